@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/kKar1503/rewired-server-2024/internal/api"
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+	"github.com/kKar1503/rewired-server-2024/internal/settings"
+	"gorm.io/gorm"
+)
+
+// apitoken issues a new api token to a user of the database, replacing the token the user had, and prints the token.
+//
+// Only the hash of the token is stored, so the token cannot be printed again.
+func main() {
+	var userName string
+
+	flag.StringVar(&settings.Get().DBPath, "db", "", "path of the sqlite database of the user")
+	flag.StringVar(&userName, "user", "", "name of the user to issue the token to")
+	flag.Parse()
+
+	if settings.Get().DBPath == "" || userName == "" {
+		fmt.Fprintln(os.Stderr, "-db and -user are required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := db.Init(settings.Get().DBPath); err != nil {
+		slog.Error("failed to init the database", "error", err)
+		os.Exit(1)
+	}
+
+	user := &db.User{}
+	result := db.Get().Where(&db.User{Name: userName}).First(user)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		slog.Error("user not found", "user", userName)
+		os.Exit(1)
+	}
+	if result.Error != nil {
+		slog.Error("failed to retrieve the user", "error", result.Error, "user", userName)
+		os.Exit(1)
+	}
+
+	token, tokenHash, err := api.NewToken()
+	if err != nil {
+		slog.Error("failed to generate the token", "error", err)
+		os.Exit(1)
+	}
+
+	if err := db.Get().Model(user).Update("token_hash", tokenHash).Error; err != nil {
+		slog.Error("failed to store the token", "error", err, "user", userName)
+		os.Exit(1)
+	}
+
+	fmt.Println(token)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+	"github.com/kKar1503/rewired-server-2024/internal/population"
+	"github.com/kKar1503/rewired-server-2024/internal/settings"
+)
+
+// rebuild recomputes the population of every room from the event log of the database, printing the rebuilt populations
+// against the stored ones, and replaces the stored populations with -apply.
+//
+// The rooms are assumed to be empty at -since, such as at the last midnight reset.
+func main() {
+	var since, until string
+	var apply bool
+
+	flag.StringVar(&settings.Get().DBPath, "db", "", "path of the sqlite database to rebuild the populations of")
+	flag.StringVar(&since, "since", "", "rebuild from this time, when every room is empty, in RFC 3339; from the start when empty")
+	flag.StringVar(&until, "until", "", "rebuild up to this time, in RFC 3339; up to now when empty")
+	flag.BoolVar(&apply, "apply", false, "replace the stored populations with the rebuilt populations")
+	flag.Parse()
+
+	if settings.Get().DBPath == "" {
+		fmt.Fprintln(os.Stderr, "-db is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	sinceTime, err := parseTime(since)
+	if err != nil {
+		slog.Error("invalid -since time", "error", err)
+		os.Exit(2)
+	}
+
+	untilTime, err := parseTime(until)
+	if err != nil {
+		slog.Error("invalid -until time", "error", err)
+		os.Exit(2)
+	}
+
+	if err := db.Init(settings.Get().DBPath); err != nil {
+		slog.Error("failed to init the database", "error", err)
+		os.Exit(1)
+	}
+
+	diffs, err := population.Rebuild(sinceTime, untilTime)
+	if err != nil {
+		slog.Error("failed to rebuild the populations", "error", err)
+		os.Exit(1)
+	}
+
+	for _, diff := range diffs {
+		marker := ""
+		if diff.Changed() {
+			marker = " *"
+		}
+		fmt.Printf(
+			"room %d %q: stored %d (net %d), rebuilt %d%s\n",
+			diff.RoomID,
+			diff.Name,
+			diff.Stored,
+			diff.StoredNet,
+			diff.Rebuilt,
+			marker,
+		)
+	}
+
+	if !apply {
+		return
+	}
+
+	if err := population.ApplyRebuild(diffs, nil); err != nil {
+		slog.Error("failed to apply the rebuilt populations", "error", err)
+		os.Exit(1)
+	}
+
+	slog.Info("applied the rebuilt populations")
+}
+
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/capture"
+	"github.com/kKar1503/rewired-server-2024/internal/clockskew"
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+	"github.com/kKar1503/rewired-server-2024/internal/devicelog"
+	"github.com/kKar1503/rewired-server-2024/internal/doorpass"
+	"github.com/kKar1503/rewired-server-2024/internal/gateconnection"
+	"github.com/kKar1503/rewired-server-2024/internal/packet"
+	"github.com/kKar1503/rewired-server-2024/internal/packetpass"
+	"github.com/kKar1503/rewired-server-2024/internal/settings"
+	"gorm.io/gorm"
+)
+
+// replay feeds the packets of a capture recorded by the server with -capture back into the packet pipeline, against a
+// scratch database that is usually a copy of the server database from before the capture started.
+//
+// The packets keep the time they were originally received, which the pipeline uses for the door passes, so a replay
+// gives the same counts regardless of its speed.
+func main() {
+	var capturePath, from, to string
+	var speed float64
+	var resetCounters bool
+
+	flag.StringVar(&capturePath, "capture", "", "path of the capture to replay")
+	flag.StringVar(&settings.Get().DBPath, "db", "", "path of the scratch sqlite database to replay against")
+	flag.Float64Var(&speed, "speed", 0, "speed of the replay relative to real time, as fast as possible when 0")
+	flag.StringVar(&from, "from", "", "only replay the packets received from this time, in RFC 3339")
+	flag.StringVar(&to, "to", "", "only replay the packets received before this time, in RFC 3339")
+	flag.UintVar(&settings.Get().Workers, "workers", 1, "number of workers that handle the packets, more than 1 may reorder packets across doors")
+	flag.BoolVar(&settings.Get().AllowLegacyDevices, "allowlegacy", true, "allow devices without a secret to send unauthenticated packets")
+	flag.DurationVar(&settings.Get().MaxClockSkew, "maxclockskew", clockskew.DEFAULT_MAX_SKEW, "clock skew of a gate beyond which the receive time is used instead of the trigger time of its statuses")
+	flag.StringVar(&settings.Get().DoorPass, "doorpass", doorpass.VERSION_1, "version of the door pass detection, either v1 or v2")
+	flag.BoolVar(&resetCounters, "resetcounters", true, "reset the authentication counters of the devices, so signed packets are not rejected as replays")
+	flag.Parse()
+
+	if capturePath == "" || settings.Get().DBPath == "" {
+		fmt.Fprintln(os.Stderr, "both -capture and -db are required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	fromTime, err := parseTime(from)
+	if err != nil {
+		slog.Error("invalid -from time", "error", err)
+		os.Exit(2)
+	}
+
+	toTime, err := parseTime(to)
+	if err != nil {
+		slog.Error("invalid -to time", "error", err)
+		os.Exit(2)
+	}
+
+	file, err := os.Open(capturePath)
+	if err != nil {
+		slog.Error("failed to open the capture", "error", err)
+		os.Exit(1)
+	}
+	defer file.Close()
+
+	reader, err := capture.NewReader(file)
+	if err != nil {
+		slog.Error("failed to read the capture", "error", err)
+		os.Exit(1)
+	}
+
+	settings.Get().QueueDepth = 64
+	settings.Get().QueuePolicy = "block"
+
+	if err := initPipeline(resetCounters); err != nil {
+		slog.Error("failed to init the pipeline", "error", err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	pipelineCtx, stopPipeline := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	packetsEgress := make(chan *packet.RawPacket)
+	debugEgress := make(chan []byte)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(debugEgress)
+		packetpass.PacketPasser(pipelineCtx, packetsEgress, debugEgress)
+	}()
+
+	// the device logs are inserted throughout the replay, as the buffer drops the logs beyond what it holds
+	logsCtx, stopLogs := context.WithCancel(context.Background())
+	logsDone := make(chan struct{})
+	go func() {
+		defer close(logsDone)
+		devicelog.Get().Run(logsCtx, devicelog.DEFAULT_FLUSH_INTERVAL)
+	}()
+
+	// the debug output is discarded, while still being drained for the workers that are handling the last packets
+	go func() {
+		for range debugEgress {
+		}
+	}()
+
+	replayed, err := replay(ctx, reader, packetsEgress, speed, fromTime, toTime)
+	if err != nil {
+		slog.Error("stopped replay", "error", err)
+	}
+
+	// the pool handles every packet that was queued before returning
+	stopPipeline()
+	wg.Wait()
+
+	stopLogs()
+	<-logsDone
+
+	if err := devicelog.Get().Flush(); err != nil {
+		slog.Error("failed to flush the device logs", "error", err)
+	}
+
+	slog.Info("finished replay", "packets", replayed)
+	printPopulations()
+
+	// the rebuilds of the populations count the device logs, so a replay that lost some of them is not usable
+	if dropped := devicelog.Get().Dropped() + int64(devicelog.Get().Len()); dropped > 0 {
+		slog.Error("device logs were dropped during the replay", "count", dropped)
+		os.Exit(1)
+	}
+}
+
+func initPipeline(resetCounters bool) error {
+	if err := db.Init(settings.Get().DBPath); err != nil {
+		return err
+	}
+
+	if resetCounters {
+		result := db.Get().Session(&gorm.Session{AllowGlobalUpdate: true}).Model(&db.Device{}).Update("auth_counter", 0)
+		if result.Error != nil {
+			return result.Error
+		}
+	}
+
+	if err := devicelog.Init(devicelog.DEFAULT_BATCH_SIZE); err != nil {
+		return err
+	}
+
+	clockskew.Init(settings.Get().MaxClockSkew)
+
+	if err := gateconnection.Init(); err != nil {
+		return err
+	}
+
+	return doorpass.Init(settings.Get().DoorPass)
+}
+
+// replay passes the packets of the capture received between from and to into the packetsEgress, paced by the time they
+// were received divided by the speed, returning the number of packets replayed.
+func replay(
+	ctx context.Context,
+	reader *capture.Reader,
+	packetsEgress chan<- *packet.RawPacket,
+	speed float64,
+	from, to time.Time,
+) (int, error) {
+	replayed := 0
+	var firstReceivedAt, start time.Time
+
+	for {
+		rawPacket, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return replayed, nil
+		}
+		if err != nil {
+			return replayed, err
+		}
+
+		if !from.IsZero() && rawPacket.ReceivedAt.Before(from) {
+			continue
+		}
+		if !to.IsZero() && !rawPacket.ReceivedAt.Before(to) {
+			return replayed, nil
+		}
+
+		if speed > 0 {
+			if start.IsZero() {
+				firstReceivedAt, start = rawPacket.ReceivedAt, time.Now()
+			}
+
+			offset := time.Duration(float64(rawPacket.ReceivedAt.Sub(firstReceivedAt)) / speed)
+			select {
+			case <-ctx.Done():
+				return replayed, ctx.Err()
+			case <-time.After(time.Until(start.Add(offset))):
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return replayed, ctx.Err()
+		case packetsEgress <- rawPacket:
+			replayed++
+		}
+	}
+}
+
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func printPopulations() {
+	rooms := []db.Room{}
+	result := db.Get().Preload("RoomPopulation").Order("id").Find(&rooms)
+	if result.Error != nil {
+		slog.Error("failed to find the rooms", "error", result.Error)
+		return
+	}
+
+	for _, room := range rooms {
+		fmt.Printf("room %d %q: %d\n", room.ID, room.Name, room.RoomPopulation.Population)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"log"
@@ -12,39 +13,77 @@ import (
 	"sync"
 	"time"
 
+	"github.com/kKar1503/rewired-server-2024/internal/api"
+	"github.com/kKar1503/rewired-server-2024/internal/clockskew"
 	"github.com/kKar1503/rewired-server-2024/internal/db"
-	"github.com/kKar1503/rewired-server-2024/internal/doorpass/v1"
+	"github.com/kKar1503/rewired-server-2024/internal/devicelog"
+	"github.com/kKar1503/rewired-server-2024/internal/doorpass"
+	"github.com/kKar1503/rewired-server-2024/internal/doorpass/timing"
+	"github.com/kKar1503/rewired-server-2024/internal/downlink"
 	"github.com/kKar1503/rewired-server-2024/internal/gateconnection"
+	"github.com/kKar1503/rewired-server-2024/internal/history"
 	"github.com/kKar1503/rewired-server-2024/internal/packet"
 	"github.com/kKar1503/rewired-server-2024/internal/packetpass"
+	"github.com/kKar1503/rewired-server-2024/internal/schedule"
 	"github.com/kKar1503/rewired-server-2024/internal/settings"
 	"github.com/kKar1503/rewired-server-2024/internal/tcp"
+	"github.com/kKar1503/rewired-server-2024/internal/udp"
 	"github.com/kKar1503/rewired-server-2024/internal/ws"
 )
 
 func main() {
+	flag.StringVar(&settings.Get().DBPath, "db", "rewired.db", "path of the sqlite database")
 	flag.UintVar(&settings.Get().TCPPort, "tcpport", 42069, "port number that the tcp server will serve in")
+	flag.UintVar(&settings.Get().UDPPort, "udpport", 0, "port number that the udp server will serve in, disabled when 0")
 	flag.UintVar(&settings.Get().WSPort, "wsport", 80, "port number that the tcp server will serve in")
 	flag.StringVar(&settings.Get().Origins, "origins", "*", "the origins that is allowed on the server, seprated by commas")
+	flag.BoolVar(&settings.Get().AllowLegacyDevices, "allowlegacy", true, "allow devices without a secret to send unauthenticated packets")
+	flag.DurationVar(&settings.Get().CommandTimeout, "commandtimeout", 30*time.Second, "duration before an unacked command to a device times out")
+	flag.StringVar(&settings.Get().TLSCert, "tlscert", "", "path of the tls certificate of the tcp server, enables tls with -tlskey")
+	flag.StringVar(&settings.Get().TLSKey, "tlskey", "", "path of the tls key of the tcp server, enables tls with -tlscert")
+	flag.StringVar(&settings.Get().TLSClientCA, "tlsclientca", "", "path of the ca certificates of the device client certificates, enables mutual tls")
+	flag.UintVar(&settings.Get().Workers, "workers", 4, "number of workers that handle the packets in parallel across gates")
+	flag.UintVar(&settings.Get().QueueDepth, "queuedepth", 64, "number of packets that can be queued for each worker")
+	flag.StringVar(&settings.Get().QueuePolicy, "queuepolicy", "block", "policy when a worker queue is full, either block or drop")
+	flag.UintVar(&settings.Get().LogBatchSize, "logbatchsize", devicelog.DEFAULT_BATCH_SIZE, "number of device logs inserted in a batch")
+	flag.DurationVar(&settings.Get().LogFlushInterval, "logflushinterval", devicelog.DEFAULT_FLUSH_INTERVAL, "interval the device logs are inserted at when the batch is not full")
+	flag.DurationVar(&settings.Get().AllowanceFrame, "allowanceframe", timing.ALLOWANCE_FRAME, "default duration a gate can be inactive for before it is considered blocked")
+	flag.DurationVar(&settings.Get().PassFrame, "passframe", timing.PASS_FRAME, "default duration between the blocks of the gates of a door for them to be a pass")
+	flag.DurationVar(&settings.Get().MaxClockSkew, "maxclockskew", clockskew.DEFAULT_MAX_SKEW, "clock skew of a gate beyond which the receive time is used instead of the trigger time of its statuses")
+	flag.StringVar(&settings.Get().DoorPass, "doorpass", doorpass.VERSION_1, "version of the door pass detection, either v1 or v2")
+	flag.StringVar(&settings.Get().CapturePath, "capture", "", "path of the file to capture every received packet into, for cmd/replay")
 	flag.Parse()
 
 	log.SetOutput(os.Stdout)
 	log.SetPrefix("\n")
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
-	err := db.Init()
+	if _, err := packetpass.ParseQueuePolicy(settings.Get().QueuePolicy); err != nil {
+		slog.Error("invalid queue policy", "error", err, "policy", settings.Get().QueuePolicy)
+		os.Exit(1)
+	}
+
+	err := db.Init(settings.Get().DBPath)
 	if err != nil {
 		slog.Error("failed to create db", "error", err)
 		os.Exit(1)
 	}
 
+	err = devicelog.Init(int(settings.Get().LogBatchSize))
+	if err != nil {
+		slog.Error("failed to init devicelog", "error", err)
+		os.Exit(1)
+	}
+
 	err = gateconnection.Init()
 	if err != nil {
 		slog.Error("failed to init gateconnection", "error", err)
 		os.Exit(1)
 	}
 
-	err = doorpass.Init()
+	clockskew.Init(settings.Get().MaxClockSkew)
+
+	err = doorpass.Init(settings.Get().DoorPass)
 	if err != nil {
 		slog.Error("failed to init doorpass", "error", err)
 		os.Exit(1)
@@ -72,6 +111,34 @@ func main() {
 		packetpass.ServerStatusPasser(ctx, wsEgress)
 	}()
 
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// insert the device logs of the handled packets in batches
+		devicelog.Get().Run(ctx, settings.Get().LogFlushInterval)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// time out the commands sent to the devices that were never acked
+		downlink.Run(ctx)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// reset the populations of the rooms on their schedules
+		schedule.Run(ctx)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// compact the occupancy snapshots into the minute, hour and day aggregates
+		history.Run(ctx)
+	}()
+
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
@@ -80,6 +147,26 @@ func main() {
 
 		http.HandleFunc("/debug", wsServer.ServeDebugWS)
 		http.HandleFunc("/ws", wsServer.ServeWS)
+		// the api is read by anyone, but only changed by the users with an api token
+		http.HandleFunc("/api/commands", api.AuthenticatedChanges(api.ServeCommands))
+		http.HandleFunc("/api/commands/", api.AuthenticatedChanges(api.ServeCommands))
+		http.HandleFunc("/api/doors", api.AuthenticatedChanges(api.ServeDoors))
+		http.HandleFunc("/api/doors/", api.AuthenticatedChanges(api.ServeDoors))
+		http.HandleFunc("/api/deadletters", api.AuthenticatedChanges(api.ServeDeadLetters(packetsEgress)))
+		http.HandleFunc("/api/deadletters/", api.AuthenticatedChanges(api.ServeDeadLetters(packetsEgress)))
+		http.HandleFunc("/api/populations/", api.Authenticated(api.ServePopulations))
+		http.HandleFunc("/api/rooms", api.AuthenticatedChanges(api.ServeRooms))
+		http.HandleFunc("/api/rooms/", api.AuthenticatedChanges(api.ServeRooms))
+		http.HandleFunc("/api/zones", api.AuthenticatedChanges(api.ServeZones))
+		http.HandleFunc("/api/zones/", api.AuthenticatedChanges(api.ServeZones))
+		http.HandleFunc("/api/history", api.ServeHistory)
+		http.HandleFunc("/api/alerts", api.ServeAlerts)
+		http.HandleFunc("/api/schedules", api.AuthenticatedChanges(api.ServeSchedules))
+		http.HandleFunc("/api/schedules/", api.AuthenticatedChanges(api.ServeSchedules))
+		http.HandleFunc("/api/occupancies", api.AuthenticatedChanges(api.ServeOccupancies))
+		http.HandleFunc("/api/occupancies/", api.AuthenticatedChanges(api.ServeOccupancies))
+		http.HandleFunc("/api/corrections", api.Authenticated(api.ServeCorrections))
+		http.HandleFunc("/api/corrections/", api.Authenticated(api.ServeCorrections))
 
 		go func() {
 			<-ctx.Done()
@@ -100,10 +187,23 @@ func main() {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		server, err := tcp.NewTCPServer(uint16(settings.Get().TCPPort), packetsEgress)
+		var tlsConfig *tls.Config
+		// the client ca alone must not silently fall back to plaintext, so it also fails without the certificate and key
+		if settings.Get().TLSCert != "" || settings.Get().TLSKey != "" || settings.Get().TLSClientCA != "" {
+			var err error
+			tlsConfig, err = tcp.LoadTLSConfig(settings.Get().TLSCert, settings.Get().TLSKey, settings.Get().TLSClientCA)
+			if err != nil {
+				slog.Error("failed to load tls config", "error", err)
+				stop()
+				return
+			}
+		}
+
+		server, err := tcp.NewTCPServer(uint16(settings.Get().TCPPort), tlsConfig, packetsEgress)
 		if err != nil {
 			slog.Error("server failed to start", "error", err)
 			stop()
+			return
 		}
 
 		go func() {
@@ -118,8 +218,41 @@ func main() {
 		slog.Info("exiting tcp serving")
 	}()
 
+	if settings.Get().UDPPort != 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if settings.Get().TLSClientCA != "" {
+				slog.Warn("udp packets are not bound to client certificates, only packet authentication applies")
+			}
+
+			server, err := udp.NewUDPServer(uint16(settings.Get().UDPPort), packetsEgress)
+			if err != nil {
+				slog.Error("server failed to start", "error", err)
+				stop()
+				return
+			}
+
+			go func() {
+				<-ctx.Done()
+				server.Close()
+			}()
+
+			slog.Info("starting udp server", "port", settings.Get().UDPPort)
+
+			server.Start()
+			slog.Info("exiting udp serving")
+		}()
+	}
+
 	<-ctx.Done()
 	wg.Wait()
+
+	// the workers have handled every queued packet by now, so the last of their device logs can be inserted
+	if err := devicelog.Get().Flush(); err != nil {
+		slog.Error("failed to flush the device logs", "error", err)
+	}
+
 	time.Sleep(1 * time.Second)
 	os.Exit(1)
 }
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"log/slog"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/packet"
+)
+
+// The virtual gate, which reports its IR beam as unblocked every status interval while nothing is in the way.
+type gate struct {
+	gateID uint16
+	config *config
+	rand   *rand.Rand
+	// the durations the beam is blocked for by the people walking through the door
+	blocks chan time.Duration
+
+	writeMu sync.Mutex
+	conn    net.Conn
+	counter uint32
+}
+
+func newGate(gateID uint16, config *config, seed int64) *gate {
+	return &gate{
+		gateID:  gateID,
+		config:  config,
+		rand:    rand.New(rand.NewSource(seed)),
+		blocks:  make(chan time.Duration, 4),
+		counter: config.counter,
+	}
+}
+
+// run connects the gate to the server and reports its status until the context is done, reconnecting whenever the
+// connection is lost or the gate simulates a disconnect.
+func (g *gate) run(ctx context.Context) {
+	for {
+		if err := g.connect(ctx); err != nil {
+			slog.Error("failed to connect gate", "error", err, "gateID", g.gateID)
+		} else {
+			g.report(ctx)
+			g.writeMu.Lock()
+			g.conn.Close()
+			g.writeMu.Unlock()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(g.config.reconnectDelay):
+		}
+	}
+}
+
+func (g *gate) connect(ctx context.Context) error {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", g.config.addr)
+	if err != nil {
+		return err
+	}
+
+	g.writeMu.Lock()
+	g.conn = conn
+	g.writeMu.Unlock()
+
+	go g.readCommands(conn)
+
+	slog.Info("gate connected", "gateID", g.gateID)
+	return g.sendStatus(packet.GateStatusTurnOn)
+}
+
+// report sends the heartbeats and statuses of the gate until the context is done, the connection fails, or the gate
+// simulates a disconnect.
+func (g *gate) report(ctx context.Context) {
+	heartbeatTicker := time.NewTicker(g.config.heartbeatInterval)
+	defer heartbeatTicker.Stop()
+
+	statusTicker := time.NewTicker(g.config.statusInterval)
+	defer statusTicker.Stop()
+
+	var silentUntil time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-heartbeatTicker.C:
+			if g.rand.Float64() < g.config.disconnectRate {
+				slog.Info("gate disconnecting", "gateID", g.gateID)
+				return
+			}
+
+			if err := g.sendHeartbeat(); err != nil {
+				slog.Error("failed to send heartbeat", "error", err, "gateID", g.gateID)
+				return
+			}
+
+		case duration := <-g.blocks:
+			if err := g.sendStatus(packet.GateStatusBlocked); err != nil {
+				slog.Error("failed to send status", "error", err, "gateID", g.gateID)
+				return
+			}
+			silentUntil = latest(silentUntil, time.Now().Add(duration))
+
+		case <-statusTicker.C:
+			if time.Now().Before(silentUntil) {
+				continue
+			}
+
+			status := packet.GateStatusUnblocked
+			if g.rand.Float64() < g.config.faultRate {
+				// a faulty beam stays silent for a while, as if the emitter was misaligned
+				status = packet.GateStatusFaulty
+				silentUntil = time.Now().Add(g.config.faultDuration)
+				slog.Info("gate faulty", "gateID", g.gateID)
+			}
+
+			if err := g.sendStatus(status); err != nil {
+				slog.Error("failed to send status", "error", err, "gateID", g.gateID)
+				return
+			}
+		}
+	}
+}
+
+// readCommands acknowledges the commands the server sends down to the gate, until the connection is closed.
+func (g *gate) readCommands(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	for {
+		rawPacket := &packet.RawPacket{}
+		if err := rawPacket.ReadPackets(reader); err != nil {
+			return
+		}
+
+		if !rawPacket.PacketType.IsCommand() {
+			continue
+		}
+
+		commandPacket := &packet.CommandPacket{}
+		if err := commandPacket.Parse(rawPacket); err != nil {
+			slog.Error("failed to parse command", "error", err, "gateID", g.gateID)
+			continue
+		}
+
+		slog.Info("gate received command",
+			"gateID",
+			g.gateID,
+			"command",
+			commandPacket.PacketType,
+			"argument",
+			commandPacket.Argument,
+		)
+
+		ackPacket := &packet.AckPacket{
+			RawPacket: packet.RawPacket{Version: packet.VERSION_2, PacketType: packet.PacketTypeAck},
+		}
+		ackPacket.SetGateID(g.gateID)
+		ackPacket.SetAckedPacketType(commandPacket.PacketType)
+		ackPacket.SetSequence(commandPacket.Sequence)
+		if err := g.write(&ackPacket.RawPacket); err != nil {
+			slog.Error("failed to send ack", "error", err, "gateID", g.gateID)
+			return
+		}
+	}
+}
+
+func (g *gate) sendHeartbeat() error {
+	heartbeatPacket := &packet.HeartbeatPacket{
+		RawPacket: packet.RawPacket{Version: g.config.version, PacketType: packet.PacketTypeHeartbeat},
+	}
+	heartbeatPacket.SetGateID(g.gateID)
+
+	return g.write(&heartbeatPacket.RawPacket)
+}
+
+func (g *gate) sendStatus(status packet.GateStatus) error {
+	gateStatusPacket := &packet.GateStatusPacket{
+		RawPacket: packet.RawPacket{Version: g.config.version, PacketType: packet.PacketTypeGateStatus},
+	}
+	gateStatusPacket.SetGateID(g.gateID)
+	gateStatusPacket.SetStatus(status)
+	if err := gateStatusPacket.SetTimestamp(time.Now()); err != nil {
+		return err
+	}
+
+	return g.write(&gateStatusPacket.RawPacket)
+}
+
+// write signs the packet when the gates have a secret, and writes it to the connection.
+func (g *gate) write(rawPacket *packet.RawPacket) error {
+	g.writeMu.Lock()
+	defer g.writeMu.Unlock()
+
+	if g.config.secret != nil {
+		g.counter++
+		if err := rawPacket.Sign(g.config.secret, g.counter); err != nil {
+			return err
+		}
+	}
+
+	data, err := rawPacket.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	if err := g.conn.SetWriteDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		return err
+	}
+
+	_, err = g.conn.Write(data)
+	return err
+}
+
+func latest(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/packet"
+)
+
+// The beams of both gates are blocked by a person walking through the door for between BLOCK_DURATION and
+// BLOCK_DURATION + BLOCK_JITTER, with the person reaching the second gate STEP_DELAY (± STEP_JITTER / 2) after the
+// first. The step is longer than the default status interval, so the first gate is always clear before the second.
+const (
+	BLOCK_DURATION = 600 * time.Millisecond
+	BLOCK_JITTER   = 300 * time.Millisecond
+	STEP_DELAY     = 400 * time.Millisecond
+	STEP_JITTER    = 100 * time.Millisecond
+)
+
+type config struct {
+	addr              string
+	version           byte
+	secret            []byte
+	counter           uint32
+	heartbeatInterval time.Duration
+	statusInterval    time.Duration
+	walkInterval      time.Duration
+	walkGap           time.Duration
+	enterRatio        float64
+	faultRate         float64
+	faultDuration     time.Duration
+	disconnectRate    float64
+	reconnectDelay    time.Duration
+}
+
+// simulator opens a TCP connection to the server for every gate of the simulated doors, each door being a pair of an
+// inner and an outer gate, and walks people through the doors in either direction.
+//
+// Door i is made of the inner gate firstgate + 2i and the outer gate firstgate + 2i + 1, which must be registered as a
+// door of the outer gate and then the inner gate on the server for the passes to be counted.
+func main() {
+	cfg := &config{}
+	var doors, firstGate, version uint
+	var secret string
+	var seed int64
+	var duration time.Duration
+
+	flag.StringVar(&cfg.addr, "addr", "localhost:42069", "address of the tcp server of the server")
+	flag.UintVar(&doors, "doors", 1, "number of doors to simulate, each with an inner and an outer gate")
+	flag.UintVar(&firstGate, "firstgate", 1, "gate ID of the inner gate of the first door")
+	flag.UintVar(&version, "version", uint(packet.VERSION_3), "version of the packets the gates send")
+	flag.StringVar(&secret, "secret", "", "hex encoded secret every gate signs its packets with, packets are not signed when empty")
+	flag.Func("counter", "counter of the first signed packet of every gate, defaults to the unix time", func(s string) error {
+		_, err := fmt.Sscan(s, &cfg.counter)
+		return err
+	})
+	flag.DurationVar(&cfg.heartbeatInterval, "heartbeat", 10*time.Second, "interval between the heartbeats of a gate")
+	flag.DurationVar(&cfg.statusInterval, "statusinterval", 200*time.Millisecond, "interval between the unblocked statuses of a gate with a clear beam")
+	flag.DurationVar(&cfg.walkInterval, "walkinterval", 5*time.Second, "mean interval between people walking through a door on top of the walkgap")
+	flag.DurationVar(&cfg.walkGap, "walkgap", 1500*time.Millisecond, "minimum interval between people walking through a door, lower to simulate tailgating")
+	flag.Float64Var(&cfg.enterRatio, "enterratio", 0.5, "probability that a person walks into the inner room rather than out of it")
+	flag.Float64Var(&cfg.faultRate, "faultrate", 0, "probability that a status of a gate is faulty")
+	flag.DurationVar(&cfg.faultDuration, "faultduration", 3*time.Second, "duration a faulty gate stays silent for")
+	flag.Float64Var(&cfg.disconnectRate, "disconnectrate", 0, "probability that a gate disconnects instead of sending a heartbeat")
+	flag.DurationVar(&cfg.reconnectDelay, "reconnectdelay", 5*time.Second, "delay before a disconnected gate reconnects")
+	flag.Int64Var(&seed, "seed", time.Now().UnixNano(), "seed of the randomness of the simulation")
+	flag.DurationVar(&duration, "duration", 0, "duration of the simulation, runs until interrupted when 0")
+	flag.Parse()
+
+	if version < uint(packet.VERSION_1) || version > uint(packet.VERSION_3) {
+		slog.Error("unsupported packet version", "version", version)
+		os.Exit(2)
+	}
+	cfg.version = byte(version)
+
+	if secret != "" {
+		if cfg.version < packet.VERSION_2 {
+			slog.Error("signed packets require version 2")
+			os.Exit(2)
+		}
+
+		var err error
+		if cfg.secret, err = hex.DecodeString(secret); err != nil {
+			slog.Error("invalid secret", "error", err)
+			os.Exit(2)
+		}
+	}
+
+	if cfg.counter == 0 {
+		cfg.counter = uint32(time.Now().Unix())
+	}
+
+	if doors == 0 || firstGate == 0 || firstGate+2*doors-1 > 0xFFFF {
+		slog.Error("invalid doors", "doors", doors, "firstGate", firstGate)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, duration)
+		defer cancel()
+	}
+
+	slog.Info("starting simulation", "addr", cfg.addr, "doors", doors, "firstGate", firstGate, "seed", seed)
+
+	wg := &sync.WaitGroup{}
+	for i := uint(0); i < doors; i++ {
+		innerGateID := uint16(firstGate + 2*i)
+		inner := newGate(innerGateID, cfg, seed+int64(innerGateID))
+		outer := newGate(innerGateID+1, cfg, seed+int64(innerGateID+1))
+
+		for _, g := range []*gate{inner, outer} {
+			wg.Add(1)
+			go func(g *gate) {
+				defer wg.Done()
+				g.run(ctx)
+			}(g)
+		}
+
+		wg.Add(1)
+		go func(door int64) {
+			defer wg.Done()
+			walk(ctx, cfg, rand.New(rand.NewSource(seed-door)), inner, outer)
+		}(int64(i))
+	}
+
+	wg.Wait()
+	slog.Info("finished simulation")
+}
+
+// walk walks people through the door at random intervals of at least the walk gap, blocking the beam of the first gate and then the beam of
+// the second gate of their direction, with the blocks overlapping as they would for a person stepping through.
+func walk(ctx context.Context, cfg *config, r *rand.Rand, inner, outer *gate) {
+	for {
+		interval := cfg.walkGap + time.Duration(r.ExpFloat64()*float64(cfg.walkInterval))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		first, second, direction := outer, inner, "in"
+		if r.Float64() >= cfg.enterRatio {
+			first, second, direction = inner, outer, "out"
+		}
+
+		slog.Info("person walking", "direction", direction, "innerGateID", inner.gateID, "outerGateID", outer.gateID)
+
+		duration := BLOCK_DURATION + time.Duration(r.Int63n(int64(BLOCK_JITTER)))
+		block(first, duration)
+
+		step := STEP_DELAY - STEP_JITTER/2 + time.Duration(r.Int63n(int64(STEP_JITTER)))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(step):
+		}
+
+		block(second, duration)
+	}
+}
+
+// block blocks the beam of the gate, unless the gate is busy with too many blocks, such as while it is disconnected.
+func block(g *gate, duration time.Duration) {
+	select {
+	case g.blocks <- duration:
+	default:
+	}
+}
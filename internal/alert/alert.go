@@ -0,0 +1,56 @@
+package alert
+
+import (
+	"expvar"
+	"log/slog"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+	"gorm.io/gorm"
+)
+
+var alertsRaised = expvar.NewMap("alerts_raised")
+
+// The type of a db.Alert.
+type Type uint8
+
+const (
+	TypeNegativePopulation Type = iota + 1
+	TypeCapacityWarning
+	TypeOverCapacity
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeNegativePopulation:
+		return "negative-population"
+	case TypeCapacityWarning:
+		return "capacity-warning"
+	case TypeOverCapacity:
+		return "over-capacity"
+	default:
+		return "unknown"
+	}
+}
+
+// Raise records the alert within the transaction, so the alert is only raised when the change that raised it is
+// committed.
+func Raise(tx *gorm.DB, alert *db.Alert) error {
+	if err := tx.Create(alert).Error; err != nil {
+		return err
+	}
+
+	alertType := Type(alert.AlertType)
+	alertsRaised.Add(alertType.String(), 1)
+	slog.Warn("alert raised",
+		"type",
+		alertType,
+		"roomID",
+		alert.RoomID,
+		"doorID",
+		alert.DoorID,
+		"population",
+		alert.Population,
+	)
+
+	return nil
+}
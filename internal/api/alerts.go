@@ -0,0 +1,96 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/alert"
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+)
+
+var ErrUnknownAlertType = errors.New("unknown alert type")
+
+var alertTypes = map[string]alert.Type{
+	alert.TypeNegativePopulation.String(): alert.TypeNegativePopulation,
+	alert.TypeCapacityWarning.String():    alert.TypeCapacityWarning,
+	alert.TypeOverCapacity.String():       alert.TypeOverCapacity,
+}
+
+type AlertResponse struct {
+	ID          uint      `json:"id"`
+	Type        string    `json:"type"`
+	RoomID      uint      `json:"roomId"`
+	DoorID      *uint     `json:"doorId"`
+	PassEventID *uint     `json:"passEventId"`
+	Population  int32     `json:"population"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// ServeAlerts serves the alerts raised on the rooms.
+//
+//   - GET /api/alerts lists the latest alerts, optionally filtered by the roomId, doorId and type queries.
+func ServeAlerts(w http.ResponseWriter, r *http.Request) {
+	_, hasID, err := pathID(r, "/api/alerts")
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && !hasID:
+		listAlerts(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func listAlerts(w http.ResponseWriter, r *http.Request) {
+	query := db.Get().Order("id DESC").Limit(100)
+
+	for _, filter := range []struct{ query, column string }{{"roomId", "room_id"}, {"doorId", "door_id"}} {
+		value := r.URL.Query().Get(filter.query)
+		if value == "" {
+			continue
+		}
+
+		id, err := strconv.ParseUint(value, 10, 0)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		query = query.Where(filter.column+" = ?", id)
+	}
+
+	if typeQuery := r.URL.Query().Get("type"); typeQuery != "" {
+		alertType, ok := alertTypes[typeQuery]
+		if !ok {
+			writeError(w, http.StatusBadRequest, ErrUnknownAlertType)
+			return
+		}
+		query = query.Where("alert_type = ?", uint8(alertType))
+	}
+
+	alerts := []db.Alert{}
+	result := query.Find(&alerts)
+	if result.Error != nil {
+		writeError(w, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	response := make([]*AlertResponse, 0, len(alerts))
+	for _, a := range alerts {
+		response = append(response, &AlertResponse{
+			ID:          a.ID,
+			Type:        alert.Type(a.AlertType).String(),
+			RoomID:      a.RoomID,
+			DoorID:      a.DoorID,
+			PassEventID: a.PassEventID,
+			Population:  a.Population,
+			CreatedAt:   a.CreatedAt,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
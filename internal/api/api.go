@@ -0,0 +1,60 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kKar1503/rewired-server-2024/internal/packetpass"
+)
+
+var ErrInvalidID = errors.New("invalid id")
+
+// statusChanged makes the status be sent to the websocket clients immediately, which the tests replace to observe it.
+var statusChanged = packetpass.StatusChanged
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("failed to write api response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, &errorResponse{Error: err.Error()})
+}
+
+func readJSON(r *http.Request, v any) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+// pathID parses the ID that follows the prefix in the request path, returning false when the path is the prefix
+// itself.
+func pathID(r *http.Request, prefix string) (uint, bool, error) {
+	return parsePathID(r.URL.Path, prefix)
+}
+
+func parsePathID(path string, prefix string) (uint, bool, error) {
+	rest := strings.Trim(strings.TrimPrefix(path, prefix), "/")
+	if rest == "" {
+		return 0, false, nil
+	}
+
+	id, err := strconv.ParseUint(rest, 10, 0)
+	if err != nil {
+		return 0, true, ErrInvalidID
+	}
+
+	return uint(id), true, nil
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+	"gorm.io/gorm"
+)
+
+var ErrUnauthorized = errors.New("missing or invalid api token")
+
+type userContextKey struct{}
+
+// NewToken returns a random api token, and the hash of the token to store as the TokenHash of its user.
+func NewToken() (string, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+
+	token := hex.EncodeToString(buf)
+	return token, HashToken(token), nil
+}
+
+// HashToken returns the hex encoded sha256 of the api token, which is what is stored of the token.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Authenticated serves the requests with the api token of a user in the bearer Authorization header, responding 401 to
+// the other requests. The user of the token is available to the handler with requestUser.
+func Authenticated(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, ErrUnauthorized)
+			return
+		}
+
+		user := &db.User{}
+		result := db.Get().Where(&db.User{TokenHash: HashToken(token)}).First(user)
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, ErrUnauthorized)
+			return
+		}
+		if result.Error != nil {
+			writeError(w, http.StatusInternalServerError, result.Error)
+			return
+		}
+
+		handler(w, r.WithContext(context.WithValue(r.Context(), userContextKey{}, user)))
+	}
+}
+
+// AuthenticatedChanges serves the GET requests as they are, and the requests of the other methods, which change the
+// state of the server, Authenticated.
+func AuthenticatedChanges(handler http.HandlerFunc) http.HandlerFunc {
+	authenticated := Authenticated(handler)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			handler(w, r)
+			return
+		}
+
+		authenticated(w, r)
+	}
+}
+
+// requestUser returns the user of the request served by Authenticated.
+func requestUser(r *http.Request) *db.User {
+	user, _ := r.Context().Value(userContextKey{}).(*db.User)
+	return user
+}
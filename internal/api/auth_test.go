@@ -0,0 +1,161 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+)
+
+// createUser creates a user with a new api token, returning the user and the token.
+func createUser(t *testing.T, name string) (*db.User, string) {
+	t.Helper()
+
+	token, tokenHash, err := NewToken()
+	if err != nil {
+		t.Fatalf("NewToken() error = %v", err)
+	}
+
+	user := &db.User{Name: name, TokenHash: tokenHash}
+	if err := db.Get().Create(user).Error; err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	return user, token
+}
+
+// serveAs serves the request with the handler with the api token in the Authorization header, returning the recorded
+// response. The empty token sends no Authorization header.
+func serveAs(handler http.HandlerFunc, token, method, target, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	handler(recorder, request)
+	return recorder
+}
+
+func TestNewToken(t *testing.T) {
+	token, tokenHash, err := NewToken()
+	if err != nil {
+		t.Fatalf("NewToken() error = %v", err)
+	}
+
+	if len(token) != 64 {
+		t.Errorf("len(token) = %d, expected %d", len(token), 64)
+	}
+	if tokenHash != HashToken(token) {
+		t.Errorf("tokenHash = %v, expected %v", tokenHash, HashToken(token))
+	}
+	if tokenHash == token {
+		t.Errorf("tokenHash = %v, expected it to differ from the token", tokenHash)
+	}
+
+	other, _, err := NewToken()
+	if err != nil {
+		t.Fatalf("NewToken() error = %v", err)
+	}
+	if other == token {
+		t.Errorf("NewToken() = %v twice, expected different tokens", token)
+	}
+}
+
+func TestHashToken(t *testing.T) {
+	// the sha256 of "token"
+	expected := "3c469e9d6c5875d37a43f353d4f88e61fcf812c66eee3457465a40b0da4153e0"
+	if got := HashToken("token"); got != expected {
+		t.Errorf("HashToken() = %v, expected %v", got, expected)
+	}
+}
+
+func TestAuthenticated(t *testing.T) {
+	setup(t)
+
+	user, token := createUser(t, "admin")
+
+	var served *db.User
+	handler := Authenticated(func(w http.ResponseWriter, r *http.Request) {
+		served = requestUser(r)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name   string
+		header string
+		status int
+	}{
+		{"Valid Token", "Bearer " + token, http.StatusNoContent},
+		{"Missing Header", "", http.StatusUnauthorized},
+		{"Empty Token", "Bearer ", http.StatusUnauthorized},
+		{"Not Bearer", "Basic " + token, http.StatusUnauthorized},
+		{"Unknown Token", "Bearer unknown", http.StatusUnauthorized},
+		// the hash is what is stored, so it must not be accepted as the token
+		{"Token Hash", "Bearer " + user.TokenHash, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			served = nil
+
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, "/api/corrections", nil)
+			if tt.header != "" {
+				request.Header.Set("Authorization", tt.header)
+			}
+			handler(recorder, request)
+
+			if recorder.Code != tt.status {
+				t.Fatalf("status = %d, expected %d", recorder.Code, tt.status)
+			}
+
+			if tt.status != http.StatusUnauthorized {
+				if served == nil || served.ID != user.ID {
+					t.Errorf("requestUser() = %+v, expected %+v", served, user)
+				}
+				return
+			}
+
+			if served != nil {
+				t.Errorf("handler served the request of %+v, expected it not to be served", served)
+			}
+			if recorder.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Errorf("WWW-Authenticate = %q, expected %q", recorder.Header().Get("WWW-Authenticate"), "Bearer")
+			}
+		})
+	}
+}
+
+func TestAuthenticatedChanges(t *testing.T) {
+	setup(t)
+
+	_, token := createUser(t, "admin")
+
+	handler := AuthenticatedChanges(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name   string
+		method string
+		token  string
+		status int
+	}{
+		{"Get", http.MethodGet, "", http.StatusNoContent},
+		{"Post", http.MethodPost, "", http.StatusUnauthorized},
+		{"Put", http.MethodPut, "", http.StatusUnauthorized},
+		{"Delete", http.MethodDelete, "", http.StatusUnauthorized},
+		{"Post With Token", http.MethodPost, token, http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := serveAs(handler, tt.token, tt.method, "/api/rooms/1/capacity", "")
+			if response.Code != tt.status {
+				t.Fatalf("status = %d, expected %d", response.Code, tt.status)
+			}
+		})
+	}
+}
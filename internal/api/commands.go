@@ -0,0 +1,153 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+	"github.com/kKar1503/rewired-server-2024/internal/downlink"
+	"github.com/kKar1503/rewired-server-2024/internal/packet"
+	"gorm.io/gorm"
+)
+
+var ErrUnknownCommand = errors.New("unknown command")
+
+var commandTypes = map[string]packet.PacketType{
+	packet.PacketTypeReboot.String():               packet.PacketTypeReboot,
+	packet.PacketTypeSetHeartbeatInterval.String(): packet.PacketTypeSetHeartbeatInterval,
+	packet.PacketTypeSetIRSensitivity.String():     packet.PacketTypeSetIRSensitivity,
+	packet.PacketTypeIdentify.String():             packet.PacketTypeIdentify,
+}
+
+type CommandRequest struct {
+	GateID   uint16 `json:"gateId"`
+	Command  string `json:"command"`
+	Argument uint16 `json:"argument"`
+}
+
+type CommandResponse struct {
+	ID        uint       `json:"id"`
+	GateID    uint16     `json:"gateId"`
+	Command   string     `json:"command"`
+	Argument  uint16     `json:"argument"`
+	Sequence  uint16     `json:"sequence"`
+	Status    string     `json:"status"`
+	CreatedAt time.Time  `json:"createdAt"`
+	SentAt    *time.Time `json:"sentAt"`
+	AckedAt   *time.Time `json:"ackedAt"`
+}
+
+// ServeCommands serves the downlink commands sent to the devices.
+//
+//   - GET /api/commands lists the commands, optionally filtered by the gateId query.
+//   - GET /api/commands/{id} returns the command with its delivery status.
+//   - POST /api/commands queues a command to be sent to a device.
+func ServeCommands(w http.ResponseWriter, r *http.Request) {
+	id, hasID, err := pathID(r, "/api/commands")
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && hasID:
+		getCommand(w, id)
+	case r.Method == http.MethodGet:
+		listCommands(w, r)
+	case r.Method == http.MethodPost && !hasID:
+		sendCommand(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func getCommand(w http.ResponseWriter, id uint) {
+	command := &db.DeviceCommand{}
+	result := db.Get().Joins("Device").First(command, id)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		writeError(w, http.StatusNotFound, result.Error)
+		return
+	}
+	if result.Error != nil {
+		writeError(w, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newCommandResponse(command))
+}
+
+func listCommands(w http.ResponseWriter, r *http.Request) {
+	query := db.Get().Joins("Device").Order("device_commands.id DESC").Limit(100)
+
+	if gateIDQuery := r.URL.Query().Get("gateId"); gateIDQuery != "" {
+		gateID, err := strconv.ParseUint(gateIDQuery, 10, 16)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		query = query.Where("Device.gate_id = ?", gateID)
+	}
+
+	commands := []db.DeviceCommand{}
+	result := query.Find(&commands)
+	if result.Error != nil {
+		writeError(w, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	response := make([]*CommandResponse, 0, len(commands))
+	for i := range commands {
+		response = append(response, newCommandResponse(&commands[i]))
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func sendCommand(w http.ResponseWriter, r *http.Request) {
+	request := &CommandRequest{}
+	if err := readJSON(r, request); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	commandType, ok := commandTypes[request.Command]
+	if !ok {
+		writeError(w, http.StatusBadRequest, ErrUnknownCommand)
+		return
+	}
+
+	command, err := downlink.Send(request.GateID, commandType, request.Argument)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// the delivery status may have changed while sending, so the latest status is returned
+	result := db.Get().Joins("Device").First(command, command.ID)
+	if result.Error != nil {
+		writeError(w, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, newCommandResponse(command))
+}
+
+func newCommandResponse(command *db.DeviceCommand) *CommandResponse {
+	return &CommandResponse{
+		ID:        command.ID,
+		GateID:    command.Device.GateID,
+		Command:   packet.PacketType(command.CommandType).String(),
+		Argument:  command.Argument,
+		Sequence:  command.Sequence,
+		Status:    downlink.CommandStatus(command.Status).String(),
+		CreatedAt: command.CreatedAt,
+		SentAt:    command.SentAt,
+		AckedAt:   command.AckedAt,
+	}
+}
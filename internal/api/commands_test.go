@@ -0,0 +1,107 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+)
+
+// setup creates a fresh database with the device of gate 1.
+func setup(t *testing.T) {
+	t.Helper()
+
+	if err := db.Init(filepath.Join(t.TempDir(), "rewired.db")); err != nil {
+		t.Fatalf("db.Init() error = %v", err)
+	}
+
+	if err := db.Get().Create(&db.Device{GateID: 1}).Error; err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+}
+
+// serve serves the request with the handler, returning the recorded response.
+func serve(handler http.HandlerFunc, method, target, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return recorder
+}
+
+func TestServeCommands(t *testing.T) {
+	setup(t)
+
+	response := serve(ServeCommands, http.MethodPost, "/api/commands", `{"gateId": 1, "command": "identify"}`)
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("POST status = %d, expected %d", response.Code, http.StatusAccepted)
+	}
+
+	sent := &CommandResponse{}
+	if err := json.NewDecoder(response.Body).Decode(sent); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		status int
+		// the status of the command in the response, when the response is a command
+		commandStatus string
+	}{
+		{"Get", http.MethodGet, "/api/commands/" + strconv.FormatUint(uint64(sent.ID), 10), "", http.StatusOK, "queued"},
+		{"Get Unknown", http.MethodGet, "/api/commands/99", "", http.StatusNotFound, ""},
+		{"Get Invalid ID", http.MethodGet, "/api/commands/abc", "", http.StatusNotFound, ""},
+		{"List Invalid Gate ID", http.MethodGet, "/api/commands?gateId=abc", "", http.StatusBadRequest, ""},
+		{"Send Unknown Command", http.MethodPost, "/api/commands", `{"gateId": 1, "command": "explode"}`, http.StatusBadRequest, ""},
+		{"Send Unknown Device", http.MethodPost, "/api/commands", `{"gateId": 99, "command": "reboot"}`, http.StatusNotFound, ""},
+		{"Send Unknown Field", http.MethodPost, "/api/commands", `{"gateId": 1, "command": "reboot", "x": 1}`, http.StatusBadRequest, ""},
+		{"Delete", http.MethodDelete, "/api/commands/" + strconv.FormatUint(uint64(sent.ID), 10), "", http.StatusMethodNotAllowed, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := serve(ServeCommands, tt.method, tt.target, tt.body)
+			if response.Code != tt.status {
+				t.Fatalf("status = %d, expected %d", response.Code, tt.status)
+			}
+
+			if tt.commandStatus == "" {
+				return
+			}
+
+			command := &CommandResponse{}
+			if err := json.NewDecoder(response.Body).Decode(command); err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if command.Status != tt.commandStatus {
+				t.Errorf("Status = %v, expected %v", command.Status, tt.commandStatus)
+			}
+		})
+	}
+
+	t.Run("List By Gate ID", func(t *testing.T) {
+		for _, tt := range []struct {
+			gateID string
+			count  int
+		}{{"1", 1}, {"2", 0}} {
+			response := serve(ServeCommands, http.MethodGet, "/api/commands?gateId="+tt.gateID, "")
+			if response.Code != http.StatusOK {
+				t.Fatalf("status = %d, expected %d", response.Code, http.StatusOK)
+			}
+
+			commands := []CommandResponse{}
+			if err := json.NewDecoder(response.Body).Decode(&commands); err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if len(commands) != tt.count {
+				t.Errorf("len(commands) of gate %s = %d, expected %d", tt.gateID, len(commands), tt.count)
+			}
+		}
+	})
+}
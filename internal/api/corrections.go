@@ -0,0 +1,181 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+	"github.com/kKar1503/rewired-server-2024/internal/population"
+)
+
+var (
+	ErrMissingReason     = errors.New("reason is required")
+	ErrInvalidCorrection = errors.New("exactly one of population and adjustment is required")
+)
+
+var correctionSources = map[uint8]string{
+	1: "scheduled",
+	2: "manual",
+	3: "rebuild",
+}
+
+// The correction of the population of a room, which either sets the population or adjusts it by a number of people.
+type CorrectionRequest struct {
+	RoomID     uint    `json:"roomId"`
+	Population *uint32 `json:"population"`
+	Adjustment *int64  `json:"adjustment"`
+	Reason     string  `json:"reason"`
+}
+
+type CorrectionResponse struct {
+	ID               uint      `json:"id"`
+	RoomID           uint      `json:"roomId"`
+	Source           string    `json:"source"`
+	ScheduleID       *uint     `json:"scheduleId"`
+	UserID           *uint     `json:"userId"`
+	User             string    `json:"user"` // empty when the correction is not made by a user
+	OldPopulation    uint32    `json:"oldPopulation"`
+	OldNetPopulation int32     `json:"oldNetPopulation"`
+	NewPopulation    uint32    `json:"newPopulation"`
+	Reason           string    `json:"reason"`
+	Skipped          bool      `json:"skipped"`
+	CreatedAt        time.Time `json:"createdAt"`
+}
+
+// ServeCorrections serves the corrections of the populations of the rooms, and must be served Authenticated.
+//
+//   - GET /api/corrections lists the latest corrections, manual, scheduled and rebuilds, optionally filtered by the roomId
+//     query.
+//   - POST /api/corrections sets or adjusts the population of a room with a reason, on behalf of the user of the
+//     request, and sends the status to the websocket clients immediately.
+func ServeCorrections(w http.ResponseWriter, r *http.Request) {
+	_, hasID, err := pathID(r, "/api/corrections")
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && !hasID:
+		listCorrections(w, r)
+	case r.Method == http.MethodPost && !hasID:
+		createCorrection(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func listCorrections(w http.ResponseWriter, r *http.Request) {
+	query, err := filterRoomID(r, db.Get().Order("id DESC").Limit(100))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	corrections := []db.PopulationCorrection{}
+	result := query.Find(&corrections)
+	if result.Error != nil {
+		writeError(w, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	userNames := make(map[uint]string)
+	for _, correction := range corrections {
+		if correction.UserID != nil {
+			userNames[*correction.UserID] = ""
+		}
+	}
+	if len(userNames) > 0 {
+		userIDs := make([]uint, 0, len(userNames))
+		for userID := range userNames {
+			userIDs = append(userIDs, userID)
+		}
+
+		users := []db.User{}
+		if err := db.Get().Unscoped().Find(&users, userIDs).Error; err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		for _, user := range users {
+			userNames[user.ID] = user.Name
+		}
+	}
+
+	response := make([]*CorrectionResponse, 0, len(corrections))
+	for i := range corrections {
+		correctionResponse := newCorrectionResponse(&corrections[i])
+		if correctionResponse.UserID != nil {
+			correctionResponse.User = userNames[*correctionResponse.UserID]
+		}
+		response = append(response, correctionResponse)
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func createCorrection(w http.ResponseWriter, r *http.Request) {
+	request := &CorrectionRequest{}
+	if err := readJSON(r, request); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	request.Reason = strings.TrimSpace(request.Reason)
+	if request.Reason == "" {
+		writeError(w, http.StatusBadRequest, ErrMissingReason)
+		return
+	}
+	if (request.Population == nil) == (request.Adjustment == nil) {
+		writeError(w, http.StatusBadRequest, ErrInvalidCorrection)
+		return
+	}
+
+	if status, err := findRoom(request.RoomID); err != nil {
+		writeError(w, status, err)
+		return
+	}
+
+	user := requestUser(r)
+	correction := &db.PopulationCorrection{
+		RoomID: request.RoomID,
+		Source: 2,
+		UserID: &user.ID,
+		Reason: request.Reason,
+	}
+
+	var err error
+	if request.Population != nil {
+		correction.NewPopulation = *request.Population
+		err = population.Correct(correction)
+	} else {
+		err = population.Adjust(correction, *request.Adjustment)
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	statusChanged()
+
+	response := newCorrectionResponse(correction)
+	response.User = user.Name
+	writeJSON(w, http.StatusCreated, response)
+}
+
+func newCorrectionResponse(correction *db.PopulationCorrection) *CorrectionResponse {
+	return &CorrectionResponse{
+		ID:               correction.ID,
+		RoomID:           correction.RoomID,
+		Source:           correctionSources[correction.Source],
+		ScheduleID:       correction.ScheduleID,
+		UserID:           correction.UserID,
+		OldPopulation:    correction.OldPopulation,
+		OldNetPopulation: correction.OldNetPopulation,
+		NewPopulation:    correction.NewPopulation,
+		Reason:           correction.Reason,
+		Skipped:          correction.Skipped,
+		CreatedAt:        correction.CreatedAt,
+	}
+}
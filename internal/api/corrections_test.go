@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+)
+
+func TestServeCorrections(t *testing.T) {
+	setup(t)
+
+	user, token := createUser(t, "admin")
+
+	room := &db.Room{Name: "room"}
+	if err := db.Get().Create(room).Error; err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := db.Get().Create(&db.RoomPopulation{RoomID: room.ID, Population: 4, NetPopulation: 4}).Error; err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	changes := 0
+	original := statusChanged
+	statusChanged = func() { changes++ }
+	t.Cleanup(func() { statusChanged = original })
+
+	handler := Authenticated(ServeCorrections)
+
+	tests := []struct {
+		name   string
+		token  string
+		body   string
+		status int
+		// the population of the room after the request
+		population uint32
+	}{
+		{"Unauthenticated", "", `{"roomId": 1, "population": 9, "reason": "recount"}`, http.StatusUnauthorized, 4},
+		{"Missing Reason", token, `{"roomId": 1, "population": 9}`, http.StatusBadRequest, 4},
+		{"Blank Reason", token, `{"roomId": 1, "population": 9, "reason": "  "}`, http.StatusBadRequest, 4},
+		{
+			"Population And Adjustment",
+			token,
+			`{"roomId": 1, "population": 9, "adjustment": 1, "reason": "recount"}`,
+			http.StatusBadRequest,
+			4,
+		},
+		{"Neither Population Nor Adjustment", token, `{"roomId": 1, "reason": "recount"}`, http.StatusBadRequest, 4},
+		{"Unknown Room", token, `{"roomId": 99, "population": 9, "reason": "recount"}`, http.StatusNotFound, 4},
+		{"Set", token, `{"roomId": 1, "population": 9, "reason": "recount"}`, http.StatusCreated, 9},
+		{"Adjust", token, `{"roomId": 1, "adjustment": -2, "reason": "fire exit"}`, http.StatusCreated, 7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes = 0
+
+			response := serveAs(handler, tt.token, http.MethodPost, "/api/corrections", tt.body)
+			if response.Code != tt.status {
+				t.Fatalf("status = %d, expected %d", response.Code, tt.status)
+			}
+
+			roomPopulation := &db.RoomPopulation{}
+			if err := db.Get().Where(&db.RoomPopulation{RoomID: room.ID}).First(roomPopulation).Error; err != nil {
+				t.Fatalf("failed to find room population: %v", err)
+			}
+			if roomPopulation.Population != tt.population {
+				t.Errorf("population = %d, expected %d", roomPopulation.Population, tt.population)
+			}
+
+			if tt.status != http.StatusCreated {
+				if changes != 0 {
+					t.Errorf("status changes = %d, expected %d", changes, 0)
+				}
+				return
+			}
+
+			// the status is sent to the websocket clients immediately
+			if changes != 1 {
+				t.Errorf("status changes = %d, expected %d", changes, 1)
+			}
+
+			correction := &CorrectionResponse{}
+			if err := json.NewDecoder(response.Body).Decode(correction); err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if correction.Source != "manual" || correction.UserID == nil || *correction.UserID != user.ID {
+				t.Errorf("correction = %+v, expected a manual correction by %+v", correction, user)
+			}
+			if correction.User != user.Name {
+				t.Errorf("correction.User = %v, expected %v", correction.User, user.Name)
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package api
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+	"github.com/kKar1503/rewired-server-2024/internal/deadletter"
+	"github.com/kKar1503/rewired-server-2024/internal/packet"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrUnknownReason = errors.New("unknown reason")
+	ErrUnknownStatus = errors.New("unknown status")
+)
+
+var deadLetterReasons = map[string]deadletter.Reason{
+	deadletter.ReasonUndecodable.String():   deadletter.ReasonUndecodable,
+	deadletter.ReasonUnhandled.String():     deadletter.ReasonUnhandled,
+	deadletter.ReasonUnknownDevice.String(): deadletter.ReasonUnknownDevice,
+}
+
+var deadLetterStatuses = map[string]deadletter.Status{
+	deadletter.StatusPending.String():  deadletter.StatusPending,
+	deadletter.StatusRedriven.String(): deadletter.StatusRedriven,
+}
+
+type DeadLetterResponse struct {
+	ID         uint       `json:"id"`
+	GateID     *uint16    `json:"gateId"`
+	PacketType string     `json:"packetType"`
+	Reason     string     `json:"reason"`
+	Error      string     `json:"error"`
+	Status     string     `json:"status"`
+	RemoteAddr string     `json:"remoteAddr"`
+	ReceivedAt time.Time  `json:"receivedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	RedrivenAt *time.Time `json:"redrivenAt"`
+	Raw        string     `json:"raw"` // hex encoded
+}
+
+// The dead letter with the framing of its raw packet decoded.
+type DeadLetterDetailResponse struct {
+	*DeadLetterResponse
+	Version       byte   `json:"version"`
+	Flags         byte   `json:"flags"`
+	Authenticated bool   `json:"authenticated"`
+	Counter       uint32 `json:"counter"`
+	Payload       string `json:"payload"` // hex encoded
+}
+
+// ServeDeadLetters serves the packets that failed to be handled, redriving them into the packetsEgress.
+//
+//   - GET /api/deadletters lists the dead letters, optionally filtered by the gateId, reason and status queries.
+//   - GET /api/deadletters/{id} returns the dead letter with the framing of its packet decoded.
+//   - POST /api/deadletters/{id}/redrive passes the packet of the dead letter through the pipeline again.
+//   - POST /api/deadletters/redrive redrives every pending dead letter, optionally filtered by the gateId query.
+func ServeDeadLetters(packetsEgress chan<- *packet.RawPacket) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path, redrive := strings.CutSuffix(strings.TrimSuffix(r.URL.Path, "/"), "/redrive")
+
+		id, hasID, err := parsePathID(path, "/api/deadletters")
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+
+		switch {
+		case r.Method == http.MethodGet && !redrive && hasID:
+			getDeadLetter(w, id)
+		case r.Method == http.MethodGet && !redrive:
+			listDeadLetters(w, r)
+		case r.Method == http.MethodPost && redrive && hasID:
+			redriveDeadLetter(w, r, id, packetsEgress)
+		case r.Method == http.MethodPost && redrive:
+			redriveDeadLetters(w, r, packetsEgress)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func getDeadLetter(w http.ResponseWriter, id uint) {
+	deadLetter := &db.DeadLetter{}
+	result := db.Get().First(deadLetter, id)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		writeError(w, http.StatusNotFound, result.Error)
+		return
+	}
+	if result.Error != nil {
+		writeError(w, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newDeadLetterDetailResponse(deadLetter))
+}
+
+func listDeadLetters(w http.ResponseWriter, r *http.Request) {
+	query, err := filterDeadLetters(r, db.Get().Order("id DESC").Limit(100))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if statusQuery := r.URL.Query().Get("status"); statusQuery != "" {
+		status, ok := deadLetterStatuses[statusQuery]
+		if !ok {
+			writeError(w, http.StatusBadRequest, ErrUnknownStatus)
+			return
+		}
+		query = query.Where("status = ?", uint8(status))
+	}
+
+	deadLetters := []db.DeadLetter{}
+	result := query.Find(&deadLetters)
+	if result.Error != nil {
+		writeError(w, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newDeadLetterResponses(deadLetters))
+}
+
+func redriveDeadLetter(w http.ResponseWriter, r *http.Request, id uint, packetsEgress chan<- *packet.RawPacket) {
+	deadLetter := &db.DeadLetter{}
+	result := db.Get().First(deadLetter, id)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		writeError(w, http.StatusNotFound, result.Error)
+		return
+	}
+	if result.Error != nil {
+		writeError(w, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	err := deadletter.Redrive(r.Context(), deadLetter, packetsEgress)
+	if errors.Is(err, deadletter.ErrAlreadyRedriven) {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, newDeadLetterResponse(deadLetter))
+}
+
+func redriveDeadLetters(w http.ResponseWriter, r *http.Request, packetsEgress chan<- *packet.RawPacket) {
+	query, err := filterDeadLetters(r, db.Get().Order("id").Where("status = ?", uint8(deadletter.StatusPending)))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	deadLetters := []db.DeadLetter{}
+	result := query.Find(&deadLetters)
+	if result.Error != nil {
+		writeError(w, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	// the dead letters are redriven in the order they were received, skipping those that were redriven concurrently
+	redriven := make([]db.DeadLetter, 0, len(deadLetters))
+	for i := range deadLetters {
+		err := deadletter.Redrive(r.Context(), &deadLetters[i], packetsEgress)
+		if errors.Is(err, deadletter.ErrAlreadyRedriven) {
+			continue
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		redriven = append(redriven, deadLetters[i])
+	}
+
+	writeJSON(w, http.StatusAccepted, newDeadLetterResponses(redriven))
+}
+
+// filterDeadLetters filters the query by the gateId and reason queries of the request.
+func filterDeadLetters(r *http.Request, query *gorm.DB) (*gorm.DB, error) {
+	if gateIDQuery := r.URL.Query().Get("gateId"); gateIDQuery != "" {
+		gateID, err := strconv.ParseUint(gateIDQuery, 10, 16)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("gate_id = ?", gateID)
+	}
+
+	if reasonQuery := r.URL.Query().Get("reason"); reasonQuery != "" {
+		reason, ok := deadLetterReasons[reasonQuery]
+		if !ok {
+			return nil, ErrUnknownReason
+		}
+		query = query.Where("reason = ?", uint8(reason))
+	}
+
+	return query, nil
+}
+
+func newDeadLetterResponses(deadLetters []db.DeadLetter) []*DeadLetterResponse {
+	response := make([]*DeadLetterResponse, 0, len(deadLetters))
+	for i := range deadLetters {
+		response = append(response, newDeadLetterResponse(&deadLetters[i]))
+	}
+	return response
+}
+
+func newDeadLetterResponse(deadLetter *db.DeadLetter) *DeadLetterResponse {
+	return &DeadLetterResponse{
+		ID:         deadLetter.ID,
+		GateID:     deadLetter.GateID,
+		PacketType: packet.PacketType(deadLetter.PacketType).String(),
+		Reason:     deadletter.Reason(deadLetter.Reason).String(),
+		Error:      deadLetter.Error,
+		Status:     deadletter.Status(deadLetter.Status).String(),
+		RemoteAddr: deadLetter.RemoteAddr,
+		ReceivedAt: deadLetter.ReceivedAt,
+		CreatedAt:  deadLetter.CreatedAt,
+		RedrivenAt: deadLetter.RedrivenAt,
+		Raw:        hex.EncodeToString(deadLetter.Raw),
+	}
+}
+
+func newDeadLetterDetailResponse(deadLetter *db.DeadLetter) *DeadLetterDetailResponse {
+	response := &DeadLetterDetailResponse{DeadLetterResponse: newDeadLetterResponse(deadLetter)}
+
+	// the raw packet was framed by the server when it was stored, so only the payload can fail to decode
+	rawPacket := &packet.RawPacket{}
+	if err := rawPacket.UnmarshalBinary(deadLetter.Raw); err == nil {
+		response.Version = rawPacket.Version
+		response.Flags = rawPacket.Flags
+		response.Authenticated = rawPacket.IsAuthenticated()
+		response.Counter = rawPacket.Counter
+		response.Payload = hex.EncodeToString(rawPacket.Payload())
+	}
+
+	return response
+}
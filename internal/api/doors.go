@@ -0,0 +1,173 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+	"github.com/kKar1503/rewired-server-2024/internal/doorpass"
+	"github.com/kKar1503/rewired-server-2024/internal/doorpass/timing"
+	"gorm.io/gorm"
+)
+
+// The timing of a door, where a nil frame uses the default timing.
+type TimingRequest struct {
+	AllowanceFrameMs *uint32 `json:"allowanceFrameMs"`
+	PassFrameMs      *uint32 `json:"passFrameMs"`
+}
+
+type DoorResponse struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+	// the gates of the door in order from its outer side to its inner side
+	Gates []DoorGateResponse `json:"gates"`
+	Rooms []DoorRoomResponse `json:"rooms"`
+	// the timing set on the door, nil when the default timing is used
+	AllowanceFrameMs *uint32 `json:"allowanceFrameMs"`
+	PassFrameMs      *uint32 `json:"passFrameMs"`
+	// the timing the door is detecting passes with
+	EffectiveAllowanceFrameMs int64 `json:"effectiveAllowanceFrameMs"`
+	EffectivePassFrameMs      int64 `json:"effectivePassFrameMs"`
+}
+
+type DoorGateResponse struct {
+	Position uint8  `json:"position"`
+	GateID   uint16 `json:"gateId"`
+}
+
+// The room of a door at the position between the gates at position - 1 and position.
+type DoorRoomResponse struct {
+	Position uint8 `json:"position"`
+	RoomID   uint  `json:"roomId"`
+}
+
+// ServeDoors serves the doors between the rooms.
+//
+//   - GET /api/doors lists the doors with their gates, rooms and timing.
+//   - GET /api/doors/{id} returns the door with its gates, rooms and timing.
+//   - PUT /api/doors/{id}/timing replaces the timing of the door, taking effect immediately.
+func ServeDoors(w http.ResponseWriter, r *http.Request) {
+	path, timing := strings.CutSuffix(strings.TrimSuffix(r.URL.Path, "/"), "/timing")
+
+	id, hasID, err := parsePathID(path, "/api/doors")
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && !timing && hasID:
+		getDoor(w, id)
+	case r.Method == http.MethodGet && !timing:
+		listDoors(w)
+	case r.Method == http.MethodPut && timing && hasID:
+		setDoorTiming(w, r, id)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func getDoor(w http.ResponseWriter, id uint) {
+	door, err := findDoor(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newDoorResponse(door))
+}
+
+func listDoors(w http.ResponseWriter) {
+	doors := []db.Door{}
+	result := db.Get().Scopes(db.PreloadDoor).Order("id").Find(&doors)
+	if result.Error != nil {
+		writeError(w, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	response := make([]*DoorResponse, 0, len(doors))
+	for i := range doors {
+		response = append(response, newDoorResponse(&doors[i]))
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func setDoorTiming(w http.ResponseWriter, r *http.Request, id uint) {
+	request := &TimingRequest{}
+	if err := readJSON(r, request); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	for _, frameMs := range []*uint32{request.AllowanceFrameMs, request.PassFrameMs} {
+		if frameMs == nil {
+			continue
+		}
+		if err := timing.ValidateTiming(time.Duration(*frameMs) * time.Millisecond); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	door, err := findDoor(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// the frames are updated with a map, so the frames that are nil are cleared back to the default timing
+	result := db.Get().Model(door).Updates(map[string]any{
+		"allowance_frame_ms": request.AllowanceFrameMs,
+		"pass_frame_ms":      request.PassFrameMs,
+	})
+	if result.Error != nil {
+		writeError(w, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	door.AllowanceFrameMs = request.AllowanceFrameMs
+	door.PassFrameMs = request.PassFrameMs
+	doorpass.SetTiming(door)
+
+	writeJSON(w, http.StatusOK, newDoorResponse(door))
+}
+
+func findDoor(id uint) (*db.Door, error) {
+	door := &db.Door{}
+	result := db.Get().Scopes(db.PreloadDoor).First(door, id)
+	return door, result.Error
+}
+
+func newDoorResponse(door *db.Door) *DoorResponse {
+	allowanceFrame, passFrame := timing.Timing(door)
+
+	response := &DoorResponse{
+		ID:                        door.ID,
+		Name:                      door.Name,
+		Gates:                     make([]DoorGateResponse, 0, len(door.Gates)),
+		Rooms:                     make([]DoorRoomResponse, 0, len(door.Rooms)),
+		AllowanceFrameMs:          door.AllowanceFrameMs,
+		PassFrameMs:               door.PassFrameMs,
+		EffectiveAllowanceFrameMs: allowanceFrame.Milliseconds(),
+		EffectivePassFrameMs:      passFrame.Milliseconds(),
+	}
+	for _, gate := range door.Gates {
+		response.Gates = append(response.Gates, DoorGateResponse{Position: gate.Position, GateID: gate.Device.GateID})
+	}
+	for _, room := range door.Rooms {
+		response.Rooms = append(response.Rooms, DoorRoomResponse{Position: room.Position, RoomID: room.RoomID})
+	}
+
+	return response
+}
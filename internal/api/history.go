@@ -0,0 +1,164 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+	"github.com/kKar1503/rewired-server-2024/internal/history"
+	"github.com/kKar1503/rewired-server-2024/internal/zone"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidHistoryTarget = errors.New("exactly one of roomId and zoneId is required")
+	ErrInvalidHistoryRange  = errors.New("until must be after since")
+)
+
+type HistoryResponse struct {
+	RoomID     *uint                   `json:"roomId"`
+	ZoneID     *uint                   `json:"zoneId"`
+	Resolution string                  `json:"resolution"`
+	Since      time.Time               `json:"since"`
+	Until      time.Time               `json:"until"`
+	Points     []*HistoryPointResponse `json:"points"`
+}
+
+type HistoryPointResponse struct {
+	At  time.Time `json:"at"`
+	Min uint32    `json:"min"`
+	Max uint32    `json:"max"`
+	Avg float64   `json:"avg"`
+}
+
+// ServeHistory serves the occupancy history of the rooms and the zones.
+//
+//   - GET /api/history returns the history of the room of the roomId query, or of the total of the rooms in the zone of
+//     the zoneId query, between the since and until queries in RFC 3339, which default to the day until now, at the
+//     resolution query of raw, minute, hour or day, which defaults to hour.
+func ServeHistory(w http.ResponseWriter, r *http.Request) {
+	_, hasID, err := pathID(r, "/api/history")
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && !hasID:
+		getHistory(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func getHistory(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	response := &HistoryResponse{Resolution: history.ResolutionHour.String(), Until: time.Now()}
+
+	var err error
+	if response.RoomID, err = queryID(r, "roomId"); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if response.ZoneID, err = queryID(r, "zoneId"); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if (response.RoomID == nil) == (response.ZoneID == nil) {
+		writeError(w, http.StatusBadRequest, ErrInvalidHistoryTarget)
+		return
+	}
+
+	if value := query.Get("resolution"); value != "" {
+		response.Resolution = value
+	}
+	resolution, err := history.ParseResolution(response.Resolution)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if value := query.Get("until"); value != "" {
+		if response.Until, err = time.Parse(time.RFC3339, value); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	response.Since = response.Until.Add(-24 * time.Hour)
+	if value := query.Get("since"); value != "" {
+		if response.Since, err = time.Parse(time.RFC3339, value); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if !response.Since.Before(response.Until) {
+		writeError(w, http.StatusBadRequest, ErrInvalidHistoryRange)
+		return
+	}
+
+	var roomIDs []uint
+	if response.RoomID != nil {
+		if status, err := findRoom(*response.RoomID); err != nil {
+			writeError(w, status, err)
+			return
+		}
+		roomIDs = []uint{*response.RoomID}
+	} else {
+		if status, err := findZone(*response.ZoneID); err != nil {
+			writeError(w, status, err)
+			return
+		}
+		if roomIDs, err = zone.RoomIDs(*response.ZoneID); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	points, err := history.Query(roomIDs, resolution, response.Since, response.Until)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	response.Points = make([]*HistoryPointResponse, 0, len(points))
+	for _, point := range points {
+		response.Points = append(response.Points, &HistoryPointResponse{
+			At:  point.At,
+			Min: point.Min,
+			Max: point.Max,
+			Avg: point.Avg,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// queryID parses the ID of the query of the request, which is nil when the query is empty.
+func queryID(r *http.Request, name string) (*uint, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	id, err := strconv.ParseUint(value, 10, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	uintID := uint(id)
+	return &uintID, nil
+}
+
+// findZone checks that the zone exists, returning the status to respond with when it does not.
+func findZone(zoneID uint) (int, error) {
+	result := db.Get().First(&db.Zone{}, zoneID)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return http.StatusNotFound, result.Error
+	}
+	if result.Error != nil {
+		return http.StatusInternalServerError, result.Error
+	}
+	return http.StatusOK, nil
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+)
+
+var ErrInvalidOccupancy = errors.New("occupancy must end after it starts")
+
+type OccupancyRequest struct {
+	RoomID    uint      `json:"roomId"`
+	Name      string    `json:"name"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+}
+
+type OccupancyResponse struct {
+	ID        uint      `json:"id"`
+	RoomID    uint      `json:"roomId"`
+	Name      string    `json:"name"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+}
+
+// ServeOccupancies serves the occupancy calendars of the rooms, while which the scheduled resets of the rooms can be
+// skipped.
+//
+//   - GET /api/occupancies lists the occupancies that have not ended, optionally filtered by the roomId query.
+//   - POST /api/occupancies adds an occupancy to the calendar of a room.
+//   - DELETE /api/occupancies/{id} deletes the occupancy.
+func ServeOccupancies(w http.ResponseWriter, r *http.Request) {
+	id, hasID, err := pathID(r, "/api/occupancies")
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && !hasID:
+		listOccupancies(w, r)
+	case r.Method == http.MethodPost && !hasID:
+		createOccupancy(w, r)
+	case r.Method == http.MethodDelete && hasID:
+		deleteByID(w, &db.RoomOccupancy{}, id)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func listOccupancies(w http.ResponseWriter, r *http.Request) {
+	query, err := filterRoomID(r, db.Get().Where("end_time > ?", time.Now()).Order("start_time"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	occupancies := []db.RoomOccupancy{}
+	result := query.Find(&occupancies)
+	if result.Error != nil {
+		writeError(w, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	response := make([]*OccupancyResponse, 0, len(occupancies))
+	for i := range occupancies {
+		response = append(response, newOccupancyResponse(&occupancies[i]))
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func createOccupancy(w http.ResponseWriter, r *http.Request) {
+	request := &OccupancyRequest{}
+	if err := readJSON(r, request); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !request.EndTime.After(request.StartTime) {
+		writeError(w, http.StatusBadRequest, ErrInvalidOccupancy)
+		return
+	}
+
+	if status, err := findRoom(request.RoomID); err != nil {
+		writeError(w, status, err)
+		return
+	}
+
+	occupancy := &db.RoomOccupancy{
+		RoomID:    request.RoomID,
+		Name:      request.Name,
+		StartTime: request.StartTime,
+		EndTime:   request.EndTime,
+	}
+	if err := db.Get().Create(occupancy).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, newOccupancyResponse(occupancy))
+}
+
+func newOccupancyResponse(occupancy *db.RoomOccupancy) *OccupancyResponse {
+	return &OccupancyResponse{
+		ID:        occupancy.ID,
+		RoomID:    occupancy.RoomID,
+		Name:      occupancy.Name,
+		StartTime: occupancy.StartTime,
+		EndTime:   occupancy.EndTime,
+	}
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/population"
+)
+
+// The window of the event log to rebuild the populations from, where the rooms are empty at since, and whether to
+// replace the stored populations with the rebuilt ones.
+type RebuildRequest struct {
+	Since *time.Time `json:"since"`
+	Until *time.Time `json:"until"`
+	Apply bool       `json:"apply"`
+}
+
+type RebuildResponse struct {
+	Applied bool                   `json:"applied"`
+	Rooms   []*RoomRebuildResponse `json:"rooms"`
+}
+
+type RoomRebuildResponse struct {
+	RoomID    uint   `json:"roomId"`
+	Name      string `json:"name"`
+	Stored    uint32 `json:"stored"`
+	StoredNet int32  `json:"storedNet"`
+	Rebuilt   uint32 `json:"rebuilt"`
+	Diff      int64  `json:"diff"` // rebuilt - stored
+	Changed   bool   `json:"changed"`
+}
+
+// ServePopulations serves the populations of the rooms, and must be served Authenticated.
+//
+//   - POST /api/populations/rebuild rebuilds the population of every room from the event log, returning the rebuilt
+//     populations against the stored ones, and replaces the stored populations on behalf of the user of the request
+//     when apply is set.
+func ServePopulations(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodPost && strings.TrimSuffix(r.URL.Path, "/") == "/api/populations/rebuild":
+		rebuildPopulations(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func rebuildPopulations(w http.ResponseWriter, r *http.Request) {
+	request := &RebuildRequest{}
+	if err := readJSON(r, request); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var since, until time.Time
+	if request.Since != nil {
+		since = *request.Since
+	}
+	if request.Until != nil {
+		until = *request.Until
+	}
+
+	diffs, err := population.Rebuild(since, until)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if request.Apply {
+		err := population.ApplyRebuild(diffs, &requestUser(r).ID)
+		if errors.Is(err, population.ErrRebuildConflict) {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	response := &RebuildResponse{Applied: request.Apply, Rooms: make([]*RoomRebuildResponse, 0, len(diffs))}
+	for _, diff := range diffs {
+		response.Rooms = append(response.Rooms, &RoomRebuildResponse{
+			RoomID:    diff.RoomID,
+			Name:      diff.Name,
+			Stored:    diff.Stored,
+			StoredNet: diff.StoredNet,
+			Rebuilt:   diff.Rebuilt,
+			Diff:      int64(diff.Rebuilt) - int64(diff.Stored),
+			Changed:   diff.Changed(),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+	"github.com/kKar1503/rewired-server-2024/internal/population"
+	"gorm.io/gorm"
+)
+
+var ErrInvalidWarningThreshold = errors.New("warning threshold must not be above the capacity")
+
+// The zone of a room, where nil removes the room from its zone.
+type RoomZoneRequest struct {
+	ZoneID *uint `json:"zoneId"`
+}
+
+// The capacity of a room, where 0 removes the capacity or the warning threshold.
+type CapacityRequest struct {
+	Capacity         uint32 `json:"capacity"`
+	WarningThreshold uint32 `json:"warningThreshold"`
+}
+
+type RoomResponse struct {
+	ID               uint   `json:"id"`
+	ZoneID           *uint  `json:"zoneId"` // nil when the room is not in a zone
+	Name             string `json:"name"`
+	Population       uint32 `json:"population"`
+	NetPopulation    int32  `json:"netPopulation"`
+	Capacity         uint32 `json:"capacity"`         // 0 when the room has no capacity
+	WarningThreshold uint32 `json:"warningThreshold"` // 0 when the room is not warned on
+	// the population as a percentage of the capacity, nil when the room has no capacity
+	OccupancyPercent *float64 `json:"occupancyPercent"`
+}
+
+// ServeRooms serves the rooms with their populations.
+//
+//   - GET /api/rooms lists the rooms with their populations and capacities.
+//   - GET /api/rooms/{id} returns the room with its population and capacity.
+//   - PUT /api/rooms/{id}/capacity replaces the capacity and the warning threshold of the room, alerting when the room
+//     is already over them.
+//   - PUT /api/rooms/{id}/zone moves the room into a building or a floor.
+func ServeRooms(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	path, capacity := strings.CutSuffix(path, "/capacity")
+	path, zone := strings.CutSuffix(path, "/zone")
+
+	id, hasID, err := parsePathID(path, "/api/rooms")
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && !capacity && !zone && hasID:
+		getRoom(w, id)
+	case r.Method == http.MethodGet && !capacity && !zone:
+		listRooms(w)
+	case r.Method == http.MethodPut && capacity && hasID:
+		setRoomCapacity(w, r, id)
+	case r.Method == http.MethodPut && zone && hasID:
+		setRoomZone(w, r, id)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func getRoom(w http.ResponseWriter, id uint) {
+	room := &db.Room{}
+	result := db.Get().Preload("RoomPopulation").First(room, id)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		writeError(w, http.StatusNotFound, result.Error)
+		return
+	}
+	if result.Error != nil {
+		writeError(w, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newRoomResponse(room))
+}
+
+func listRooms(w http.ResponseWriter) {
+	rooms := []db.Room{}
+	result := db.Get().Preload("RoomPopulation").Order("id").Find(&rooms)
+	if result.Error != nil {
+		writeError(w, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	response := make([]*RoomResponse, 0, len(rooms))
+	for i := range rooms {
+		response = append(response, newRoomResponse(&rooms[i]))
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func setRoomCapacity(w http.ResponseWriter, r *http.Request, id uint) {
+	request := &CapacityRequest{}
+	if err := readJSON(r, request); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if request.Capacity > 0 && request.WarningThreshold > request.Capacity {
+		writeError(w, http.StatusBadRequest, ErrInvalidWarningThreshold)
+		return
+	}
+
+	err := population.SetCapacity(id, request.Capacity, request.WarningThreshold)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	statusChanged()
+
+	getRoom(w, id)
+}
+
+func setRoomZone(w http.ResponseWriter, r *http.Request, id uint) {
+	request := &RoomZoneRequest{}
+	if err := readJSON(r, request); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if request.ZoneID != nil {
+		result := db.Get().First(&db.Zone{}, *request.ZoneID)
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			writeError(w, http.StatusBadRequest, result.Error)
+			return
+		}
+		if result.Error != nil {
+			writeError(w, http.StatusInternalServerError, result.Error)
+			return
+		}
+	}
+
+	result := db.Get().Model(&db.Room{}).Where("id = ?", id).Update("zone_id", request.ZoneID)
+	if result.Error != nil {
+		writeError(w, http.StatusInternalServerError, result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		writeError(w, http.StatusNotFound, gorm.ErrRecordNotFound)
+		return
+	}
+
+	statusChanged()
+
+	getRoom(w, id)
+}
+
+func newRoomResponse(room *db.Room) *RoomResponse {
+	response := &RoomResponse{
+		ID:               room.ID,
+		ZoneID:           room.ZoneID,
+		Name:             room.Name,
+		Population:       room.RoomPopulation.Population,
+		NetPopulation:    room.RoomPopulation.NetPopulation,
+		Capacity:         room.Capacity,
+		WarningThreshold: room.WarningThreshold,
+	}
+	if occupancyPercent, ok := room.OccupancyPercent(); ok {
+		response.OccupancyPercent = &occupancyPercent
+	}
+
+	return response
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+	"github.com/kKar1503/rewired-server-2024/internal/schedule"
+	"gorm.io/gorm"
+)
+
+type ScheduleRequest struct {
+	RoomID            uint   `json:"roomId"`
+	Expression        string `json:"expression"`
+	Population        uint32 `json:"population"`
+	SkipWhileOccupied bool   `json:"skipWhileOccupied"`
+}
+
+type ScheduleResponse struct {
+	ID                uint       `json:"id"`
+	RoomID            uint       `json:"roomId"`
+	Expression        string     `json:"expression"`
+	Population        uint32     `json:"population"`
+	SkipWhileOccupied bool       `json:"skipWhileOccupied"`
+	LastRunAt         *time.Time `json:"lastRunAt"`
+	NextRunAt         *time.Time `json:"nextRunAt"` // nil when the expression never matches
+	CreatedAt         time.Time  `json:"createdAt"`
+}
+
+// ServeSchedules serves the schedules the populations of the rooms are reset on.
+//
+//   - GET /api/schedules lists the schedules, optionally filtered by the roomId query.
+//   - POST /api/schedules creates a schedule that resets the population of a room at the times of its cron expression.
+//   - DELETE /api/schedules/{id} deletes the schedule.
+func ServeSchedules(w http.ResponseWriter, r *http.Request) {
+	id, hasID, err := pathID(r, "/api/schedules")
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && !hasID:
+		listSchedules(w, r)
+	case r.Method == http.MethodPost && !hasID:
+		createSchedule(w, r)
+	case r.Method == http.MethodDelete && hasID:
+		deleteByID(w, &db.PopulationSchedule{}, id)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func listSchedules(w http.ResponseWriter, r *http.Request) {
+	query, err := filterRoomID(r, db.Get().Order("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	schedules := []db.PopulationSchedule{}
+	result := query.Find(&schedules)
+	if result.Error != nil {
+		writeError(w, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	response := make([]*ScheduleResponse, 0, len(schedules))
+	for i := range schedules {
+		response = append(response, newScheduleResponse(&schedules[i]))
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func createSchedule(w http.ResponseWriter, r *http.Request) {
+	request := &ScheduleRequest{}
+	if err := readJSON(r, request); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, err := schedule.Parse(request.Expression); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if status, err := findRoom(request.RoomID); err != nil {
+		writeError(w, status, err)
+		return
+	}
+
+	populationSchedule := &db.PopulationSchedule{
+		RoomID:            request.RoomID,
+		Expression:        request.Expression,
+		Population:        request.Population,
+		SkipWhileOccupied: request.SkipWhileOccupied,
+	}
+	if err := db.Get().Create(populationSchedule).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, newScheduleResponse(populationSchedule))
+}
+
+func newScheduleResponse(populationSchedule *db.PopulationSchedule) *ScheduleResponse {
+	response := &ScheduleResponse{
+		ID:                populationSchedule.ID,
+		RoomID:            populationSchedule.RoomID,
+		Expression:        populationSchedule.Expression,
+		Population:        populationSchedule.Population,
+		SkipWhileOccupied: populationSchedule.SkipWhileOccupied,
+		LastRunAt:         populationSchedule.LastRunAt,
+		CreatedAt:         populationSchedule.CreatedAt,
+	}
+
+	if expression, err := schedule.Parse(populationSchedule.Expression); err == nil {
+		last := populationSchedule.CreatedAt
+		if populationSchedule.LastRunAt != nil {
+			last = *populationSchedule.LastRunAt
+		}
+		if next := expression.Next(last.In(time.Local)); !next.IsZero() {
+			response.NextRunAt = &next
+		}
+	}
+
+	return response
+}
+
+// filterRoomID filters the query by the roomId query of the request.
+func filterRoomID(r *http.Request, query *gorm.DB) (*gorm.DB, error) {
+	roomIDQuery := r.URL.Query().Get("roomId")
+	if roomIDQuery == "" {
+		return query, nil
+	}
+
+	roomID, err := strconv.ParseUint(roomIDQuery, 10, 0)
+	if err != nil {
+		return nil, err
+	}
+	return query.Where("room_id = ?", roomID), nil
+}
+
+// findRoom checks that the room exists, returning the status to respond with when it does not.
+func findRoom(roomID uint) (int, error) {
+	result := db.Get().First(&db.Room{}, roomID)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return http.StatusNotFound, result.Error
+	}
+	if result.Error != nil {
+		return http.StatusInternalServerError, result.Error
+	}
+	return http.StatusOK, nil
+}
+
+// deleteByID deletes the record of the model with the ID.
+func deleteByID(w http.ResponseWriter, model any, id uint) {
+	result := db.Get().Delete(model, id)
+	if result.Error != nil {
+		writeError(w, http.StatusInternalServerError, result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		writeError(w, http.StatusNotFound, gorm.ErrRecordNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,202 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+	"github.com/kKar1503/rewired-server-2024/internal/zone"
+	"gorm.io/gorm"
+)
+
+var ErrZoneNotEmpty = errors.New("zone has zones or rooms in it")
+
+type ZoneRequest struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`     // building or floor
+	ParentID *uint  `json:"parentId"` // the building of a floor
+}
+
+type ZoneResponse struct {
+	ID            uint   `json:"id"`
+	ParentID      *uint  `json:"parentId"`
+	Name          string `json:"name"`
+	Type          string `json:"type"`
+	Population    uint32 `json:"population"`
+	NetPopulation int32  `json:"netPopulation"`
+	Capacity      uint32 `json:"capacity"` // the total of the capacities of the rooms that have a capacity
+	RoomCount     int    `json:"roomCount"`
+}
+
+// The zone with the zones and the rooms directly in it, to drill down into.
+type ZoneDetailResponse struct {
+	*ZoneResponse
+	Zones []*ZoneResponse `json:"zones"`
+	Rooms []*RoomResponse `json:"rooms"`
+}
+
+// ServeZones serves the buildings and floors the rooms are grouped into, with the total populations of their rooms.
+//
+//   - GET /api/zones lists the zones with their populations.
+//   - GET /api/zones/{id} returns the zone with its population, and the zones and the rooms directly in it.
+//   - POST /api/zones creates a building, or a floor in a building.
+//   - DELETE /api/zones/{id} deletes the zone, which must not have zones or rooms in it.
+func ServeZones(w http.ResponseWriter, r *http.Request) {
+	id, hasID, err := pathID(r, "/api/zones")
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && hasID:
+		getZone(w, id)
+	case r.Method == http.MethodGet:
+		listZones(w)
+	case r.Method == http.MethodPost && !hasID:
+		createZone(w, r)
+	case r.Method == http.MethodDelete && hasID:
+		deleteZone(w, id)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func listZones(w http.ResponseWriter) {
+	zones, rooms, err := findZones()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	populations := zone.Aggregate(zones, rooms)
+	response := make([]*ZoneResponse, 0, len(zones))
+	for i := range zones {
+		response = append(response, newZoneResponse(&zones[i], populations[zones[i].ID]))
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func getZone(w http.ResponseWriter, id uint) {
+	zones, rooms, err := findZones()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	populations := zone.Aggregate(zones, rooms)
+	if _, ok := populations[id]; !ok {
+		writeError(w, http.StatusNotFound, gorm.ErrRecordNotFound)
+		return
+	}
+
+	response := &ZoneDetailResponse{Zones: []*ZoneResponse{}, Rooms: []*RoomResponse{}}
+	for i := range zones {
+		switch {
+		case zones[i].ID == id:
+			response.ZoneResponse = newZoneResponse(&zones[i], populations[id])
+		case zones[i].ParentID != nil && *zones[i].ParentID == id:
+			response.Zones = append(response.Zones, newZoneResponse(&zones[i], populations[zones[i].ID]))
+		}
+	}
+	for i := range rooms {
+		if rooms[i].ZoneID != nil && *rooms[i].ZoneID == id {
+			response.Rooms = append(response.Rooms, newRoomResponse(&rooms[i]))
+		}
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func createZone(w http.ResponseWriter, r *http.Request) {
+	request := &ZoneRequest{}
+	if err := readJSON(r, request); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	zoneType, err := zone.ParseType(request.Type)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var parent *db.Zone
+	if request.ParentID != nil {
+		parent = &db.Zone{}
+		result := db.Get().First(parent, *request.ParentID)
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			writeError(w, http.StatusBadRequest, zone.ErrInvalidParent)
+			return
+		}
+		if result.Error != nil {
+			writeError(w, http.StatusInternalServerError, result.Error)
+			return
+		}
+	}
+
+	if err := zone.ValidateParent(zoneType, parent); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// the status is only sent for the rooms and zones of the first user
+	newZone := &db.Zone{Name: request.Name, ZoneType: uint8(zoneType), ParentID: request.ParentID, OwnerID: 1}
+	if err := db.Get().Create(newZone).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	statusChanged()
+
+	writeJSON(w, http.StatusCreated, newZoneResponse(newZone, &zone.Population{}))
+}
+
+func deleteZone(w http.ResponseWriter, id uint) {
+	for _, child := range []struct {
+		model  any
+		column string
+	}{{&db.Zone{}, "parent_id"}, {&db.Room{}, "zone_id"}} {
+		var count int64
+		if err := db.Get().Model(child.model).Where(child.column+" = ?", id).Count(&count).Error; err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if count > 0 {
+			writeError(w, http.StatusConflict, ErrZoneNotEmpty)
+			return
+		}
+	}
+
+	deleteByID(w, &db.Zone{}, id)
+	statusChanged()
+}
+
+// findZones returns every zone, and every room with its population.
+func findZones() ([]db.Zone, []db.Room, error) {
+	zones := []db.Zone{}
+	if err := db.Get().Order("id").Find(&zones).Error; err != nil {
+		return nil, nil, err
+	}
+
+	rooms := []db.Room{}
+	if err := db.Get().Preload("RoomPopulation").Order("id").Find(&rooms).Error; err != nil {
+		return nil, nil, err
+	}
+
+	return zones, rooms, nil
+}
+
+func newZoneResponse(z *db.Zone, population *zone.Population) *ZoneResponse {
+	return &ZoneResponse{
+		ID:            z.ID,
+		ParentID:      z.ParentID,
+		Name:          z.Name,
+		Type:          zone.Type(z.ZoneType).String(),
+		Population:    population.Population,
+		NetPopulation: population.NetPopulation,
+		Capacity:      population.Capacity,
+		RoomCount:     population.Rooms,
+	}
+}
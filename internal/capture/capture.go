@@ -0,0 +1,214 @@
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/packet"
+)
+
+// The capture file starts with the MAGIC, followed by the records of the packets, each of which is:
+//
+//	[received at, unix nanoseconds int64][remote address length uint8][remote address][frame length uint16][frame]
+//
+// where the frame is the packet as framed by the device. All integers are big endian.
+var MAGIC = []byte("RWCAP\x01")
+
+const FLUSH_INTERVAL = time.Second
+
+var (
+	ErrInvalidCapture = errors.New("not a capture file")
+	ErrRecordTooLarge = errors.New("capture record too large")
+)
+
+// The writer of a capture file, which is safe to use from multiple goroutines.
+type Writer struct {
+	mu     sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+}
+
+// Create creates the capture file at the path. When a file already exists at the path, such as the capture of a
+// previous run, the capture is created next to it with the current time in its name instead of overwriting it.
+func Create(path string) (*Writer, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if errors.Is(err, fs.ErrExist) {
+		file, err = os.OpenFile(timestampedPath(path, time.Now()), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	writer := bufio.NewWriter(file)
+	if _, err := writer.Write(MAGIC); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &Writer{file: file, writer: writer}, nil
+}
+
+// timestampedPath inserts the time before the extension of the path, e.g. packets-20240528T130000.cap.
+func timestampedPath(path string, now time.Time) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + now.Format("20060102T150405") + ext
+}
+
+// Path returns the path of the capture file, which is not the path it was created with when a file already existed.
+func (w *Writer) Path() string {
+	return w.file.Name()
+}
+
+// Write records the packet with the time it was received and the address it was received from.
+func (w *Writer) Write(rawPacket *packet.RawPacket) error {
+	// the packets that were not received are marshalled instead, such as the packets built by the tests
+	frame := rawPacket.Frame()
+	if frame == nil {
+		var err error
+		if frame, err = rawPacket.MarshalBinary(); err != nil {
+			return err
+		}
+	}
+
+	if len(rawPacket.RemoteAddr) > math.MaxUint8 || len(frame) > math.MaxUint16 {
+		return ErrRecordTooLarge
+	}
+
+	record := make([]byte, 0, 8+1+len(rawPacket.RemoteAddr)+2+len(frame))
+	record = binary.BigEndian.AppendUint64(record, uint64(rawPacket.ReceivedAt.UnixNano()))
+	record = append(record, byte(len(rawPacket.RemoteAddr)))
+	record = append(record, rawPacket.RemoteAddr...)
+	record = binary.BigEndian.AppendUint16(record, uint16(len(frame)))
+	record = append(record, frame...)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_, err := w.writer.Write(record)
+	return err
+}
+
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.writer.Flush()
+}
+
+// Close flushes the records and closes the capture file.
+func (w *Writer) Close() error {
+	if err := w.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+
+	return w.file.Close()
+}
+
+// Tee records every packet from the packetIngress before passing it on to the returned channel, until the context is
+// done. The records are flushed every FLUSH_INTERVAL, so a capture of a crashed server only misses the last moments.
+//
+// The redriven packets are passed on without being recorded, as they were already recorded when they were received.
+func (w *Writer) Tee(ctx context.Context, packetIngress <-chan *packet.RawPacket) <-chan *packet.RawPacket {
+	packetsEgress := make(chan *packet.RawPacket)
+
+	go func() {
+		ticker := time.NewTicker(FLUSH_INTERVAL)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := w.Flush(); err != nil {
+					slog.Error("failed to flush the capture", "error", err)
+				}
+			case rawPacket := <-packetIngress:
+				if !rawPacket.Redriven {
+					if err := w.Write(rawPacket); err != nil {
+						slog.Error("failed to capture packet", "error", err, "packetType", rawPacket.PacketType)
+					}
+				}
+
+				select {
+				case packetsEgress <- rawPacket:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return packetsEgress
+}
+
+// The reader of a capture file.
+type Reader struct {
+	reader *bufio.Reader
+}
+
+// NewReader creates the reader of the capture, failing when the capture does not start with the MAGIC.
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := bufio.NewReader(r)
+
+	magic := make([]byte, len(MAGIC))
+	if _, err := io.ReadFull(reader, magic); err != nil || !bytes.Equal(magic, MAGIC) {
+		return nil, ErrInvalidCapture
+	}
+
+	return &Reader{reader: reader}, nil
+}
+
+// Read reads the next packet of the capture, with the time it was received and the address it was received from. It
+// returns io.EOF at the end of the capture, and io.ErrUnexpectedEOF when the last record was cut short.
+func (r *Reader) Read() (*packet.RawPacket, error) {
+	header := make([]byte, 9)
+	if _, err := io.ReadFull(r.reader, header); err != nil {
+		return nil, err
+	}
+	receivedAt := time.Unix(0, int64(binary.BigEndian.Uint64(header)))
+
+	remoteAddr := make([]byte, header[8])
+	if _, err := io.ReadFull(r.reader, remoteAddr); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	frameLength := make([]byte, 2)
+	if _, err := io.ReadFull(r.reader, frameLength); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	frame := make([]byte, binary.BigEndian.Uint16(frameLength))
+	if _, err := io.ReadFull(r.reader, frame); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	rawPacket := &packet.RawPacket{}
+	if err := rawPacket.UnmarshalBinary(frame); err != nil {
+		return nil, err
+	}
+	rawPacket.RemoteAddr = string(remoteAddr)
+	rawPacket.ReceivedAt = receivedAt
+
+	return rawPacket, nil
+}
+
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,186 @@
+package capture
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/packet"
+)
+
+func TestWriteAndRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "packets.cap")
+
+	writer, err := Create(path)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	heartbeatPacket := &packet.HeartbeatPacket{
+		RawPacket: packet.RawPacket{Version: packet.VERSION_2, PacketType: packet.PacketTypeHeartbeat},
+	}
+	heartbeatPacket.SetGateID(7)
+	heartbeatPacket.RemoteAddr = "127.0.0.1:5000"
+	heartbeatPacket.ReceivedAt = time.Unix(1716912942, 123456789)
+
+	decrementPacket := &packet.DecrementPacket{
+		RawPacket: packet.RawPacket{Version: packet.VERSION_1, PacketType: packet.PacketTypeDecrement},
+	}
+	decrementPacket.SetGateID(8)
+	decrementPacket.ReceivedAt = time.Unix(1716912943, 0)
+
+	expected := []*packet.RawPacket{&heartbeatPacket.RawPacket, &decrementPacket.RawPacket}
+	for _, rawPacket := range expected {
+		if err := writer.Write(rawPacket); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+
+	reader, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewReader() error = %v", err)
+	}
+
+	for i, e := range expected {
+		result, err := reader.Read()
+		if err != nil {
+			t.Fatalf("Read() %d error = %v", i, err)
+		}
+
+		resultGateID, _ := result.GateID()
+		expectedGateID, _ := e.GateID()
+		if result.Version != e.Version || result.PacketType != e.PacketType || resultGateID != expectedGateID {
+			t.Errorf("Read() %d = %+v, expected %+v", i, result, e)
+		}
+		if result.RemoteAddr != e.RemoteAddr {
+			t.Errorf("Read() %d remoteAddr = %q, expected %q", i, result.RemoteAddr, e.RemoteAddr)
+		}
+		if !result.ReceivedAt.Equal(e.ReceivedAt) {
+			t.Errorf("Read() %d receivedAt = %v, expected %v", i, result.ReceivedAt, e.ReceivedAt)
+		}
+	}
+
+	if _, err := reader.Read(); !errors.Is(err, io.EOF) {
+		t.Errorf("Read() at end error = %v, expected %v", err, io.EOF)
+	}
+
+	// a capture cut short in the middle of a record, as when the server crashed while writing
+	reader, err = NewReader(bytes.NewReader(data[:len(data)-1]))
+	if err != nil {
+		t.Fatalf("NewReader() error = %v", err)
+	}
+	reader.Read()
+	if _, err := reader.Read(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Read() of truncated record error = %v, expected %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestNewReaderRejectsOtherFiles(t *testing.T) {
+	if _, err := NewReader(bytes.NewReader([]byte("SQLite format 3"))); !errors.Is(err, ErrInvalidCapture) {
+		t.Errorf("NewReader() error = %v, expected %v", err, ErrInvalidCapture)
+	}
+}
+
+func TestCreateKeepsExistingCapture(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "packets.cap")
+	if err := os.WriteFile(path, []byte("previous capture"), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	writer, err := Create(path)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	defer writer.Close()
+
+	if writer.Path() == path {
+		t.Errorf("Path() = %q, expected a path other than the existing capture", writer.Path())
+	}
+	if filepath.Ext(writer.Path()) != ".cap" {
+		t.Errorf("Path() = %q, expected the extension of the existing capture", writer.Path())
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if string(data) != "previous capture" {
+		t.Errorf("existing capture = %q, expected it to be kept", data)
+	}
+}
+
+func TestTeeSkipsRedriven(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "packets.cap")
+
+	writer, err := Create(path)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ingress := make(chan *packet.RawPacket)
+	egress := writer.Tee(ctx, ingress)
+
+	for gateID, redriven := range map[uint16]bool{1: false, 2: true} {
+		heartbeatPacket := &packet.HeartbeatPacket{
+			RawPacket: packet.RawPacket{Version: packet.VERSION_2, PacketType: packet.PacketTypeHeartbeat},
+		}
+		heartbeatPacket.SetGateID(gateID)
+		heartbeatPacket.Redriven = redriven
+
+		ingress <- &heartbeatPacket.RawPacket
+		if passed := <-egress; passed != &heartbeatPacket.RawPacket {
+			t.Fatalf("Tee() passed %+v, expected %+v", passed, heartbeatPacket.RawPacket)
+		}
+	}
+
+	cancel()
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer file.Close()
+
+	reader, err := NewReader(file)
+	if err != nil {
+		t.Fatalf("NewReader() error = %v", err)
+	}
+
+	captured := []uint16{}
+	for {
+		rawPacket, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+
+		gateID, _ := rawPacket.GateID()
+		captured = append(captured, gateID)
+	}
+
+	if len(captured) != 1 || captured[0] != 1 {
+		t.Errorf("captured gateIDs = %v, expected [1]", captured)
+	}
+}
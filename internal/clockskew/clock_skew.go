@@ -0,0 +1,115 @@
+package clockskew
+
+import (
+	"expvar"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// The number of the latest samples of a gate the skew is estimated from.
+	WINDOW_SIZE = 32
+	// The skew beyond which the clock of a gate is not trusted, and the receive time is used instead.
+	DEFAULT_MAX_SKEW = 2 * time.Second
+)
+
+var clockSkews = expvar.NewMap("clock_skews")
+
+// The estimator of the clock skew of every gate, which is the offset to add to the trigger time of the gate to get the
+// time of the server.
+//
+// The offset of a sample is the receive time less the trigger time, which is the skew plus the delay of the network.
+// The delay is never negative and only sometimes large, so the smallest offset within the window is taken as the skew;
+// the window lets the estimate follow a clock that drifts or is set again.
+type Estimator struct {
+	mu      sync.Mutex
+	maxSkew time.Duration
+	gates   map[uint16]*window
+}
+
+type window struct {
+	samples [WINDOW_SIZE]time.Duration
+	next    int
+	count   int
+}
+
+func NewEstimator(maxSkew time.Duration) *Estimator {
+	return &Estimator{
+		maxSkew: maxSkew,
+		gates:   make(map[uint16]*window),
+	}
+}
+
+var instance = NewEstimator(DEFAULT_MAX_SKEW)
+
+func Get() *Estimator {
+	return instance
+}
+
+// Init replaces the estimator with one that falls back to the receive time beyond maxSkew, forgetting every sample.
+func Init(maxSkew time.Duration) {
+	instance = NewEstimator(maxSkew)
+}
+
+// Observe adds the sample of a packet of the gate triggered at triggerTime and received at receivedAt.
+func (e *Estimator) Observe(gateID uint16, triggerTime time.Time, receivedAt time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	w, ok := e.gates[gateID]
+	if !ok {
+		w = &window{}
+		e.gates[gateID] = w
+	}
+
+	w.samples[w.next] = receivedAt.Sub(triggerTime)
+	w.next = (w.next + 1) % WINDOW_SIZE
+	if w.count < WINDOW_SIZE {
+		w.count++
+	}
+
+	skew := &expvar.Int{}
+	skew.Set(w.skew().Milliseconds())
+	clockSkews.Set(gateIDKey(gateID), skew)
+}
+
+// Skew returns the estimated skew of the gate, and whether there are any samples of the gate.
+func (e *Estimator) Skew(gateID uint16) (time.Duration, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	w, ok := e.gates[gateID]
+	if !ok {
+		return 0, false
+	}
+	return w.skew(), true
+}
+
+// Correct returns the trigger time of the gate in the time of the server, falling back to receivedAt when the skew of
+// the gate is unknown or too large for its clock to be trusted.
+func (e *Estimator) Correct(gateID uint16, triggerTime time.Time, receivedAt time.Time) time.Time {
+	skew, ok := e.Skew(gateID)
+	if !ok || skew > e.maxSkew || skew < -e.maxSkew {
+		return receivedAt
+	}
+
+	corrected := triggerTime.Add(skew)
+	// the status cannot have been triggered after it was received
+	if corrected.After(receivedAt) {
+		return receivedAt
+	}
+	return corrected
+}
+
+func (w *window) skew() time.Duration {
+	skew := w.samples[0]
+	for _, sample := range w.samples[1:w.count] {
+		skew = min(skew, sample)
+	}
+	return skew
+}
+
+func gateIDKey(gateID uint16) string {
+	return strconv.FormatUint(uint64(gateID), 10)
+}
@@ -0,0 +1,78 @@
+package clockskew
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCorrect(t *testing.T) {
+	received := time.UnixMilli(1_700_000_000_000)
+
+	tests := []struct {
+		name string
+		// the offsets of the samples observed before the correction
+		offsets  []time.Duration
+		trigger  time.Time
+		expected time.Time
+	}{
+		{
+			name:     "no samples",
+			trigger:  received.Add(-300 * time.Millisecond),
+			expected: received,
+		},
+		{
+			name:     "smallest offset is the skew",
+			offsets:  []time.Duration{520 * time.Millisecond, 500 * time.Millisecond, 650 * time.Millisecond},
+			trigger:  received.Add(-800 * time.Millisecond),
+			expected: received.Add(-300 * time.Millisecond),
+		},
+		{
+			name:     "device clock ahead",
+			offsets:  []time.Duration{-1500 * time.Millisecond, -1480 * time.Millisecond},
+			trigger:  received.Add(1200 * time.Millisecond),
+			expected: received.Add(-300 * time.Millisecond),
+		},
+		{
+			name:     "skew too large",
+			offsets:  []time.Duration{time.Hour},
+			trigger:  received.Add(-time.Hour - 300*time.Millisecond),
+			expected: received,
+		},
+		{
+			name:     "never after received",
+			offsets:  []time.Duration{500 * time.Millisecond},
+			trigger:  received,
+			expected: received,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEstimator(DEFAULT_MAX_SKEW)
+			for _, offset := range tt.offsets {
+				e.Observe(1, received.Add(-offset), received)
+			}
+
+			if got := e.Correct(1, tt.trigger, received); !got.Equal(tt.expected) {
+				t.Errorf("Correct() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSkewFollowsWindow(t *testing.T) {
+	e := NewEstimator(DEFAULT_MAX_SKEW)
+	received := time.Now()
+
+	e.Observe(1, received.Add(-100*time.Millisecond), received)
+	for i := 0; i < WINDOW_SIZE; i++ {
+		e.Observe(1, received.Add(-time.Second), received)
+	}
+
+	if got, _ := e.Skew(1); got != time.Second {
+		t.Errorf("Skew() = %v, expected %v", got, time.Second)
+	}
+	if _, ok := e.Skew(2); ok {
+		t.Errorf("Skew() of an unseen gate is known, expected unknown")
+	}
+}
@@ -1,8 +1,11 @@
 package db
 
 import (
+	"fmt"
 	"log"
+	"log/slog"
 	"os"
+	"strings"
 	"time"
 
 	"gorm.io/driver/sqlite"
@@ -13,17 +16,23 @@ import (
 type User struct {
 	gorm.Model
 	Name        string       `gorm:"unique"`
+	TokenHash   string       `gorm:"index"` // hex encoded sha256 of the api token of the user; empty when the user has no token
 	DevicePairs []DevicePair `gorm:"foreignKey:OwnerID"`
+	Doors       []Door       `gorm:"foreignKey:OwnerID"`
 	Rooms       []Room       `gorm:"foreignKey:OwnerID"`
+	Zones       []Zone       `gorm:"foreignKey:OwnerID"`
 }
 
 type Device struct {
 	gorm.Model
-	GateID     uint16 `gorm:"unique"`
-	Status     uint8  // 1 is connected; 0 is disconnected
-	DeviceLogs []DeviceLog
+	GateID      uint16 `gorm:"unique"`
+	Status      uint8  // 1 is connected; 0 is disconnected
+	Secret      string // hex encoded secret the device signs its packets with; empty when the device has no secret
+	AuthCounter uint32 // counter of the last authenticated packet accepted from the device
+	DeviceLogs  []DeviceLog
 }
 
+// DevicePair is the door of an inner and an outer gate between two rooms, which is migrated into a Door on Init.
 type DevicePair struct {
 	gorm.Model
 	InnerGateID uint
@@ -35,19 +44,205 @@ type DevicePair struct {
 	OuterRoomID uint
 	OuterRoom   Room `gorm:"foreignKey:OuterRoomID"`
 	OwnerID     uint
+	// the timing of the door in milliseconds; nil when the door uses the default timing
+	AllowanceFrameMs *uint32
+	PassFrameMs      *uint32
+}
+
+// Door is a passage between rooms made of gates in order from its outer side to its inner side, opening into a room at
+// either end and optionally between its gates.
+type Door struct {
+	gorm.Model
+	Name    string
+	OwnerID uint
+	Gates   []DoorGate
+	Rooms   []DoorRoom
+	// the device pair the door was migrated from; nil when the door was not migrated
+	DevicePairID *uint `gorm:"unique"`
+	// the timing of the door in milliseconds; nil when the door uses the default timing
+	AllowanceFrameMs *uint32
+	PassFrameMs      *uint32
+}
+
+// DoorGate is the gate of the device at the position of the door, counting from 0 at the outer side.
+type DoorGate struct {
+	gorm.Model
+	DoorID   uint  `gorm:"uniqueIndex:idx_door_gates_position"`
+	Position uint8 `gorm:"uniqueIndex:idx_door_gates_position"`
+	DeviceID uint
+	Device   Device
+}
+
+// DoorRoom is the room the door opens into at the position, which is between the gates at position - 1 and position, so
+// the outer room is at 0 and the inner room is at the number of gates. The positions between the gates without a room
+// are inside the door.
+type DoorRoom struct {
+	gorm.Model
+	DoorID   uint  `gorm:"uniqueIndex:idx_door_rooms_position"`
+	Position uint8 `gorm:"uniqueIndex:idx_door_rooms_position"`
+	RoomID   uint
+	Room     Room
+}
+
+// PreloadDoor is the scope that loads the gates of the doors in order with their devices, and the rooms of the doors.
+func PreloadDoor(tx *gorm.DB) *gorm.DB {
+	return tx.
+		Preload("Gates", func(tx *gorm.DB) *gorm.DB { return tx.Order("position") }).
+		Preload("Gates.Device").
+		Preload("Rooms")
+}
+
+// RoomAt returns the ID of the room at the position of the door, which must have its Rooms loaded, and whether the door
+// opens into a room at the position.
+func (d *Door) RoomAt(position int) (uint, bool) {
+	for _, room := range d.Rooms {
+		if int(room.Position) == position {
+			return room.RoomID, true
+		}
+	}
+	return 0, false
+}
+
+// OuterRoomID returns the ID of the room at the outer side of the door, which must have its Gates and Rooms loaded.
+func (d *Door) OuterRoomID() uint {
+	roomID, _ := d.RoomAt(0)
+	return roomID
+}
+
+// InnerRoomID returns the ID of the room at the inner side of the door, which must have its Gates and Rooms loaded.
+func (d *Door) InnerRoomID() uint {
+	roomID, _ := d.RoomAt(len(d.Gates))
+	return roomID
 }
 
 type Room struct {
 	gorm.Model
-	Name           string
-	OwnerID        uint
-	RoomPopulation RoomPopulation
+	Name             string
+	OwnerID          uint
+	Capacity         uint32 // the most people the room may hold; 0 when the room has no capacity
+	WarningThreshold uint32 // the population the room is near its capacity at; 0 when the room is not warned on
+	ZoneID           *uint  // the floor or building the room is in; nil when the room is not in a zone
+	RoomPopulation   RoomPopulation
+}
+
+// Zone groups the rooms into a hierarchy of buildings and floors, where a floor is always in a building.
+type Zone struct {
+	gorm.Model
+	Name     string
+	ZoneType uint8 // 1 is building; 2 is floor
+	ParentID *uint // the building of a floor; nil for a building
+	OwnerID  uint
+	Zones    []Zone `gorm:"foreignKey:ParentID"`
+	Rooms    []Room
+}
+
+// OccupancyPercent returns the population of the room, which must have its RoomPopulation loaded, as a percentage of its
+// capacity, and whether the room has a capacity.
+func (r *Room) OccupancyPercent() (float64, bool) {
+	if r.Capacity == 0 {
+		return 0, false
+	}
+	return float64(r.RoomPopulation.Population) * 100 / float64(r.Capacity), true
 }
 
 type RoomPopulation struct {
 	gorm.Model
-	Population uint32
-	RoomID     uint
+	Population uint32 // the population that is displayed, which is never below 0
+	// the net of the passes into and out of the room, which is below the population when decrements were made while the
+	// room was already empty
+	NetPopulation int32
+	// 0 is under the warning threshold; 1 is at the warning threshold; 2 is over capacity, which is only lowered once the
+	// population is below the threshold of the level by more than the hysteresis
+	CapacityLevel uint8
+	RoomID        uint
+}
+
+// Drift returns how many decrements of the room were lost while it was empty, which is 0 for a room that was never
+// miscounted.
+func (p *RoomPopulation) Drift() int64 {
+	return int64(p.Population) - int64(p.NetPopulation)
+}
+
+// OccupancySnapshot is the population of the room after it changed, which the occupancy history is compacted from.
+type OccupancySnapshot struct {
+	ID            uint `gorm:"primarykey"`
+	RoomID        uint `gorm:"index:idx_occupancy_snapshot_room_time"`
+	Population    uint32
+	NetPopulation int32
+	RecordedAt    time.Time `gorm:"index:idx_occupancy_snapshot_room_time"`
+}
+
+// OccupancyAggregate is the population of the room over the time from StartTime for the length of its resolution.
+type OccupancyAggregate struct {
+	ID         uint      `gorm:"primarykey"`
+	RoomID     uint      `gorm:"uniqueIndex:idx_occupancy_aggregate_bucket"`
+	Resolution uint8     `gorm:"uniqueIndex:idx_occupancy_aggregate_bucket"` // 1 is minute; 2 is hour; 3 is day
+	StartTime  time.Time `gorm:"uniqueIndex:idx_occupancy_aggregate_bucket"`
+	Min        uint32
+	Max        uint32
+	Avg        float64 // weighted by how long each population was held
+}
+
+// PopulationSchedule resets the population of the room at the times of its cron expression.
+type PopulationSchedule struct {
+	gorm.Model
+	RoomID uint
+	// the minute, hour, day of month, month and day of week the population is reset at, in the local time of the server
+	Expression string
+	Population uint32 // the population the room is reset to
+	// skip the reset while the room is occupied by its occupancy calendar
+	SkipWhileOccupied bool
+	LastRunAt         *time.Time // nil when the schedule has never run
+}
+
+// RoomOccupancy is an entry of the occupancy calendar of the room, such as a booking, while which the room is known to be
+// occupied.
+type RoomOccupancy struct {
+	gorm.Model
+	RoomID    uint
+	Name      string
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+// PopulationCorrection replaces the population of the room outside of the passes, recording the populations it replaced
+// for auditing.
+type PopulationCorrection struct {
+	gorm.Model
+	RoomID           uint
+	Source           uint8 // 1 is scheduled reset; 2 is manual; 3 is rebuild
+	ScheduleID       *uint // nil when the correction is not a scheduled reset
+	UserID           *uint // the user that made the correction; nil when the correction is not made by a user
+	OldPopulation    uint32
+	OldNetPopulation int32
+	NewPopulation    uint32
+	Reason           string
+	Skipped          bool // the correction was skipped, so the population was not replaced
+}
+
+type Alert struct {
+	gorm.Model
+	AlertType   uint8 // 1 is negative population; 2 is capacity warning; 3 is over capacity
+	RoomID      uint
+	DoorID      *uint // the door of the pass that raised the alert; nil when the alert was not raised by a pass
+	PassEventID *uint
+	// the population of the room when the alert was raised, which is the net population for a negative population
+	Population int32
+}
+
+// PassEvent is a person moving through a door from one room to another, which is recorded in the same transaction as the
+// populations of the rooms are changed.
+type PassEvent struct {
+	gorm.Model
+	DoorID     uint
+	FromRoomID *uint     // nil when the pass is a manual increment, which only enters the inner room
+	ToRoomID   *uint     // nil when the pass is a manual decrement, which only leaves the inner room
+	Direction  uint8     // 1 is in; 2 is out
+	StartTime  time.Time // time the person started passing the door
+	EndTime    time.Time `gorm:"index"` // time the person finished passing the door
+	Source     uint8     // 1 is IR pass; 2 is manual button
+	Confidence float64
+	Flagged    bool // flagged for review, so the populations of the rooms were not changed
 }
 
 type DeviceLog struct {
@@ -56,6 +251,34 @@ type DeviceLog struct {
 	LogType     uint8      // 1 is heartbeat; 2 is gate status; 3 is increment; 4 is decrement
 	Status      *uint8     // 1 is turn on; 2 is unblocked; 3 is blocked; 4 is faulty; nil when log not status
 	TriggerTime *time.Time // trigger time of status; nil when log not status
+	// time the packet of the log was received, which the log may be inserted well after; nil for the logs from before it
+	// was recorded, which were inserted as they were received
+	ReceivedAt *time.Time
+}
+
+type DeviceCommand struct {
+	gorm.Model
+	DeviceID    uint
+	Device      Device
+	CommandType uint8  // 6 is reboot; 7 is set heartbeat interval; 8 is set IR sensitivity; 9 is identify
+	Argument    uint16 // argument of the command; 0 when the command has no argument
+	Sequence    uint16 // sequence number the device acknowledges the command with
+	Status      uint8  // 1 is queued; 2 is sent; 3 is acked; 4 is timed out
+	SentAt      *time.Time
+	AckedAt     *time.Time
+}
+
+type DeadLetter struct {
+	gorm.Model
+	Raw        []byte // the packet as it was framed by the device
+	RemoteAddr string
+	ReceivedAt time.Time
+	GateID     *uint16 // nil when the gate ID could not be read from the packet
+	PacketType uint8
+	Reason     uint8 // 1 is undecodable; 2 is unhandled packet type; 3 is unknown device
+	Error      string
+	Status     uint8 // 1 is pending; 2 is redriven
+	RedrivenAt *time.Time
 }
 
 var instance *gorm.DB
@@ -64,7 +287,7 @@ func Get() *gorm.DB {
 	return instance
 }
 
-func Init() error {
+func Init(path string) error {
 	newLogger := logger.New(
 		log.New(os.Stdout, "\n", log.LstdFlags), // io writer
 		logger.Config{
@@ -76,7 +299,14 @@ func Init() error {
 		},
 	)
 
-	newDB, err := gorm.Open(sqlite.Open("rewired.db"), &gorm.Config{
+	// the packets are handled by several workers in parallel, so the writers wait on the lock instead of failing, and
+	// transactions take the write lock up front to avoid deadlocking on upgrading a read lock
+	dsn := path + "?_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate"
+	if strings.Contains(path, "?") {
+		dsn = path + "&_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate"
+	}
+
+	newDB, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
 		Logger: newLogger,
 	})
 	if err != nil {
@@ -93,5 +323,82 @@ func Init() error {
 }
 
 func autoMigrate(db *gorm.DB) error {
-	return db.AutoMigrate(&User{}, &Device{}, &DevicePair{}, &Room{}, &RoomPopulation{}, &DeviceLog{})
+	// the net population is only added to the rooms that existed before it, so it starts at their population
+	hasNetPopulation := db.Migrator().HasColumn(&RoomPopulation{}, "NetPopulation")
+	hasRoomPopulations := db.Migrator().HasTable(&RoomPopulation{})
+
+	err := db.AutoMigrate(
+		&User{},
+		&Device{},
+		&DevicePair{},
+		&Door{},
+		&DoorGate{},
+		&DoorRoom{},
+		&Zone{},
+		&Room{},
+		&RoomPopulation{},
+		&PassEvent{},
+		&Alert{},
+		&PopulationSchedule{},
+		&RoomOccupancy{},
+		&PopulationCorrection{},
+		&OccupancySnapshot{},
+		&OccupancyAggregate{},
+		&DeviceLog{},
+		&DeviceCommand{},
+		&DeadLetter{},
+	)
+	if err != nil {
+		return err
+	}
+
+	if hasRoomPopulations && !hasNetPopulation {
+		result := db.Session(&gorm.Session{AllowGlobalUpdate: true}).
+			Model(&RoomPopulation{}).
+			Update("net_population", gorm.Expr("population"))
+		if result.Error != nil {
+			return result.Error
+		}
+	}
+
+	return migrateDevicePairs(db)
+}
+
+// migrateDevicePairs creates the door of every device pair that does not have a door yet, with the outer gate at
+// position 0 and the inner gate at position 1.
+func migrateDevicePairs(db *gorm.DB) error {
+	devicePairs := []DevicePair{}
+	result := db.Where("id NOT IN (?)", db.Model(&Door{}).Where("device_pair_id IS NOT NULL").Select("device_pair_id")).
+		Find(&devicePairs)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, devicePair := range devicePairs {
+			devicePairID := devicePair.ID
+			door := &Door{
+				Name:         fmt.Sprintf("door %d", devicePair.ID),
+				OwnerID:      devicePair.OwnerID,
+				DevicePairID: &devicePairID,
+				Gates: []DoorGate{
+					{Position: 0, DeviceID: devicePair.OuterGateID},
+					{Position: 1, DeviceID: devicePair.InnerGateID},
+				},
+				Rooms: []DoorRoom{
+					{Position: 0, RoomID: devicePair.OuterRoomID},
+					{Position: 2, RoomID: devicePair.InnerRoomID},
+				},
+				AllowanceFrameMs: devicePair.AllowanceFrameMs,
+				PassFrameMs:      devicePair.PassFrameMs,
+			}
+			if err := tx.Create(door).Error; err != nil {
+				return err
+			}
+
+			slog.Info("migrated device pair into door", "devicePairID", devicePair.ID, "doorID", door.ID)
+		}
+
+		return nil
+	})
 }
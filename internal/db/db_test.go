@@ -0,0 +1,83 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrateDevicePairs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rewired.db")
+	if err := Init(path); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+
+	passFrameMs := uint32(1500)
+	devicePair := &DevicePair{InnerGateID: 1, OuterGateID: 2, InnerRoomID: 3, OuterRoomID: 4, OwnerID: 5, PassFrameMs: &passFrameMs}
+	if err := Get().Create(devicePair).Error; err != nil {
+		t.Fatalf("failed to create device pair: %v", err)
+	}
+
+	// the device pair is migrated on the next Init, and only once
+	for i := 0; i < 2; i++ {
+		if err := Init(path); err != nil {
+			t.Fatalf("Init() error = %v", err)
+		}
+	}
+
+	doors := []Door{}
+	if err := Get().Preload("Gates").Preload("Rooms").Find(&doors).Error; err != nil {
+		t.Fatalf("failed to find doors: %v", err)
+	}
+	if len(doors) != 1 {
+		t.Fatalf("doors = %d, expected 1", len(doors))
+	}
+
+	door := doors[0]
+	if door.DevicePairID == nil || *door.DevicePairID != devicePair.ID || door.OwnerID != 5 {
+		t.Errorf("door = %+v, expected migrated from device pair %d", door, devicePair.ID)
+	}
+	if door.PassFrameMs == nil || *door.PassFrameMs != 1500 || door.AllowanceFrameMs != nil {
+		t.Errorf("door timing = (%v, %v), expected (nil, 1500)", door.AllowanceFrameMs, door.PassFrameMs)
+	}
+	if len(door.Gates) != 2 {
+		t.Fatalf("door gates = %d, expected 2", len(door.Gates))
+	}
+	for _, gate := range door.Gates {
+		if gate.Position == 0 && gate.DeviceID != 2 || gate.Position == 1 && gate.DeviceID != 1 {
+			t.Errorf("door gate = %+v, expected the outer gate at 0 and the inner gate at 1", gate)
+		}
+	}
+	if door.OuterRoomID() != 4 || door.InnerRoomID() != 3 {
+		t.Errorf("door rooms = (%d, %d), expected (4, 3)", door.OuterRoomID(), door.InnerRoomID())
+	}
+	if _, ok := door.RoomAt(1); ok {
+		t.Errorf("RoomAt(1) = true, expected no room inside the door")
+	}
+}
+
+func TestMigrateNetPopulation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rewired.db")
+	if err := Init(path); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+
+	// the room population is from before the net population was tracked
+	if err := Get().Create(&RoomPopulation{RoomID: 1, Population: 3}).Error; err != nil {
+		t.Fatalf("failed to create room population: %v", err)
+	}
+	if err := Get().Migrator().DropColumn(&RoomPopulation{}, "NetPopulation"); err != nil {
+		t.Fatalf("failed to drop net population: %v", err)
+	}
+
+	if err := Init(path); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+
+	roomPopulation := &RoomPopulation{}
+	if err := Get().First(roomPopulation).Error; err != nil {
+		t.Fatalf("failed to find room population: %v", err)
+	}
+	if roomPopulation.NetPopulation != 3 || roomPopulation.Drift() != 0 {
+		t.Errorf("net population = %d, expected 3 without drift", roomPopulation.NetPopulation)
+	}
+}
@@ -0,0 +1,136 @@
+package deadletter
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+	"github.com/kKar1503/rewired-server-2024/internal/packet"
+)
+
+var ErrAlreadyRedriven = errors.New("dead letter was already redriven")
+
+// The reason a packet was dead lettered.
+type Reason uint8
+
+const (
+	ReasonUndecodable Reason = iota + 1
+	ReasonUnhandled
+	ReasonUnknownDevice
+)
+
+func (r Reason) String() string {
+	switch r {
+	case ReasonUndecodable:
+		return "undecodable"
+	case ReasonUnhandled:
+		return "unhandled"
+	case ReasonUnknownDevice:
+		return "unknown-device"
+	default:
+		return "unknown"
+	}
+}
+
+// The status of a db.DeadLetter.
+type Status uint8
+
+const (
+	StatusPending Status = iota + 1
+	StatusRedriven
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusPending:
+		return "pending"
+	case StatusRedriven:
+		return "redriven"
+	default:
+		return "unknown"
+	}
+}
+
+// Store persists the packet that could not be handled as it was received, with the reason and error it failed with.
+func Store(rawPacket *packet.RawPacket, reason Reason, cause error) error {
+	// the packets that were not received are marshalled instead, such as the packets built by the tests
+	raw := rawPacket.Frame()
+	if raw == nil {
+		var err error
+		if raw, err = rawPacket.MarshalBinary(); err != nil {
+			return err
+		}
+	}
+
+	deadLetter := &db.DeadLetter{
+		Raw:        raw,
+		RemoteAddr: rawPacket.RemoteAddr,
+		ReceivedAt: rawPacket.ReceivedAt,
+		PacketType: uint8(rawPacket.PacketType),
+		Reason:     uint8(reason),
+		Error:      cause.Error(),
+		Status:     uint8(StatusPending),
+	}
+	if gateID, err := rawPacket.GateID(); err == nil {
+		deadLetter.GateID = &gateID
+	}
+	if deadLetter.ReceivedAt.IsZero() {
+		deadLetter.ReceivedAt = time.Now()
+	}
+
+	return db.Get().Create(deadLetter).Error
+}
+
+// Redrive decodes the packet of the dead letter and passes it to the packetsEgress to be handled again, marking the
+// dead letter as redriven. The packet is dead lettered again as a new dead letter when it still fails.
+//
+// The dead letter is put back to pending when the context is done before the packet could be passed.
+func Redrive(ctx context.Context, deadLetter *db.DeadLetter, packetsEgress chan<- *packet.RawPacket) error {
+	if Status(deadLetter.Status) == StatusRedriven {
+		return ErrAlreadyRedriven
+	}
+
+	rawPacket := &packet.RawPacket{}
+	if err := rawPacket.UnmarshalBinary(deadLetter.Raw); err != nil {
+		return err
+	}
+	rawPacket.RemoteAddr = deadLetter.RemoteAddr
+	rawPacket.ReceivedAt = deadLetter.ReceivedAt
+	rawPacket.Redriven = true
+
+	// the dead letter is only marked when it was still pending, so concurrent redrives pass the packet once
+	now := time.Now()
+	result := db.Get().
+		Model(&db.DeadLetter{}).
+		Where("id = ? AND status = ?", deadLetter.ID, uint8(StatusPending)).
+		Updates(&db.DeadLetter{Status: uint8(StatusRedriven), RedrivenAt: &now})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrAlreadyRedriven
+	}
+
+	select {
+	case packetsEgress <- rawPacket:
+	case <-ctx.Done():
+		result = db.Get().
+			Model(&db.DeadLetter{}).
+			Where("id = ? AND status = ?", deadLetter.ID, uint8(StatusRedriven)).
+			Select("Status", "RedrivenAt").
+			Updates(&db.DeadLetter{Status: uint8(StatusPending), RedrivenAt: nil})
+		if result.Error != nil {
+			slog.Error("failed to put the dead letter back to pending", "error", result.Error, "id", deadLetter.ID)
+		}
+		return ctx.Err()
+	}
+
+	deadLetter.Status = uint8(StatusRedriven)
+	deadLetter.RedrivenAt = &now
+
+	slog.Info("redriven dead letter", "id", deadLetter.ID, "packetType", rawPacket.PacketType)
+
+	return nil
+}
@@ -0,0 +1,195 @@
+package deadletter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+	"github.com/kKar1503/rewired-server-2024/internal/packet"
+)
+
+var errCause = errors.New("device is not registered")
+
+func setup(t *testing.T) {
+	t.Helper()
+
+	if err := db.Init(filepath.Join(t.TempDir(), "rewired.db")); err != nil {
+		t.Fatalf("db.Init() error = %v", err)
+	}
+}
+
+func heartbeat(t *testing.T, version byte, gateID uint16, secret []byte) []byte {
+	t.Helper()
+
+	heartbeatPacket := &packet.HeartbeatPacket{
+		RawPacket: packet.RawPacket{Version: version, PacketType: packet.PacketTypeHeartbeat},
+	}
+	heartbeatPacket.SetGateID(gateID)
+
+	if secret != nil {
+		if err := heartbeatPacket.Sign(secret, 7); err != nil {
+			t.Fatalf("Sign() error = %v", err)
+		}
+	}
+
+	data, err := heartbeatPacket.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary() error = %v", err)
+	}
+
+	return data
+}
+
+// received unmarshals the data as the packet received by a server.
+func received(t *testing.T, data []byte) *packet.RawPacket {
+	t.Helper()
+
+	rawPacket := &packet.RawPacket{}
+	if err := rawPacket.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary() error = %v", err)
+	}
+	rawPacket.RemoteAddr = "127.0.0.1:5000"
+	rawPacket.ReceivedAt = time.Unix(1716912942, 0)
+
+	return rawPacket
+}
+
+func TestStore(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"Version 1", heartbeat(t, packet.VERSION_1, 1, nil)},
+		{"Version 2", heartbeat(t, packet.VERSION_2, 2, nil)},
+		{"Signed", heartbeat(t, packet.VERSION_2, 3, []byte("0123456789abcdef"))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setup(t)
+
+			rawPacket := received(t, tt.data)
+			if err := Store(rawPacket, ReasonUnknownDevice, errCause); err != nil {
+				t.Fatalf("Store() error = %v", err)
+			}
+
+			deadLetter := &db.DeadLetter{}
+			if err := db.Get().First(deadLetter).Error; err != nil {
+				t.Fatalf("First() error = %v", err)
+			}
+
+			if !bytes.Equal(deadLetter.Raw, tt.data) {
+				t.Errorf("Raw = %X, expected %X", deadLetter.Raw, tt.data)
+			}
+
+			gateID, _ := rawPacket.GateID()
+			if deadLetter.GateID == nil || *deadLetter.GateID != gateID {
+				t.Errorf("GateID = %v, expected %d", deadLetter.GateID, gateID)
+			}
+
+			if Status(deadLetter.Status) != StatusPending || Reason(deadLetter.Reason) != ReasonUnknownDevice {
+				t.Errorf("Status, Reason = %v, %v, expected %v, %v",
+					Status(deadLetter.Status),
+					Reason(deadLetter.Reason),
+					StatusPending,
+					ReasonUnknownDevice,
+				)
+			}
+
+			if deadLetter.Error != errCause.Error() || deadLetter.RemoteAddr != rawPacket.RemoteAddr ||
+				!deadLetter.ReceivedAt.Equal(rawPacket.ReceivedAt) {
+				t.Errorf("dead letter = %+v, expected the error, remote address and receive time of the packet", deadLetter)
+			}
+		})
+	}
+}
+
+func TestRedrive(t *testing.T) {
+	data := heartbeat(t, packet.VERSION_2, 1, nil)
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name    string
+		status  Status
+		raw     []byte
+		ctx     context.Context
+		reader  bool
+		wantErr error
+		// the status of the dead letter in the database afterwards
+		expected Status
+	}{
+		{"Pending", StatusPending, data, context.Background(), true, nil, StatusRedriven},
+		{"Already Redriven", StatusRedriven, data, context.Background(), true, ErrAlreadyRedriven, StatusRedriven},
+		{"Context Done", StatusPending, data, canceled, false, context.Canceled, StatusPending},
+		{"Undecodable", StatusPending, data[:len(data)-1], context.Background(), true, packet.ErrInvalidBinarySize, StatusPending},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setup(t)
+
+			deadLetter := &db.DeadLetter{
+				Raw:        tt.raw,
+				RemoteAddr: "127.0.0.1:5000",
+				ReceivedAt: time.Unix(1716912942, 0),
+				Reason:     uint8(ReasonUnknownDevice),
+				Status:     uint8(tt.status),
+			}
+			if err := db.Get().Create(deadLetter).Error; err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+
+			// the packets are only read when there is a reader, otherwise the egress is never ready
+			packetsEgress := make(chan *packet.RawPacket)
+			redriven := make(chan *packet.RawPacket, 1)
+			if tt.reader {
+				go func() {
+					select {
+					case rawPacket := <-packetsEgress:
+						redriven <- rawPacket
+					case <-time.After(time.Second):
+					}
+				}()
+			}
+
+			err := Redrive(tt.ctx, deadLetter, packetsEgress)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Redrive() error = %v, expected %v", err, tt.wantErr)
+			}
+
+			stored := &db.DeadLetter{}
+			if err := db.Get().First(stored, deadLetter.ID).Error; err != nil {
+				t.Fatalf("First() error = %v", err)
+			}
+			if Status(stored.Status) != tt.expected {
+				t.Errorf("Status = %v, expected %v", Status(stored.Status), tt.expected)
+			}
+			if (stored.RedrivenAt != nil) != (tt.expected == StatusRedriven && tt.status == StatusPending) {
+				t.Errorf("RedrivenAt = %v, expected set only once redriven", stored.RedrivenAt)
+			}
+
+			if tt.wantErr != nil {
+				return
+			}
+
+			rawPacket := <-redriven
+			if !bytes.Equal(rawPacket.Frame(), data) {
+				t.Errorf("redriven packet = %X, expected %X", rawPacket.Frame(), data)
+			}
+			if rawPacket.RemoteAddr != deadLetter.RemoteAddr || !rawPacket.ReceivedAt.Equal(deadLetter.ReceivedAt) {
+				t.Errorf("redriven packet from %v at %v, expected %v at %v",
+					rawPacket.RemoteAddr,
+					rawPacket.ReceivedAt,
+					deadLetter.RemoteAddr,
+					deadLetter.ReceivedAt,
+				)
+			}
+		})
+	}
+}
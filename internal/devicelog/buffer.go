@@ -0,0 +1,187 @@
+package devicelog
+
+import (
+	"context"
+	"expvar"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+	"gorm.io/gorm"
+)
+
+const (
+	DEFAULT_BATCH_SIZE     = 100
+	DEFAULT_FLUSH_INTERVAL = 200 * time.Millisecond
+	// The number of batches the buffer holds before the appended logs are dropped, so the buffer does not grow without
+	// bound while the database is unavailable.
+	MAX_BUFFERED_BATCHES = 10
+	// The number of times a batch is attempted to be inserted before it is dropped.
+	MAX_FLUSH_ATTEMPTS = 3
+)
+
+var deviceLogsDropped = expvar.NewInt("device_logs_dropped")
+
+func init() {
+	expvar.Publish("device_log_queue_length", expvar.Func(func() any {
+		return Get().Len()
+	}))
+}
+
+// The write-behind buffer of the device logs, which inserts the logs in batches instead of one at a time.
+//
+// The device logs are only for record keeping, so the population updates are not held back by them; the logs are
+// inserted in the order they were appended, and dropped when the buffer holds MAX_BUFFERED_BATCHES batches.
+type Buffer struct {
+	mu        sync.Mutex
+	logs      []*db.DeviceLog
+	batchSize int
+	capacity  int
+	full      chan struct{}
+	// flushes are serialised so that the batches are inserted in order
+	flushMu sync.Mutex
+	// the number of times the logs at the front of the buffer failed to be inserted, guarded by flushMu
+	attempts int
+	dropped  atomic.Int64
+}
+
+func NewBuffer(batchSize int) *Buffer {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	return &Buffer{
+		batchSize: batchSize,
+		capacity:  batchSize * MAX_BUFFERED_BATCHES,
+		full:      make(chan struct{}, 1),
+	}
+}
+
+var instance = NewBuffer(DEFAULT_BATCH_SIZE)
+
+func Get() *Buffer {
+	return instance
+}
+
+// Init replaces the buffer with one that flushes when it holds batchSize logs, flushing the current buffer first.
+func Init(batchSize int) error {
+	if err := instance.Flush(); err != nil {
+		return err
+	}
+
+	instance = NewBuffer(batchSize)
+	return nil
+}
+
+// Append queues the device log to be inserted with the next batch, dropping it when the buffer is full.
+func (b *Buffer) Append(deviceLog *db.DeviceLog) {
+	b.mu.Lock()
+	if len(b.logs) >= b.capacity {
+		b.mu.Unlock()
+
+		b.drop(1)
+		slog.Warn("dropped device log on full buffer", "deviceID", deviceLog.DeviceID, "logType", deviceLog.LogType)
+		return
+	}
+
+	b.logs = append(b.logs, deviceLog)
+	full := len(b.logs) >= b.batchSize
+	b.mu.Unlock()
+
+	if full {
+		select {
+		case b.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Dropped returns the number of logs the buffer dropped, either on a full buffer or after failing to be inserted.
+func (b *Buffer) Dropped() int64 {
+	return b.dropped.Load()
+}
+
+func (b *Buffer) drop(count int64) {
+	b.dropped.Add(count)
+	deviceLogsDropped.Add(count)
+}
+
+func (b *Buffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.logs)
+}
+
+// Flush inserts the queued device logs in a single transaction. The logs are put back into the buffer to be retried by
+// the next flush when the insert fails, and are dropped after MAX_FLUSH_ATTEMPTS, as they are only for record keeping.
+func (b *Buffer) Flush() error {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.mu.Lock()
+	logs := b.logs
+	b.logs = nil
+	b.mu.Unlock()
+
+	if len(logs) == 0 {
+		return nil
+	}
+
+	err := db.Get().Transaction(func(tx *gorm.DB) error {
+		return tx.CreateInBatches(logs, b.batchSize).Error
+	})
+	if err != nil {
+		b.retry(logs, err)
+		return err
+	}
+
+	b.attempts = 0
+	return nil
+}
+
+// retry puts the logs that failed to be inserted back in front of the logs appended since, unless they failed too many
+// times. The caller must hold flushMu.
+func (b *Buffer) retry(logs []*db.DeviceLog, err error) {
+	b.attempts++
+	if b.attempts >= MAX_FLUSH_ATTEMPTS {
+		b.attempts = 0
+		b.drop(int64(len(logs)))
+		slog.Error("failed to insert the device logs, dropping them", "error", err, "count", len(logs))
+		return
+	}
+
+	slog.Warn("failed to insert the device logs, retrying", "error", err, "count", len(logs), "attempts", b.attempts)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.logs = append(logs, b.logs...)
+
+	// the logs appended last are dropped when the buffer filled up in the meantime, so the oldest are still in order
+	if overflow := len(b.logs) - b.capacity; overflow > 0 {
+		b.logs = b.logs[:b.capacity]
+		b.drop(int64(overflow))
+		slog.Warn("dropped device logs on full buffer", "count", overflow)
+	}
+}
+
+// Run flushes the buffer every interval, or as soon as a batch is full, until the context is done. The logs appended
+// after Run returns are left for a final Flush.
+func (b *Buffer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-b.full:
+		}
+
+		b.Flush()
+	}
+}
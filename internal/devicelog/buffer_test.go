@@ -0,0 +1,189 @@
+package devicelog
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+)
+
+func setup(t *testing.T) {
+	t.Helper()
+
+	if err := db.Init(filepath.Join(t.TempDir(), "rewired.db")); err != nil {
+		t.Fatalf("db.Init() error = %v", err)
+	}
+}
+
+func countDeviceLogs(t *testing.T) int64 {
+	t.Helper()
+
+	var count int64
+	if err := db.Get().Model(&db.DeviceLog{}).Count(&count).Error; err != nil {
+		t.Fatalf("failed to count device logs: %v", err)
+	}
+
+	return count
+}
+
+func TestFlushKeepsOrder(t *testing.T) {
+	setup(t)
+
+	buffer := NewBuffer(10)
+	for logType := uint8(1); logType <= 4; logType++ {
+		buffer.Append(&db.DeviceLog{DeviceID: 1, LogType: logType})
+	}
+
+	if length := buffer.Len(); length != 4 {
+		t.Errorf("Len() = %d, expected 4", length)
+	}
+
+	if count := countDeviceLogs(t); count != 0 {
+		t.Errorf("inserted %d device logs before Flush(), expected 0", count)
+	}
+
+	if err := buffer.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+
+	if length := buffer.Len(); length != 0 {
+		t.Errorf("Len() after Flush() = %d, expected 0", length)
+	}
+
+	deviceLogs := []db.DeviceLog{}
+	if err := db.Get().Order("id").Find(&deviceLogs).Error; err != nil {
+		t.Fatalf("failed to find device logs: %v", err)
+	}
+	for i, deviceLog := range deviceLogs {
+		if deviceLog.LogType != uint8(i+1) {
+			t.Errorf("device log %d has log type %d, expected %d", i, deviceLog.LogType, i+1)
+		}
+	}
+}
+
+func TestRunFlushesFullBatch(t *testing.T) {
+	setup(t)
+
+	buffer := NewBuffer(3)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// the interval is long enough that only a full batch can trigger the flush
+	go buffer.Run(ctx, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		buffer.Append(&db.DeviceLog{DeviceID: 1, LogType: 1})
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for countDeviceLogs(t) != 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the full batch to be inserted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAppendDropsWhenFull(t *testing.T) {
+	setup(t)
+
+	buffer := NewBuffer(2)
+	dropped := deviceLogsDropped.Value()
+
+	for i := 0; i < 2*MAX_BUFFERED_BATCHES+3; i++ {
+		buffer.Append(&db.DeviceLog{DeviceID: 1, LogType: 1})
+	}
+
+	if length := buffer.Len(); length != 2*MAX_BUFFERED_BATCHES {
+		t.Errorf("Len() = %d, expected %d", length, 2*MAX_BUFFERED_BATCHES)
+	}
+
+	if got := deviceLogsDropped.Value() - dropped; got != 3 {
+		t.Errorf("dropped = %d, expected 3", got)
+	}
+	if got := buffer.Dropped(); got != 3 {
+		t.Errorf("Dropped() = %d, expected 3", got)
+	}
+}
+
+// closeDB closes the connection of the database, so that every insert fails until the database is set up again.
+func closeDB(t *testing.T) {
+	t.Helper()
+
+	sqlDB, err := db.Get().DB()
+	if err != nil {
+		t.Fatalf("DB() error = %v", err)
+	}
+	if err := sqlDB.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+}
+
+func TestFlushRetriesFailedBatch(t *testing.T) {
+	setup(t)
+	closeDB(t)
+
+	buffer := NewBuffer(10)
+	buffer.Append(&db.DeviceLog{DeviceID: 1, LogType: 1})
+	buffer.Append(&db.DeviceLog{DeviceID: 1, LogType: 2})
+
+	if err := buffer.Flush(); err == nil {
+		t.Fatalf("Flush() error = nil, expected error")
+	}
+
+	if length := buffer.Len(); length != 2 {
+		t.Errorf("Len() after failed Flush() = %d, expected 2", length)
+	}
+
+	buffer.Append(&db.DeviceLog{DeviceID: 1, LogType: 3})
+
+	setup(t)
+	if err := buffer.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+
+	deviceLogs := []db.DeviceLog{}
+	if err := db.Get().Order("id").Find(&deviceLogs).Error; err != nil {
+		t.Fatalf("failed to find device logs: %v", err)
+	}
+	if len(deviceLogs) != 3 {
+		t.Fatalf("inserted %d device logs, expected 3", len(deviceLogs))
+	}
+	for i, deviceLog := range deviceLogs {
+		if deviceLog.LogType != uint8(i+1) {
+			t.Errorf("device log %d has log type %d, expected %d", i, deviceLog.LogType, i+1)
+		}
+	}
+}
+
+func TestFlushDropsAfterMaxAttempts(t *testing.T) {
+	setup(t)
+	closeDB(t)
+
+	buffer := NewBuffer(10)
+	buffer.Append(&db.DeviceLog{DeviceID: 1, LogType: 1})
+	dropped := deviceLogsDropped.Value()
+
+	for attempt := 1; attempt <= MAX_FLUSH_ATTEMPTS; attempt++ {
+		if err := buffer.Flush(); err == nil {
+			t.Fatalf("Flush() error = nil, expected error")
+		}
+
+		expected := 1
+		if attempt == MAX_FLUSH_ATTEMPTS {
+			expected = 0
+		}
+		if length := buffer.Len(); length != expected {
+			t.Errorf("Len() after attempt %d = %d, expected %d", attempt, length, expected)
+		}
+	}
+
+	if got := deviceLogsDropped.Value() - dropped; got != 1 {
+		t.Errorf("dropped = %d, expected 1", got)
+	}
+	if got := buffer.Dropped(); got != 1 {
+		t.Errorf("Dropped() = %d, expected 1", got)
+	}
+}
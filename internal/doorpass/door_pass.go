@@ -0,0 +1,97 @@
+package doorpass
+
+import (
+	"errors"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+	doorpassv1 "github.com/kKar1503/rewired-server-2024/internal/doorpass/v1"
+	doorpassv2 "github.com/kKar1503/rewired-server-2024/internal/doorpass/v2"
+)
+
+// The versions of the door pass detection, where version 1 is the original detection and version 2 is the explicit
+// state machine of the doors.
+const (
+	VERSION_1 = "v1"
+	VERSION_2 = "v2"
+)
+
+var ErrUnknownVersion = errors.New("unknown door pass version")
+
+// Detector detects the passes through the doors from the activity of their gates.
+type Detector interface {
+	// Init creates every door.
+	Init() error
+	// GateActive processes the gate becoming unblocked at the time, which is the time the packet was received or
+	// triggered rather than the time it is handled, so replaying packets gives the same passes.
+	GateActive(gateID uint16, at time.Time)
+	// DoorKey returns the gate ID that is the same for every gate of the door the gate belongs to.
+	DoorKey(gateID uint16) uint16
+	// SetTiming changes the timing of the door, which must have its Gates loaded.
+	SetTiming(door *db.Door)
+}
+
+var detector Detector = v1Detector{}
+
+// NewDetector creates the detector of the version.
+func NewDetector(version string) (Detector, error) {
+	switch version {
+	case VERSION_1, "":
+		return v1Detector{}, nil
+	case VERSION_2:
+		return doorpassv2.NewDetector(), nil
+	default:
+		return nil, ErrUnknownVersion
+	}
+}
+
+// Init creates the detector of the version with every door, replacing the current detector.
+func Init(version string) error {
+	d, err := NewDetector(version)
+	if err != nil {
+		return err
+	}
+
+	if err := d.Init(); err != nil {
+		return err
+	}
+
+	detector = d
+
+	return nil
+}
+
+func Get() Detector {
+	return detector
+}
+
+func GateActive(gateID uint16, at time.Time) {
+	detector.GateActive(gateID, at)
+}
+
+func DoorKey(gateID uint16) uint16 {
+	return detector.DoorKey(gateID)
+}
+
+func SetTiming(door *db.Door) {
+	detector.SetTiming(door)
+}
+
+// The version 1 detection keeps its doors in its package, so its detector only forwards to the package.
+type v1Detector struct{}
+
+func (v1Detector) Init() error {
+	return doorpassv1.Init()
+}
+
+func (v1Detector) GateActive(gateID uint16, at time.Time) {
+	doorpassv1.GateActive(gateID, at)
+}
+
+func (v1Detector) DoorKey(gateID uint16) uint16 {
+	return doorpassv1.DoorKey(gateID)
+}
+
+func (v1Detector) SetTiming(door *db.Door) {
+	doorpassv1.SetTiming(door)
+}
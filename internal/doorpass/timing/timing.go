@@ -0,0 +1,53 @@
+package timing
+
+import (
+	"errors"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+	"github.com/kKar1503/rewired-server-2024/internal/settings"
+)
+
+// The default timing of the doors, used when neither the door nor the settings have a timing.
+const (
+	ALLOWANCE_FRAME = 500 * time.Millisecond
+	PASS_FRAME      = 1000 * time.Millisecond
+)
+
+// The maximum timing of a door, as a frame longer than this would never see a pass.
+const MAX_FRAME = time.Minute
+
+var ErrInvalidTiming = errors.New("invalid door timing")
+
+// Timing returns the timing of the door, falling back to the timing in the settings and then the default timing for the
+// frames the door does not set.
+//
+// The allowance frame is the longest a gate can go without being active before it is considered blocked, and the pass
+// frame is the longest between the blocks of the gates of the door for them to be considered a pass.
+func Timing(door *db.Door) (time.Duration, time.Duration) {
+	allowanceFrame := settings.Get().AllowanceFrame
+	if allowanceFrame == 0 {
+		allowanceFrame = ALLOWANCE_FRAME
+	}
+	if door.AllowanceFrameMs != nil {
+		allowanceFrame = time.Duration(*door.AllowanceFrameMs) * time.Millisecond
+	}
+
+	passFrame := settings.Get().PassFrame
+	if passFrame == 0 {
+		passFrame = PASS_FRAME
+	}
+	if door.PassFrameMs != nil {
+		passFrame = time.Duration(*door.PassFrameMs) * time.Millisecond
+	}
+
+	return allowanceFrame, passFrame
+}
+
+// ValidateTiming checks that the frame is usable as the allowance or pass frame of a door.
+func ValidateTiming(frame time.Duration) error {
+	if frame <= 0 || frame > MAX_FRAME {
+		return ErrInvalidTiming
+	}
+	return nil
+}
@@ -6,22 +6,22 @@ import (
 	"time"
 
 	"github.com/kKar1503/rewired-server-2024/internal/db"
-	"gorm.io/gorm"
-)
-
-const (
-	ALLOWANCE_FRAME = 500 * time.Millisecond
-	PASS_FRAME      = 1000 * time.Millisecond
+	"github.com/kKar1503/rewired-server-2024/internal/doorpass/timing"
+	"github.com/kKar1503/rewired-server-2024/internal/population"
 )
 
 type DoorState struct {
 	sync.Mutex
-	DevicePairID   uint
+	DoorID         uint
 	InnerGateState *GateState
 	OuterGateState *GateState
 	InnerRoomID    uint
 	OuterRoomID    uint
 	LastBlocked    LastBlock
+	// AllowanceFrame is the longest the gate can go without being active before it is considered blocked, and
+	// PassFrame is the longest between the blocks of the gates of the door for them to be considered a pass.
+	AllowanceFrame time.Duration
+	PassFrame      time.Duration
 }
 
 type LastBlock struct {
@@ -46,30 +46,38 @@ type GateState struct {
 var doorStates map[uint16]*DoorState
 
 func Init() error {
-	devicePairs := []db.DevicePair{}
-	result := db.Get().Joins("InnerGate").Joins("OuterGate").Find(&devicePairs)
+	doors := []db.Door{}
+	result := db.Get().Scopes(db.PreloadDoor).Find(&doors)
 	if result.Error != nil {
 		return result.Error
 	}
 
 	initDoorStates := make(map[uint16]*DoorState)
-	for _, devicePair := range devicePairs {
+	for _, door := range doors {
+		// the doors of more than two gates are only detected by version 2
+		if _, ok := door.RoomAt(1); len(door.Gates) != 2 || ok {
+			slog.Warn("door is not of two gates, its passes are not detected", "doorID", door.ID, "gates", len(door.Gates))
+			continue
+		}
+
+		outerGate, innerGate := door.Gates[0].Device, door.Gates[1].Device
 		doorState := &DoorState{
-			DevicePairID: devicePair.ID,
+			DoorID: door.ID,
 			InnerGateState: &GateState{
-				GateID: devicePair.InnerGate.GateID,
+				GateID: innerGate.GateID,
 			},
 			OuterGateState: &GateState{
-				GateID: devicePair.OuterGate.GateID,
+				GateID: outerGate.GateID,
 			},
 			LastBlocked: LastBlock{
 				Gate: LastBlockedGateNone,
 			},
-			InnerRoomID: devicePair.InnerRoomID,
-			OuterRoomID: devicePair.OuterRoomID,
+			InnerRoomID: door.InnerRoomID(),
+			OuterRoomID: door.OuterRoomID(),
 		}
-		initDoorStates[devicePair.InnerGate.GateID] = doorState
-		initDoorStates[devicePair.OuterGate.GateID] = doorState
+		doorState.AllowanceFrame, doorState.PassFrame = timing.Timing(&door)
+		initDoorStates[innerGate.GateID] = doorState
+		initDoorStates[outerGate.GateID] = doorState
 	}
 
 	doorStates = initDoorStates
@@ -77,7 +85,53 @@ func Init() error {
 	return nil
 }
 
-func GateActive(gateID uint16) {
+// passEvent returns the event of a pass through the door from a room to the other, blocking its gates from start until
+// end.
+func (doorState *DoorState) passEvent(fromRoomID, toRoomID uint, direction uint8, start, end time.Time) *db.PassEvent {
+	return &db.PassEvent{
+		DoorID:     doorState.DoorID,
+		FromRoomID: &fromRoomID,
+		ToRoomID:   &toRoomID,
+		Direction:  direction,
+		StartTime:  start,
+		EndTime:    end,
+		Source:     1,
+		Confidence: 1,
+	}
+}
+
+// SetTiming changes the timing of the door, taking effect from the next packet of its gates.
+func SetTiming(door *db.Door) {
+	allowanceFrame, passFrame := timing.Timing(door)
+
+	for _, doorState := range doorStates {
+		if doorState.DoorID != door.ID {
+			continue
+		}
+
+		// both gates of the door share the door state, so it is only updated once
+		doorState.Lock()
+		defer doorState.Unlock()
+
+		doorState.AllowanceFrame = allowanceFrame
+		doorState.PassFrame = passFrame
+		return
+	}
+}
+
+// DoorKey returns the gate ID of the inner gate of the door the gate belongs to, which is the same for both gates of the
+// door, or the gate ID itself when the gate is not part of a door.
+func DoorKey(gateID uint16) uint16 {
+	doorState, ok := doorStates[gateID]
+	if !ok {
+		return gateID
+	}
+	return doorState.InnerGateState.GateID
+}
+
+// GateActive processes the gate becoming unblocked at the time, which is the time the packet was received rather than
+// the time it is handled, so replaying packets gives the same passes.
+func GateActive(gateID uint16, at time.Time) {
 	doorState, ok := doorStates[gateID]
 	if !ok {
 		return
@@ -86,7 +140,7 @@ func GateActive(gateID uint16) {
 	doorState.Lock()
 	defer doorState.Unlock()
 
-	now := time.Now()
+	now := at
 
 	// inner gate logic
 	if gateID == doorState.InnerGateState.GateID {
@@ -97,7 +151,7 @@ func GateActive(gateID uint16) {
 		}
 
 		// Check if there is more than the allowance frame
-		if now.Sub(*doorState.InnerGateState.LastActive) <= ALLOWANCE_FRAME {
+		if now.Sub(*doorState.InnerGateState.LastActive) <= doorState.AllowanceFrame {
 			// if less than allowance frame, we'll just update it assuming that it was just signal error from the emitter
 			doorState.InnerGateState.LastActive = &now
 			return
@@ -134,8 +188,8 @@ func GateActive(gateID uint16) {
 		// Last block is different, this means that potentially the user has passed the door, this does not straight away
 		// mean that the user has passed, because that the last passed could've been very long ago
 		// We utilise the PASS_FRAME to determine what is the maximum allowance of leeway for passing the gate.
-		if doorState.InnerGateState.LastActive.Sub(*doorState.LastBlocked.Start) > PASS_FRAME ||
-			now.Sub(*doorState.LastBlocked.End) > PASS_FRAME {
+		if doorState.InnerGateState.LastActive.Sub(*doorState.LastBlocked.Start) > doorState.PassFrame ||
+			now.Sub(*doorState.LastBlocked.End) > doorState.PassFrame {
 			// 3a. Pass thhe PASS_FRAME, this means that the object possibly didn't pass from the other gate to this,
 			// but rather a new object is now passing from the other end.
 			// This case we'll replace the last block with this new block, pending to wait for the other side get passed within
@@ -167,34 +221,13 @@ func GateActive(gateID uint16) {
 			"LastBlockedEnd",
 			doorState.LastBlocked.End.String(),
 		)
+		start := *doorState.LastBlocked.Start
 		doorState.LastBlocked.Start = nil
 		doorState.LastBlocked.End = nil
 		doorState.LastBlocked.Gate = LastBlockedGateNone
 		doorState.InnerGateState.LastActive = &now
 
-		err := db.Get().Transaction(func(tx *gorm.DB) error {
-			innerRoomPopulation := &db.RoomPopulation{}
-			if err := tx.Where(&db.RoomPopulation{RoomID: doorState.InnerRoomID}).First(innerRoomPopulation).Error; err != nil {
-				return err
-			}
-			innerRoomPopulation.Population += 1
-			if err := tx.Save(innerRoomPopulation).Error; err != nil {
-				return err
-			}
-
-			outerRoomPopulation := &db.RoomPopulation{}
-			if err := tx.Where(&db.RoomPopulation{RoomID: doorState.OuterRoomID}).First(outerRoomPopulation).Error; err != nil {
-				return err
-			}
-			if outerRoomPopulation.Population > 0 {
-				// don't decrement when the number is 0
-				outerRoomPopulation.Population -= 1
-				if err := tx.Save(outerRoomPopulation).Error; err != nil {
-					return err
-				}
-			}
-			return nil
-		})
+		err := population.Pass(doorState.passEvent(doorState.OuterRoomID, doorState.InnerRoomID, 1, start, now))
 		if err != nil {
 			slog.Error("something went wrong when updating population", "error", err)
 		}
@@ -211,7 +244,7 @@ func GateActive(gateID uint16) {
 		}
 
 		// Check if there is more than the allowance frame
-		if now.Sub(*doorState.OuterGateState.LastActive) <= ALLOWANCE_FRAME {
+		if now.Sub(*doorState.OuterGateState.LastActive) <= doorState.AllowanceFrame {
 			// if less than allowance frame, we'll just update it assuming that it was just signal error from the emitter
 			doorState.OuterGateState.LastActive = &now
 			return
@@ -248,8 +281,8 @@ func GateActive(gateID uint16) {
 		// Last block is different, this means that potentially the user has passed the door, this does not straight away
 		// mean that the user has passed, because that the last passed could've been very long ago
 		// We utilise the PASS_FRAME to determine what is the maximum allowance of leeway for passing the gate.
-		if doorState.OuterGateState.LastActive.Sub(*doorState.LastBlocked.Start) > PASS_FRAME ||
-			now.Sub(*doorState.LastBlocked.End) > PASS_FRAME {
+		if doorState.OuterGateState.LastActive.Sub(*doorState.LastBlocked.Start) > doorState.PassFrame ||
+			now.Sub(*doorState.LastBlocked.End) > doorState.PassFrame {
 			// 3a. Pass thhe PASS_FRAME, this means that the object possibly didn't pass from the other gate to this,
 			// but rather a new object is now passing from the other end.
 			// This case we'll replace the last block with this new block, pending to wait for the other side get passed within
@@ -281,34 +314,13 @@ func GateActive(gateID uint16) {
 			"LastBlockedEnd",
 			doorState.LastBlocked.End.String(),
 		)
+		start := *doorState.LastBlocked.Start
 		doorState.LastBlocked.Start = nil
 		doorState.LastBlocked.End = nil
 		doorState.LastBlocked.Gate = LastBlockedGateNone
 		doorState.OuterGateState.LastActive = &now
 
-		err := db.Get().Transaction(func(tx *gorm.DB) error {
-			outerRoomPopulation := &db.RoomPopulation{}
-			if err := tx.Where(&db.RoomPopulation{RoomID: doorState.OuterRoomID}).First(outerRoomPopulation).Error; err != nil {
-				return err
-			}
-			outerRoomPopulation.Population += 1
-			if err := tx.Save(outerRoomPopulation).Error; err != nil {
-				return err
-			}
-
-			innerRoomPopulation := &db.RoomPopulation{}
-			if err := tx.Where(&db.RoomPopulation{RoomID: doorState.InnerRoomID}).First(innerRoomPopulation).Error; err != nil {
-				return err
-			}
-			if innerRoomPopulation.Population > 0 {
-				// don't decrement when the number is 0
-				innerRoomPopulation.Population -= 1
-				if err := tx.Save(innerRoomPopulation).Error; err != nil {
-					return err
-				}
-			}
-			return nil
-		})
+		err := population.Pass(doorState.passEvent(doorState.InnerRoomID, doorState.OuterRoomID, 2, start, now))
 		if err != nil {
 			slog.Error("something went wrong when updating population", "error", err)
 		}
@@ -0,0 +1,134 @@
+package doorpass
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+	"gorm.io/gorm"
+)
+
+const (
+	innerGateID uint16 = 1
+	outerGateID uint16 = 2
+)
+
+// setup creates a fresh database with a door between an inner and an outer room, returning the door.
+func setup(t *testing.T) *db.Door {
+	t.Helper()
+
+	if err := db.Init(filepath.Join(t.TempDir(), "rewired.db")); err != nil {
+		t.Fatalf("db.Init() error = %v", err)
+	}
+
+	door := &db.Door{}
+	err := db.Get().Transaction(func(tx *gorm.DB) error {
+		innerGate := &db.Device{GateID: innerGateID}
+		outerGate := &db.Device{GateID: outerGateID}
+		innerRoom := &db.Room{Name: "room"}
+		outerRoom := &db.Room{Name: "corridor"}
+		for _, v := range []any{innerGate, outerGate, innerRoom, outerRoom} {
+			if err := tx.Create(v).Error; err != nil {
+				return err
+			}
+		}
+		for _, room := range []*db.Room{innerRoom, outerRoom} {
+			if err := tx.Create(&db.RoomPopulation{RoomID: room.ID}).Error; err != nil {
+				return err
+			}
+		}
+
+		door.Gates = []db.DoorGate{{Position: 0, DeviceID: outerGate.ID}, {Position: 1, DeviceID: innerGate.ID}}
+		door.Rooms = []db.DoorRoom{{Position: 0, RoomID: outerRoom.ID}, {Position: 2, RoomID: innerRoom.ID}}
+		if err := tx.Create(door).Error; err != nil {
+			return err
+		}
+
+		return tx.Scopes(db.PreloadDoor).First(door, door.ID).Error
+	})
+	if err != nil {
+		t.Fatalf("failed to seed database: %v", err)
+	}
+
+	if err := Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+
+	return door
+}
+
+func roomPopulation(t *testing.T, roomID uint) uint32 {
+	t.Helper()
+
+	result := &db.RoomPopulation{}
+	if err := db.Get().Where(&db.RoomPopulation{RoomID: roomID}).First(result).Error; err != nil {
+		t.Fatalf("failed to find room population: %v", err)
+	}
+
+	return result.Population
+}
+
+// The gate becoming active at the offset in milliseconds from the start of the test.
+type activity struct {
+	gateID   uint16
+	offsetMs int
+}
+
+// walkIn is a person blocking the outer gate for 700ms, and then the inner gate for 700ms, 300ms later.
+var walkIn = []activity{
+	{outerGateID, 0},
+	{innerGateID, 0},
+	{innerGateID, 300},
+	{outerGateID, 700},
+	{innerGateID, 1000},
+}
+
+func TestTiming(t *testing.T) {
+	frameMs := func(ms uint32) *uint32 { return &ms }
+
+	tests := []struct {
+		name             string
+		allowanceFrameMs *uint32
+		passFrameMs      *uint32
+		activities       []activity
+		expected         uint32
+	}{
+		{
+			name:       "Default Timing",
+			activities: walkIn,
+			expected:   1,
+		},
+		{
+			name:             "Allowance Frame Longer Than Block",
+			allowanceFrameMs: frameMs(800),
+			activities:       walkIn,
+			expected:         0,
+		},
+		{
+			name:        "Pass Frame Shorter Than Step",
+			passFrameMs: frameMs(200),
+			activities:  walkIn,
+			expected:    0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			door := setup(t)
+
+			door.AllowanceFrameMs = tt.allowanceFrameMs
+			door.PassFrameMs = tt.passFrameMs
+			SetTiming(door)
+
+			start := time.Unix(1716912942, 0)
+			for _, a := range tt.activities {
+				GateActive(a.gateID, start.Add(time.Duration(a.offsetMs)*time.Millisecond))
+			}
+
+			if result := roomPopulation(t, door.InnerRoomID()); result != tt.expected {
+				t.Errorf("inner room population = %d, expected %d", result, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package doorpass
+
+import (
+	"log/slog"
+	"time"
+)
+
+// PassEvent is emitted by a door when the blocks of its gates are a pass from one of its rooms to another.
+type PassEvent struct {
+	DoorID     uint
+	FromRoomID uint
+	ToRoomID   uint
+	// the positions of the rooms of the pass in the door, where 0 is the outer side of the door
+	FromPosition int
+	ToPosition   int
+	Direction    Direction
+	// Entry is the block of the gate the object entered the door from, and Exit is the block of the gate it left from.
+	Entry      Block
+	Exit       Block
+	Confidence float64
+}
+
+// Flagged reports whether the pass is too uncertain to be counted, and should be reviewed instead.
+func (e *PassEvent) Flagged() bool {
+	return e.Confidence < MIN_CONFIDENCE
+}
+
+// At returns the time the pass was completed.
+func (e *PassEvent) At() time.Time {
+	return e.Exit.End
+}
+
+// Door is the state machine of a door of gates in order from its outer side to its inner side, which is driven by the
+// times its gates are active.
+//
+// Every pair of gates next to each other detects the passes between them, and the passes of the pairs that follow each
+// other on their shared gate are chained into the track of a person through the door. A track is a pass once it reaches
+// either side of the door, or once the person stops at a room between the gates for longer than the pass frame, where a
+// track that stops where there is no room is flagged.
+//
+// The door is not safe for concurrent use, and expects the activity of its gates in the order of their times.
+type Door struct {
+	DoorID uint
+	// the IDs of the rooms the door opens into by their position, where 0 is the outer side of the door and the number of
+	// gates is the inner side
+	Rooms map[int]uint
+
+	pairs  []*Pair
+	tracks []*track
+	// the pair that was active last
+	last *Pair
+}
+
+// The track of a person through the gates of a door.
+type track struct {
+	direction Direction
+	// the blocks of the first and the last gates of the track, and their positions
+	entry         Block
+	entryPosition int
+	exit          Block
+	exitPosition  int
+	confidence    float64
+}
+
+func NewDoor(gates int, allowanceFrame time.Duration, passFrame time.Duration) *Door {
+	door := &Door{Rooms: make(map[int]uint)}
+	for i := 0; i < gates-1; i++ {
+		door.pairs = append(door.pairs, NewPair(allowanceFrame, passFrame))
+	}
+	door.last = door.pairs[0]
+
+	return door
+}
+
+// State returns the state of the pair of gates that was active last.
+func (d *Door) State() State {
+	return d.last.State()
+}
+
+// SetTiming changes the timing of every pair of gates of the door.
+func (d *Door) SetTiming(allowanceFrame time.Duration, passFrame time.Duration) {
+	for _, pair := range d.pairs {
+		pair.AllowanceFrame, pair.PassFrame = allowanceFrame, passFrame
+	}
+}
+
+// Active transitions the door on the gate at the position being active at the time, returning the pass events the
+// activity completes.
+func (d *Door) Active(position int, at time.Time) []*PassEvent {
+	events := d.expire(at)
+
+	// the gate is the inner gate of the pair before it, and the outer gate of the pair after it
+	if position > 0 && position <= len(d.pairs) {
+		d.last = d.pairs[position-1]
+		for _, step := range d.last.Active(SideInner, at) {
+			events = append(events, d.step(position-1, step)...)
+		}
+	}
+	if position >= 0 && position < len(d.pairs) {
+		d.last = d.pairs[position]
+		for _, step := range d.last.Active(SideOuter, at) {
+			events = append(events, d.step(position, step)...)
+		}
+	}
+
+	return events
+}
+
+// step adds the pass of the pair of the gates at position and position + 1 to the track it follows, or to a new track.
+func (d *Door) step(position int, step *PassEvent) []*PassEvent {
+	entryPosition, exitPosition := position, position+1
+	if step.Direction == DirectionOut {
+		entryPosition, exitPosition = position+1, position
+	}
+
+	t := d.follow(step.Direction, entryPosition, step.Entry)
+	if t == nil {
+		t = &track{
+			direction:     step.Direction,
+			entry:         step.Entry,
+			entryPosition: entryPosition,
+			confidence:    step.Confidence,
+		}
+		d.tracks = append(d.tracks, t)
+	}
+
+	t.exit, t.exitPosition = step.Exit, exitPosition
+	t.confidence = min(t.confidence, step.Confidence)
+
+	// the person cannot go any further than either side of the door
+	if to := t.toPosition(); to == 0 || to == len(d.pairs)+1 {
+		return []*PassEvent{d.complete(t)}
+	}
+	return nil
+}
+
+// follow returns the track that left the door through the block of the gate at the position, which the pass of the next
+// pair was entered from.
+func (d *Door) follow(direction Direction, position int, block Block) *track {
+	for _, t := range d.tracks {
+		if t.direction == direction && t.exitPosition == position && t.exit.Start.Equal(block.Start) &&
+			t.exit.End.Equal(block.End) {
+			return t
+		}
+	}
+	return nil
+}
+
+// expire completes the tracks that stopped between the gates for longer than the pass frame.
+func (d *Door) expire(at time.Time) []*PassEvent {
+	expired := []*track{}
+	for _, t := range d.tracks {
+		if at.Sub(t.exit.End) > d.pairs[0].PassFrame {
+			expired = append(expired, t)
+		}
+	}
+
+	events := []*PassEvent{}
+	for _, t := range expired {
+		events = append(events, d.complete(t))
+	}
+	return events
+}
+
+func (d *Door) complete(t *track) *PassEvent {
+	for i := range d.tracks {
+		if d.tracks[i] == t {
+			d.tracks = append(d.tracks[:i], d.tracks[i+1:]...)
+			break
+		}
+	}
+
+	event := &PassEvent{
+		DoorID:       d.DoorID,
+		FromPosition: t.fromPosition(),
+		ToPosition:   t.toPosition(),
+		Direction:    t.direction,
+		Entry:        t.entry,
+		Exit:         t.exit,
+		Confidence:   t.confidence,
+	}
+
+	fromRoomID, fromOK := d.Rooms[event.FromPosition]
+	toRoomID, toOK := d.Rooms[event.ToPosition]
+	event.FromRoomID, event.ToRoomID = fromRoomID, toRoomID
+	if !fromOK || !toOK {
+		// the track started or stopped inside the door, so the person was missed by a gate or turned back
+		event.Confidence = min(event.Confidence, CONFIDENCE_AMBIGUOUS)
+	}
+
+	message := "valid pass"
+	if event.Flagged() {
+		message = "flagged pass"
+	}
+	slog.Info(message,
+		"doorID",
+		d.DoorID,
+		"direction",
+		event.Direction,
+		"from",
+		event.FromPosition,
+		"to",
+		event.ToPosition,
+		"confidence",
+		event.Confidence,
+	)
+
+	return event
+}
+
+// fromPosition returns the position of the room the person entered the door from.
+func (t *track) fromPosition() int {
+	if t.direction == DirectionIn {
+		return t.entryPosition
+	}
+	return t.entryPosition + 1
+}
+
+// toPosition returns the position of the room the person left the door into.
+func (t *track) toPosition() int {
+	if t.direction == DirectionIn {
+		return t.exitPosition + 1
+	}
+	return t.exitPosition
+}
@@ -0,0 +1,178 @@
+package doorpass
+
+import (
+	"expvar"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+	"github.com/kKar1503/rewired-server-2024/internal/doorpass/timing"
+	"github.com/kKar1503/rewired-server-2024/internal/population"
+)
+
+var flaggedPasses = expvar.NewInt("flagged_passes")
+
+// Detector drives every door with the activity of its gates, and hands the pass events of the doors
+// to OnPass.
+type Detector struct {
+	// OnPass is called with every pass event while the door of the pass is locked, so the passes of a door are handled
+	// in order.
+	OnPass func(event *PassEvent)
+
+	gates map[uint16]*gate
+}
+
+type gate struct {
+	position int
+	door     *lockedDoor
+	// the gate ID of the outermost gate of the door
+	key uint16
+}
+
+type lockedDoor struct {
+	sync.Mutex
+	*Door
+}
+
+func NewDetector() *Detector {
+	return &Detector{
+		OnPass: ApplyPass,
+		gates:  make(map[uint16]*gate),
+	}
+}
+
+// Init creates every door with at least two gates and a room at either end, replacing the doors that were created
+// before.
+func (d *Detector) Init() error {
+	doors := []db.Door{}
+	result := db.Get().Scopes(db.PreloadDoor).Find(&doors)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	gates := make(map[uint16]*gate)
+	for _, dbDoor := range doors {
+		_, hasOuterRoom := dbDoor.RoomAt(0)
+		_, hasInnerRoom := dbDoor.RoomAt(len(dbDoor.Gates))
+		if len(dbDoor.Gates) < 2 || !hasOuterRoom || !hasInnerRoom {
+			slog.Warn("door without two gates and a room at either end is not detected", "doorID", dbDoor.ID)
+			continue
+		}
+
+		allowanceFrame, passFrame := timing.Timing(&dbDoor)
+		door := &lockedDoor{Door: NewDoor(len(dbDoor.Gates), allowanceFrame, passFrame)}
+		door.DoorID = dbDoor.ID
+		for _, room := range dbDoor.Rooms {
+			door.Rooms[int(room.Position)] = room.RoomID
+		}
+
+		key := dbDoor.Gates[0].Device.GateID
+		for position, doorGate := range dbDoor.Gates {
+			gates[doorGate.Device.GateID] = &gate{position: position, door: door, key: key}
+		}
+	}
+
+	d.gates = gates
+
+	return nil
+}
+
+// GateActive transitions the door of the gate on the gate being active at the time.
+func (d *Detector) GateActive(gateID uint16, at time.Time) {
+	g, ok := d.gates[gateID]
+	if !ok {
+		return
+	}
+
+	g.door.Lock()
+	defer g.door.Unlock()
+
+	for _, event := range g.door.Active(g.position, at) {
+		if d.OnPass != nil {
+			d.OnPass(event)
+		}
+	}
+}
+
+// DoorKey returns the gate ID of the outermost gate of the door the gate belongs to, which is the same for every gate of
+// the door, or the gate ID itself when the gate is not part of a door.
+func (d *Detector) DoorKey(gateID uint16) uint16 {
+	g, ok := d.gates[gateID]
+	if !ok {
+		return gateID
+	}
+	return g.key
+}
+
+// SetTiming changes the timing of the door, which must have its Gates loaded, taking effect from the next activity of its
+// gates.
+func (d *Detector) SetTiming(door *db.Door) {
+	if len(door.Gates) == 0 {
+		return
+	}
+
+	g, ok := d.gates[door.Gates[0].Device.GateID]
+	if !ok || g.door.DoorID != door.ID {
+		return
+	}
+
+	g.door.Lock()
+	defer g.door.Unlock()
+
+	g.door.SetTiming(timing.Timing(door))
+}
+
+// State returns the state of the door of the gate, and whether the gate is part of a door.
+func (d *Detector) State(gateID uint16) (State, bool) {
+	g, ok := d.gates[gateID]
+	if !ok {
+		return 0, false
+	}
+
+	g.door.Lock()
+	defer g.door.Unlock()
+
+	return g.door.State(), true
+}
+
+// ApplyPass records the pass and moves the population of the pass from one room to the other, unless the pass is flagged
+// for review, which is only recorded.
+func ApplyPass(event *PassEvent) {
+	if event.Flagged() {
+		flaggedPasses.Add(1)
+		slog.Warn("pass flagged for review is not counted",
+			"doorID",
+			event.DoorID,
+			"direction",
+			event.Direction,
+			"confidence",
+			event.Confidence,
+			"at",
+			event.At(),
+		)
+	}
+
+	err := population.Pass(&db.PassEvent{
+		DoorID:     event.DoorID,
+		FromRoomID: roomID(event.FromRoomID),
+		ToRoomID:   roomID(event.ToRoomID),
+		Direction:  uint8(event.Direction),
+		StartTime:  event.Entry.Start,
+		EndTime:    event.At(),
+		Source:     1,
+		Confidence: event.Confidence,
+		Flagged:    event.Flagged(),
+	})
+	if err != nil {
+		slog.Error("something went wrong when updating population", "error", err, "doorID", event.DoorID)
+	}
+}
+
+// roomID returns the room ID of a pass event, which is nil when the pass started or stopped where there is no room.
+func roomID(id uint) *uint {
+	if id == 0 {
+		return nil
+	}
+	return &id
+}
@@ -0,0 +1,173 @@
+package doorpass
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+	"gorm.io/gorm"
+)
+
+const (
+	innerGateID uint16 = 1
+	outerGateID uint16 = 2
+)
+
+// setup creates a fresh database with a door between an inner and an outer room, returning the door and the detector of
+// the door.
+func setup(t *testing.T) (*db.Door, *Detector) {
+	t.Helper()
+
+	if err := db.Init(filepath.Join(t.TempDir(), "rewired.db")); err != nil {
+		t.Fatalf("db.Init() error = %v", err)
+	}
+
+	door := &db.Door{}
+	err := db.Get().Transaction(func(tx *gorm.DB) error {
+		innerGate := &db.Device{GateID: innerGateID}
+		outerGate := &db.Device{GateID: outerGateID}
+		innerRoom := &db.Room{Name: "room"}
+		outerRoom := &db.Room{Name: "corridor"}
+		for _, v := range []any{innerGate, outerGate, innerRoom, outerRoom} {
+			if err := tx.Create(v).Error; err != nil {
+				return err
+			}
+		}
+		for _, room := range []*db.Room{innerRoom, outerRoom} {
+			if err := tx.Create(&db.RoomPopulation{RoomID: room.ID}).Error; err != nil {
+				return err
+			}
+		}
+
+		door.Gates = []db.DoorGate{{Position: 0, DeviceID: outerGate.ID}, {Position: 1, DeviceID: innerGate.ID}}
+		door.Rooms = []db.DoorRoom{{Position: 0, RoomID: outerRoom.ID}, {Position: 2, RoomID: innerRoom.ID}}
+		if err := tx.Create(door).Error; err != nil {
+			return err
+		}
+
+		return tx.Scopes(db.PreloadDoor).First(door, door.ID).Error
+	})
+	if err != nil {
+		t.Fatalf("failed to seed database: %v", err)
+	}
+
+	detector := NewDetector()
+	if err := detector.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+
+	return door, detector
+}
+
+func roomPopulation(t *testing.T, roomID uint) uint32 {
+	t.Helper()
+
+	result := &db.RoomPopulation{}
+	if err := db.Get().Where(&db.RoomPopulation{RoomID: roomID}).First(result).Error; err != nil {
+		t.Fatalf("failed to find room population: %v", err)
+	}
+
+	return result.Population
+}
+
+func TestDetector(t *testing.T) {
+	door, detector := setup(t)
+
+	if key := detector.DoorKey(innerGateID); key != outerGateID {
+		t.Errorf("DoorKey() = %d, expected %d", key, outerGateID)
+	}
+	if key := detector.DoorKey(99); key != 99 {
+		t.Errorf("DoorKey() of a gate without a door = %d, expected %d", key, 99)
+	}
+
+	gateIDs := map[Side]uint16{SideInner: innerGateID, SideOuter: outerGateID}
+	start := time.Unix(1716912942, 0)
+	for _, a := range walkIn {
+		detector.GateActive(gateIDs[a.side], start.Add(time.Duration(a.offsetMs)*time.Millisecond))
+	}
+
+	if result := roomPopulation(t, door.InnerRoomID()); result != 1 {
+		t.Errorf("inner room population = %d, expected %d", result, 1)
+	}
+	if state, _ := detector.State(innerGateID); state != StatePassing {
+		t.Errorf("State() = %v, expected %v", state, StatePassing)
+	}
+
+	// with a pass frame shorter than the step between the gates, the next walk in is not a pass
+	passFrameMs := uint32(200)
+	door.PassFrameMs = &passFrameMs
+	detector.SetTiming(door)
+
+	for _, a := range concat(idle(1200, 2800), after(3000, walkIn)) {
+		detector.GateActive(gateIDs[a.side], start.Add(time.Duration(a.offsetMs)*time.Millisecond))
+	}
+
+	if result := roomPopulation(t, door.InnerRoomID()); result != 1 {
+		t.Errorf("inner room population = %d, expected %d", result, 1)
+	}
+}
+
+func TestApplyPass(t *testing.T) {
+	tests := []struct {
+		name       string
+		confidence float64
+		expected   uint32
+	}{
+		{
+			name:       "Certain",
+			confidence: CONFIDENCE_CERTAIN,
+			expected:   1,
+		},
+		{
+			name:       "Merged",
+			confidence: CONFIDENCE_MERGED,
+			expected:   1,
+		},
+		{
+			name:       "Flagged For Review",
+			confidence: CONFIDENCE_AMBIGUOUS,
+			expected:   0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			door, _ := setup(t)
+
+			ApplyPass(&PassEvent{
+				DoorID:     door.ID,
+				FromRoomID: door.OuterRoomID(),
+				ToRoomID:   door.InnerRoomID(),
+				Direction:  DirectionIn,
+				Confidence: tt.confidence,
+			})
+
+			if result := roomPopulation(t, door.InnerRoomID()); result != tt.expected {
+				t.Errorf("inner room population = %d, expected %d", result, tt.expected)
+			}
+
+			// the flagged passes are recorded as well, so they can be reviewed
+			passEvents := []db.PassEvent{}
+			if err := db.Get().Find(&passEvents).Error; err != nil {
+				t.Fatalf("failed to find pass events: %v", err)
+			}
+			if len(passEvents) != 1 {
+				t.Fatalf("pass events = %d, expected 1", len(passEvents))
+			}
+			passEvent := passEvents[0]
+			if passEvent.DoorID != door.ID || *passEvent.FromRoomID != door.OuterRoomID() ||
+				*passEvent.ToRoomID != door.InnerRoomID() || passEvent.Direction != 1 || passEvent.Source != 1 {
+				t.Errorf("pass event = %+v, expected an IR pass into the inner room", passEvent)
+			}
+			if passEvent.Confidence != tt.confidence || passEvent.Flagged != (tt.expected == 0) {
+				t.Errorf("pass event confidence = (%v, %v), expected (%v, %v)",
+					passEvent.Confidence,
+					passEvent.Flagged,
+					tt.confidence,
+					tt.expected == 0,
+				)
+			}
+		})
+	}
+}
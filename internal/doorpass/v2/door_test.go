@@ -0,0 +1,152 @@
+package doorpass
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/doorpass/timing"
+)
+
+// The gate at the position of a door becoming active at the offset in milliseconds from the start of the test.
+type doorActivity struct {
+	position int
+	offsetMs int
+}
+
+// walkThrough is a person blocking each gate from the first to the last for 700ms, 300ms after the gate before, where
+// each gate is active every 200ms until it is blocked.
+func walkThrough(positions ...int) []doorActivity {
+	result := []doorActivity{}
+	for i, position := range positions {
+		for offsetMs := 0; offsetMs < i*300; offsetMs += 200 {
+			result = append(result, doorActivity{position, offsetMs})
+		}
+		result = append(result, doorActivity{position, i * 300}, doorActivity{position, i*300 + 700})
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].offsetMs < result[j].offsetMs })
+	return result
+}
+
+// doorIdle is every gate of the door being active every 200ms from fromMs to toMs.
+func doorIdle(gates int, fromMs int, toMs int) []doorActivity {
+	result := []doorActivity{}
+	for offsetMs := fromMs; offsetMs <= toMs; offsetMs += 200 {
+		for position := 0; position < gates; position++ {
+			result = append(result, doorActivity{position, offsetMs})
+		}
+	}
+	return result
+}
+
+// The rooms, direction and confidence of a pass event of a door.
+type doorPass struct {
+	fromRoomID uint
+	toRoomID   uint
+	direction  Direction
+	confidence float64
+}
+
+func TestDoor(t *testing.T) {
+	tests := []struct {
+		name       string
+		gates      int
+		rooms      map[int]uint
+		activities []doorActivity
+		passes     []doorPass
+	}{
+		{
+			name:       "Walk In Through Two Gates",
+			gates:      2,
+			rooms:      map[int]uint{0: 1, 2: 2},
+			activities: walkThrough(0, 1),
+			passes:     []doorPass{{1, 2, DirectionIn, CONFIDENCE_CERTAIN}},
+		},
+		{
+			name:       "Walk In Through Three Gates",
+			gates:      3,
+			rooms:      map[int]uint{0: 1, 3: 3},
+			activities: walkThrough(0, 1, 2),
+			passes:     []doorPass{{1, 3, DirectionIn, CONFIDENCE_CERTAIN}},
+		},
+		{
+			name:       "Walk Out Through Three Gates",
+			gates:      3,
+			rooms:      map[int]uint{0: 1, 3: 3},
+			activities: walkThrough(2, 1, 0),
+			passes:     []doorPass{{3, 1, DirectionOut, CONFIDENCE_CERTAIN}},
+		},
+		{
+			// the person stops in the room between the second and the third gate, which is only known once the pass frame
+			// is over
+			name:       "Walk Into A Room Between The Gates",
+			gates:      3,
+			rooms:      map[int]uint{0: 1, 2: 2, 3: 3},
+			activities: append(walkThrough(0, 1), doorIdle(3, 1200, 2800)...),
+			passes:     []doorPass{{1, 2, DirectionIn, CONFIDENCE_CERTAIN}},
+		},
+		{
+			name:       "Walk Out Of A Room Between The Gates",
+			gates:      3,
+			rooms:      map[int]uint{0: 1, 2: 2, 3: 3},
+			activities: walkThrough(1, 0),
+			passes:     []doorPass{{2, 1, DirectionOut, CONFIDENCE_CERTAIN}},
+		},
+		{
+			// there is no room where the person stopped, so a gate missed them or they turned back
+			name:       "Stop Inside The Door",
+			gates:      3,
+			rooms:      map[int]uint{0: 1, 3: 3},
+			activities: append(walkThrough(0, 1), doorIdle(3, 1200, 2800)...),
+			passes:     []doorPass{{1, 0, DirectionIn, CONFIDENCE_AMBIGUOUS}},
+		},
+		{
+			name:       "Walk In Then Out Through Three Gates",
+			gates:      3,
+			rooms:      map[int]uint{0: 1, 3: 3},
+			activities: concatDoor(walkThrough(0, 1, 2), doorIdle(3, 1200, 2800), afterDoor(3000, walkThrough(2, 1, 0))),
+			passes:     []doorPass{{1, 3, DirectionIn, CONFIDENCE_CERTAIN}, {3, 1, DirectionOut, CONFIDENCE_CERTAIN}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			door := NewDoor(tt.gates, timing.ALLOWANCE_FRAME, timing.PASS_FRAME)
+			door.Rooms = tt.rooms
+
+			passes := []doorPass{}
+			start := time.Unix(1716912942, 0)
+			for _, a := range tt.activities {
+				for _, event := range door.Active(a.position, start.Add(time.Duration(a.offsetMs)*time.Millisecond)) {
+					passes = append(passes, doorPass{event.FromRoomID, event.ToRoomID, event.Direction, event.Confidence})
+				}
+			}
+
+			if len(passes) != len(tt.passes) {
+				t.Fatalf("passes = %v, expected %v", passes, tt.passes)
+			}
+			for i := range passes {
+				if passes[i] != tt.passes[i] {
+					t.Errorf("passes = %v, expected %v", passes, tt.passes)
+				}
+			}
+		})
+	}
+}
+
+func concatDoor(parts ...[]doorActivity) []doorActivity {
+	result := []doorActivity{}
+	for _, part := range parts {
+		result = append(result, part...)
+	}
+	return result
+}
+
+// afterDoor offsets the activities by offsetMs.
+func afterDoor(offsetMs int, activities []doorActivity) []doorActivity {
+	result := make([]doorActivity, len(activities))
+	for i, a := range activities {
+		result[i] = doorActivity{a.position, a.offsetMs + offsetMs}
+	}
+	return result
+}
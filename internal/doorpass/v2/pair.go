@@ -0,0 +1,264 @@
+package doorpass
+
+import "time"
+
+// The state of a pair of gates next to each other in a door, which is driven by the blocks of the gates.
+//
+// A pair is idle until one of its gates is blocked, and then waits in the blocked state of that gate for the other gate
+// to be blocked within the pass frame, while queueing the blocks of the people behind. The pair is passing when the last
+// of the queued blocks were a pass, and ambiguous when the direction of the last pass could not be told apart, after
+// which it waits for the next block as it would when idle.
+type State uint8
+
+const (
+	StateIdle State = iota + 1
+	StateInnerBlocked
+	StateOuterBlocked
+	StatePassing
+	StateAmbiguous
+)
+
+func (s State) String() string {
+	switch s {
+	case StateIdle:
+		return "idle"
+	case StateInnerBlocked:
+		return "inner-blocked"
+	case StateOuterBlocked:
+		return "outer-blocked"
+	case StatePassing:
+		return "passing"
+	case StateAmbiguous:
+		return "ambiguous"
+	default:
+		return "unknown"
+	}
+}
+
+// The side of a pair a gate is on, where the outer gate is the one closer to the outer side of the door.
+type Side uint8
+
+const (
+	SideInner Side = iota + 1
+	SideOuter
+)
+
+func (s Side) String() string {
+	switch s {
+	case SideInner:
+		return "inner"
+	case SideOuter:
+		return "outer"
+	default:
+		return "unknown"
+	}
+}
+
+// The direction of a pass, where in is from the outer side of the door to the inner side.
+type Direction uint8
+
+const (
+	DirectionIn Direction = iota + 1
+	DirectionOut
+)
+
+func (d Direction) String() string {
+	switch d {
+	case DirectionIn:
+		return "in"
+	case DirectionOut:
+		return "out"
+	default:
+		return "unknown"
+	}
+}
+
+// The confidence of the passes, where a pass with less than MIN_CONFIDENCE is flagged for review and is not counted.
+const (
+	// a person through an otherwise empty door
+	CONFIDENCE_CERTAIN = 1.0
+	// a person through the door while others were still in it
+	CONFIDENCE_TAILGATED = 0.75
+	// a person whose block of a gate was merged with the block of the person in front
+	CONFIDENCE_MERGED = 0.5
+	// a pass whose direction is a guess, as the blocks of the gates could not be told apart
+	CONFIDENCE_AMBIGUOUS = 0.25
+
+	MIN_CONFIDENCE = 0.5
+)
+
+// The most blocks of a gate a pair queues for the blocks of the other gate, which is more people than fit in a door.
+const MAX_PENDING_BLOCKS = 8
+
+// Block is the gate of the side being blocked from Start, the last time it was active, until End, the time it was
+// active again.
+type Block struct {
+	Side  Side
+	Start time.Time
+	End   time.Time
+}
+
+// Pair is the state machine of a pair of gates next to each other in a door, which is driven by the times its gates are
+// active. The pass events of a pair only have their direction, blocks and confidence, as it is up to the door to tell
+// the rooms of the passes.
+//
+// The pair is not safe for concurrent use, and expects the activity of its gates in the order of their times.
+type Pair struct {
+	// AllowanceFrame is the longest the gate can go without being active before it is considered blocked, and
+	// PassFrame is the longest between the blocks of the gates of the door for them to be considered a pass.
+	AllowanceFrame time.Duration
+	PassFrame      time.Duration
+
+	state           State
+	innerLastActive *time.Time
+	outerLastActive *time.Time
+	// the blocks of a gate waiting for the blocks of the other gate in the order they were blocked, in the blocked states
+	pending []Block
+}
+
+func NewPair(allowanceFrame time.Duration, passFrame time.Duration) *Pair {
+	return &Pair{
+		AllowanceFrame: allowanceFrame,
+		PassFrame:      passFrame,
+		state:          StateIdle,
+	}
+}
+
+func (p *Pair) State() State {
+	return p.state
+}
+
+// Active transitions the pair on the gate of the side being active at the time, returning the pass events the activity
+// completes.
+func (p *Pair) Active(side Side, at time.Time) []*PassEvent {
+	lastActive := &p.innerLastActive
+	if side == SideOuter {
+		lastActive = &p.outerLastActive
+	}
+
+	last := *lastActive
+	*lastActive = &at
+
+	// the first activity of the gate only tells when it was last active
+	if last == nil {
+		return nil
+	}
+
+	// a gate that is inactive for no longer than the allowance frame is a signal error of its emitter, not a block
+	if at.Sub(*last) <= p.AllowanceFrame {
+		return nil
+	}
+
+	return p.blocked(Block{Side: side, Start: *last, End: at})
+}
+
+func (p *Pair) blocked(block Block) []*PassEvent {
+	if len(p.pending) == 0 || p.pending[0].Side == block.Side {
+		// the same gate is blocked again before the other one, so it is the next person behind
+		p.hold(block)
+		return nil
+	}
+
+	// the blocks of the other gate that were blocked or unblocked too long before this block was blocked are not the same
+	// people, and neither will the blocks after this one be
+	for len(p.pending) > 0 &&
+		(block.Start.Sub(p.pending[0].Start) > p.PassFrame || block.Start.Sub(p.pending[0].End) > p.PassFrame) {
+		p.pending = p.pending[1:]
+	}
+	if len(p.pending) == 0 {
+		p.hold(block)
+		return nil
+	}
+
+	first := p.pending[0]
+	p.pending = p.pending[1:]
+
+	events := []*PassEvent{p.pass(first, block)}
+
+	// the people behind whose blocks are within this block were merged with the person in front on this gate
+	for len(p.pending) > 0 && !p.pending[0].Start.Before(block.Start) && !p.pending[0].End.After(block.End) {
+		merged := p.event(p.pending[0], block, events[0].Direction, CONFIDENCE_MERGED)
+		p.pending = p.pending[1:]
+		events = append(events, merged)
+	}
+
+	// there are still people between the gates
+	if len(p.pending) > 0 {
+		if events[0].Confidence == CONFIDENCE_CERTAIN {
+			events[0].Confidence = CONFIDENCE_TAILGATED
+		}
+		p.state = blockedState(p.pending[0].Side)
+	}
+
+	return events
+}
+
+// hold queues the block for the block of the other gate.
+func (p *Pair) hold(block Block) {
+	if len(p.pending) == MAX_PENDING_BLOCKS {
+		p.pending = p.pending[1:]
+	}
+	p.pending = append(p.pending, block)
+	p.state = blockedState(block.Side)
+}
+
+func (p *Pair) pass(first Block, second Block) *PassEvent {
+	entry, exit, ok := order(first, second)
+	if !ok {
+		// the blocks could not be told apart, so the direction is guessed from the order the gates were unblocked
+		p.state = StateAmbiguous
+		return p.event(first, second, direction(first.Side), CONFIDENCE_AMBIGUOUS)
+	}
+
+	p.state = StatePassing
+	return p.event(entry, exit, direction(entry.Side), CONFIDENCE_CERTAIN)
+}
+
+func (p *Pair) event(entry Block, exit Block, direction Direction, confidence float64) *PassEvent {
+	return &PassEvent{
+		Direction:  direction,
+		Entry:      entry,
+		Exit:       exit,
+		Confidence: confidence,
+	}
+}
+
+// direction returns the direction of a pass that entered the door from the side.
+func direction(entrySide Side) Direction {
+	if entrySide == SideOuter {
+		return DirectionIn
+	}
+	return DirectionOut
+}
+
+func blockedState(side Side) State {
+	if side == SideOuter {
+		return StateOuterBlocked
+	}
+	return StateInnerBlocked
+}
+
+// order returns the block of the gate the object entered the door from, which was blocked first, and the block of the
+// gate it left from, which was unblocked last. The blocks are ambiguous when they disagree, such as when the block of
+// one gate spans the block of the other, or when both are blocked and unblocked together.
+func order(a Block, b Block) (Block, Block, bool) {
+	startOrder := a.Start.Compare(b.Start)
+	endOrder := a.End.Compare(b.End)
+
+	switch {
+	case startOrder == 0 && endOrder == 0:
+		return a, b, false
+	case startOrder == 0:
+		startOrder = endOrder
+	case endOrder == 0:
+		endOrder = startOrder
+	}
+
+	if startOrder != endOrder {
+		return a, b, false
+	}
+	if startOrder < 0 {
+		return a, b, true
+	}
+	return b, a, true
+}
@@ -0,0 +1,245 @@
+package doorpass
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/doorpass/timing"
+)
+
+// The gate of the side becoming active at the offset in milliseconds from the start of the test.
+type activity struct {
+	side     Side
+	offsetMs int
+}
+
+// walkIn is a person blocking the outer gate for 700ms, and then the inner gate for 700ms, 300ms later.
+var walkIn = []activity{
+	{SideOuter, 0},
+	{SideInner, 0},
+	{SideInner, 300},
+	{SideOuter, 700},
+	{SideInner, 1000},
+}
+
+// walkOut is walkIn the other way around.
+var walkOut = []activity{
+	{SideInner, 0},
+	{SideOuter, 0},
+	{SideOuter, 300},
+	{SideInner, 700},
+	{SideOuter, 1000},
+}
+
+// idle is both gates being active every 200ms from fromMs to toMs, as they are while nothing is in the door.
+func idle(fromMs int, toMs int) []activity {
+	result := []activity{}
+	for offsetMs := fromMs; offsetMs <= toMs; offsetMs += 200 {
+		result = append(result, activity{SideOuter, offsetMs}, activity{SideInner, offsetMs})
+	}
+	return result
+}
+
+func concat(parts ...[]activity) []activity {
+	result := []activity{}
+	for _, part := range parts {
+		result = append(result, part...)
+	}
+	return result
+}
+
+// after offsets the activities by offsetMs.
+func after(offsetMs int, activities []activity) []activity {
+	result := make([]activity, len(activities))
+	for i, a := range activities {
+		result[i] = activity{a.side, a.offsetMs + offsetMs}
+	}
+	return result
+}
+
+// The direction and confidence of a pass event.
+type pass struct {
+	direction  Direction
+	confidence float64
+}
+
+func TestPair(t *testing.T) {
+	tests := []struct {
+		name             string
+		allowanceFrameMs int
+		passFrameMs      int
+		activities       []activity
+		passes           []pass
+		state            State
+	}{
+		{
+			name:       "Walk In",
+			activities: walkIn,
+			passes:     []pass{{DirectionIn, CONFIDENCE_CERTAIN}},
+			state:      StatePassing,
+		},
+		{
+			name:       "Walk Out",
+			activities: walkOut,
+			passes:     []pass{{DirectionOut, CONFIDENCE_CERTAIN}},
+			state:      StatePassing,
+		},
+		{
+			name:       "Walks After Each Other",
+			activities: concat(walkIn, idle(1200, 2800), after(3000, walkOut), idle(4200, 5800), after(6000, walkIn)),
+			passes:     []pass{{DirectionIn, CONFIDENCE_CERTAIN}, {DirectionOut, CONFIDENCE_CERTAIN}, {DirectionIn, CONFIDENCE_CERTAIN}},
+			state:      StatePassing,
+		},
+		{
+			name:       "First Activity Of The Gates",
+			activities: []activity{{SideOuter, 0}, {SideInner, 2000}},
+			state:      StateIdle,
+		},
+		{
+			name:       "Signal Errors Within Allowance Frame",
+			activities: []activity{{SideOuter, 0}, {SideInner, 0}, {SideInner, 400}, {SideOuter, 500}, {SideOuter, 900}},
+			state:      StateIdle,
+		},
+		{
+			name:       "One Gate Blocked",
+			activities: []activity{{SideOuter, 0}, {SideInner, 0}, {SideOuter, 700}},
+			state:      StateOuterBlocked,
+		},
+		{
+			// the outer gate is blocked again before the inner gate, which is the next person behind still in the door
+			name: "Same Gate Blocked Again",
+			activities: []activity{
+				{SideOuter, 0},
+				{SideInner, 0},
+				{SideInner, 400},
+				{SideOuter, 700},
+				{SideInner, 800},
+				{SideOuter, 1900},
+				{SideInner, 2200},
+			},
+			passes: []pass{{DirectionIn, CONFIDENCE_TAILGATED}},
+			state:  StateOuterBlocked,
+		},
+		{
+			// the second person is blocking the outer gate before the first person leaves the inner gate
+			name: "Tailgating",
+			activities: []activity{
+				{SideOuter, 0},
+				{SideInner, 0},
+				{SideInner, 400},
+				{SideOuter, 700},
+				{SideInner, 800},
+				{SideInner, 1000},
+				{SideOuter, 1500},
+				{SideInner, 1600},
+				{SideInner, 1700},
+				{SideInner, 2400},
+			},
+			passes: []pass{{DirectionIn, CONFIDENCE_TAILGATED}, {DirectionIn, CONFIDENCE_CERTAIN}},
+			state:  StatePassing,
+		},
+		{
+			// the second person is right behind the first person, so the inner gate is blocked by both at once
+			name: "Tailgating Merged On A Gate",
+			activities: []activity{
+				{SideOuter, 0},
+				{SideInner, 0},
+				{SideInner, 300},
+				{SideOuter, 700},
+				{SideOuter, 900},
+				{SideOuter, 1600},
+				{SideInner, 2100},
+			},
+			passes: []pass{{DirectionIn, CONFIDENCE_CERTAIN}, {DirectionIn, CONFIDENCE_MERGED}},
+			state:  StatePassing,
+		},
+		{
+			// the inner gate is blocked too long after the outer gate to be the same person
+			name: "Other Gate Blocked After Pass Frame",
+			activities: []activity{
+				{SideOuter, 0},
+				{SideInner, 0},
+				{SideInner, 400},
+				{SideOuter, 700},
+				{SideInner, 800},
+				{SideInner, 1200},
+				{SideInner, 2600},
+			},
+			state: StateInnerBlocked,
+		},
+		{
+			name:        "Pass Frame Shorter Than Step",
+			passFrameMs: 200,
+			activities:  walkIn,
+			state:       StateInnerBlocked,
+		},
+		{
+			name:             "Allowance Frame Longer Than Block",
+			allowanceFrameMs: 800,
+			activities:       walkIn,
+			state:            StateIdle,
+		},
+		{
+			// version 1 counts this as a pass in the direction of the gate it heard from first
+			name:       "Both Gates Blocked Together",
+			activities: []activity{{SideOuter, 0}, {SideInner, 0}, {SideOuter, 700}, {SideInner, 700}},
+			passes:     []pass{{DirectionIn, CONFIDENCE_AMBIGUOUS}},
+			state:      StateAmbiguous,
+		},
+		{
+			name: "Block Spanning The Other Block",
+			activities: []activity{
+				{SideOuter, 0},
+				{SideInner, 0},
+				{SideInner, 300},
+				{SideInner, 900},
+				{SideOuter, 1000},
+			},
+			passes: []pass{{DirectionOut, CONFIDENCE_AMBIGUOUS}},
+			state:  StateAmbiguous,
+		},
+		{
+			name: "Walk In After Ambiguous",
+			activities: concat(
+				[]activity{{SideOuter, 0}, {SideInner, 0}, {SideOuter, 700}, {SideInner, 700}},
+				idle(900, 2800),
+				after(3000, walkIn),
+			),
+			passes: []pass{{DirectionIn, CONFIDENCE_AMBIGUOUS}, {DirectionIn, CONFIDENCE_CERTAIN}},
+			state:  StatePassing,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pair := NewPair(timing.ALLOWANCE_FRAME, timing.PASS_FRAME)
+			if tt.allowanceFrameMs != 0 {
+				pair.AllowanceFrame = time.Duration(tt.allowanceFrameMs) * time.Millisecond
+			}
+			if tt.passFrameMs != 0 {
+				pair.PassFrame = time.Duration(tt.passFrameMs) * time.Millisecond
+			}
+
+			passes := []pass{}
+			start := time.Unix(1716912942, 0)
+			for _, a := range tt.activities {
+				for _, event := range pair.Active(a.side, start.Add(time.Duration(a.offsetMs)*time.Millisecond)) {
+					passes = append(passes, pass{event.Direction, event.Confidence})
+				}
+			}
+
+			if len(passes) != len(tt.passes) {
+				t.Fatalf("passes = %v, expected %v", passes, tt.passes)
+			}
+			for i := range passes {
+				if passes[i] != tt.passes[i] {
+					t.Errorf("passes = %v, expected %v", passes, tt.passes)
+				}
+			}
+
+			if result := pair.State(); result != tt.state {
+				t.Errorf("State() = %v, expected %v", result, tt.state)
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package downlink
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+	"github.com/kKar1503/rewired-server-2024/internal/packet"
+	"github.com/kKar1503/rewired-server-2024/internal/settings"
+)
+
+var ErrNotCommand = errors.New("packet type is not a command")
+
+// The delivery status of a db.DeviceCommand.
+type CommandStatus uint8
+
+const (
+	CommandStatusQueued CommandStatus = iota + 1
+	CommandStatusSent
+	CommandStatusAcked
+	CommandStatusTimedOut
+)
+
+func (s CommandStatus) String() string {
+	switch s {
+	case CommandStatusQueued:
+		return "queued"
+	case CommandStatusSent:
+		return "sent"
+	case CommandStatusAcked:
+		return "acked"
+	case CommandStatusTimedOut:
+		return "timed-out"
+	default:
+		return "unknown"
+	}
+}
+
+// deliveries serialises the delivery of commands per device, so a command is not sent twice when the device registers
+// while the command is being sent. The devices have their own locks, so a slow device does not hold up the others.
+var deliveries = struct {
+	sync.Mutex
+	gates map[uint16]*sync.Mutex
+}{gates: make(map[uint16]*sync.Mutex)}
+
+// lockDeliveries locks the deliveries to the device, returning the function that unlocks them.
+func lockDeliveries(gateID uint16) func() {
+	deliveries.Lock()
+	gate, ok := deliveries.gates[gateID]
+	if !ok {
+		gate = &sync.Mutex{}
+		deliveries.gates[gateID] = gate
+	}
+	deliveries.Unlock()
+
+	gate.Lock()
+	return gate.Unlock
+}
+
+// Send queues the command for the device and immediately delivers it when the device is connected.
+func Send(gateID uint16, commandType packet.PacketType, argument uint16) (*db.DeviceCommand, error) {
+	if !commandType.IsCommand() {
+		return nil, ErrNotCommand
+	}
+
+	device := &db.Device{}
+	result := db.Get().Where(&db.Device{GateID: gateID}).First(device)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	command := &db.DeviceCommand{
+		DeviceID:    device.ID,
+		CommandType: uint8(commandType),
+		Argument:    argument,
+		Status:      uint8(CommandStatusQueued),
+	}
+	result = db.Get().Create(command)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	// the sequence number is derived from the ID, so it is unique for the device until it wraps around
+	command.Sequence = uint16(command.ID)
+	result = db.Get().Save(command)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	command.Device = *device
+
+	defer lockDeliveries(gateID)()
+
+	deliver(command)
+
+	return command, nil
+}
+
+// Acknowledge marks the sent command with the packet type and sequence number as acked by the device.
+func Acknowledge(gateID uint16, commandType packet.PacketType, sequence uint16) {
+	device := &db.Device{}
+	result := db.Get().Where(&db.Device{GateID: gateID}).First(device)
+	if result.Error != nil {
+		slog.Error("failed to find the device", "error", result.Error, "gateID", gateID)
+		return
+	}
+
+	now := time.Now()
+	result = db.Get().
+		Model(&db.DeviceCommand{}).
+		Where(&db.DeviceCommand{
+			DeviceID:    device.ID,
+			CommandType: uint8(commandType),
+			Sequence:    sequence,
+			Status:      uint8(CommandStatusSent),
+		}).
+		Updates(&db.DeviceCommand{Status: uint8(CommandStatusAcked), AckedAt: &now})
+	if result.Error != nil {
+		slog.Error("failed to save command ack", "error", result.Error, "gateID", gateID, "sequence", sequence)
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		slog.Warn("received ack for unknown command", "gateID", gateID, "commandType", commandType, "sequence", sequence)
+		return
+	}
+
+	slog.Info("command acked", "gateID", gateID, "commandType", commandType, "sequence", sequence)
+}
+
+// Run times out the commands that were not acked by the device within the command timeout, until the context is done.
+func Run(ctx context.Context) {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("exiting downlink timeout")
+			return
+		case <-ticker.C:
+			deadline := time.Now().Add(-settings.Get().CommandTimeout)
+
+			result := db.Get().
+				Model(&db.DeviceCommand{}).
+				Where("status = ? AND created_at < ?", CommandStatusQueued, deadline).
+				Or("status = ? AND sent_at < ?", CommandStatusSent, deadline).
+				Update("status", CommandStatusTimedOut)
+			if result.Error != nil {
+				slog.Error("failed to time out commands", "error", result.Error)
+				continue
+			}
+
+			if result.RowsAffected > 0 {
+				slog.Warn("commands timed out", "count", result.RowsAffected)
+			}
+		}
+	}
+}
+
+// deliverQueued delivers the commands that are still queued for the device.
+func deliverQueued(gateID uint16) {
+	defer lockDeliveries(gateID)()
+
+	commands := []db.DeviceCommand{}
+	result := db.Get().
+		Joins("Device").
+		Where("Device.gate_id = ? AND device_commands.status = ?", gateID, CommandStatusQueued).
+		Order("device_commands.id").
+		Find(&commands)
+	if result.Error != nil {
+		slog.Error("failed to find queued commands", "error", result.Error, "gateID", gateID)
+		return
+	}
+
+	for i := range commands {
+		deliver(&commands[i])
+	}
+}
+
+// deliver writes the command to the device when it is connected, marking it as sent. The caller must hold the
+// deliveries to the device.
+func deliver(command *db.DeviceCommand) {
+	writer, ok := writerOf(command.Device.GateID)
+	if !ok {
+		slog.Info("device not connected, command queued", "gateID", command.Device.GateID, "command.ID", command.ID)
+		return
+	}
+
+	commandPacket := &packet.CommandPacket{
+		RawPacket: packet.RawPacket{Version: packet.CURRENT_VERSION, PacketType: packet.PacketType(command.CommandType)},
+	}
+	commandPacket.SetGateID(command.Device.GateID)
+	commandPacket.SetSequence(command.Sequence)
+	if commandPacket.PacketType.HasArgument() {
+		if err := commandPacket.SetArgument(command.Argument); err != nil {
+			slog.Error("failed to set command argument", "error", err, "command.ID", command.ID)
+			return
+		}
+	}
+
+	// the command is marked as sent before writing, so an ack that arrives before the write returns is not missed
+	now := time.Now()
+	result := db.Get().Model(command).Updates(&db.DeviceCommand{Status: uint8(CommandStatusSent), SentAt: &now})
+	if result.Error != nil {
+		slog.Error("failed to save command status", "error", result.Error, "command.ID", command.ID)
+		return
+	}
+
+	if err := writer.WritePacket(commandPacket); err != nil {
+		slog.Error("failed to write command", "error", err, "gateID", command.Device.GateID, "command.ID", command.ID)
+
+		result = db.Get().Model(command).Select("Status", "SentAt").Updates(&db.DeviceCommand{
+			Status: uint8(CommandStatusQueued),
+			SentAt: nil,
+		})
+		if result.Error != nil {
+			slog.Error("failed to save command status", "error", result.Error, "command.ID", command.ID)
+		}
+		return
+	}
+
+	slog.Info("command sent", "gateID", command.Device.GateID, "command.ID", command.ID)
+}
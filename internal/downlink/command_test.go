@@ -0,0 +1,220 @@
+package downlink
+
+import (
+	"context"
+	"encoding"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+	"github.com/kKar1503/rewired-server-2024/internal/packet"
+	"github.com/kKar1503/rewired-server-2024/internal/settings"
+	"gorm.io/gorm"
+)
+
+// setup creates a fresh database with the device of gate 1, and no registered connections.
+func setup(t *testing.T) {
+	t.Helper()
+
+	if err := db.Init(filepath.Join(t.TempDir(), "rewired.db")); err != nil {
+		t.Fatalf("db.Init() error = %v", err)
+	}
+
+	if err := db.Get().Create(&db.Device{GateID: 1}).Error; err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	connections.Lock()
+	connections.writers = make(map[uint16]PacketWriter)
+	connections.Unlock()
+}
+
+// waitForStatus waits for the command to reach the status, as the queued commands are delivered in the background.
+func waitForStatus(t *testing.T, id uint, expected CommandStatus) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		command := &db.DeviceCommand{}
+		if err := db.Get().First(command, id).Error; err != nil {
+			t.Fatalf("First() error = %v", err)
+		}
+
+		if CommandStatus(command.Status) == expected {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("Status = %v, expected %v", CommandStatus(command.Status), expected)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSend(t *testing.T) {
+	tests := []struct {
+		name        string
+		writer      *fakeWriter
+		gateID      uint16
+		commandType packet.PacketType
+		err         error
+		status      CommandStatus
+	}{
+		{"Not Connected", nil, 1, packet.PacketTypeIdentify, nil, CommandStatusQueued},
+		{"Connected", &fakeWriter{}, 1, packet.PacketTypeReboot, nil, CommandStatusSent},
+		{"Write Failed", &fakeWriter{fail: true}, 1, packet.PacketTypeIdentify, nil, CommandStatusQueued},
+		{"Not Command", &fakeWriter{}, 1, packet.PacketTypeHeartbeat, ErrNotCommand, 0},
+		{"Unknown Device", &fakeWriter{}, 99, packet.PacketTypeIdentify, gorm.ErrRecordNotFound, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setup(t)
+
+			if tt.writer != nil {
+				connections.writers[tt.gateID] = tt.writer
+			}
+
+			command, err := Send(tt.gateID, tt.commandType, 0)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Send() error = %v, expected %v", err, tt.err)
+			}
+			if tt.err != nil {
+				return
+			}
+
+			stored := &db.DeviceCommand{}
+			if err := db.Get().First(stored, command.ID).Error; err != nil {
+				t.Fatalf("First() error = %v", err)
+			}
+
+			if CommandStatus(stored.Status) != tt.status {
+				t.Errorf("Status = %v, expected %v", CommandStatus(stored.Status), tt.status)
+			}
+
+			if stored.Sequence != uint16(stored.ID) {
+				t.Errorf("Sequence = %d, expected %d", stored.Sequence, stored.ID)
+			}
+
+			expectedWritten := 0
+			if tt.status == CommandStatusSent {
+				expectedWritten = 1
+			}
+			if tt.writer != nil && tt.writer.written() != expectedWritten {
+				t.Errorf("written = %d, expected %d", tt.writer.written(), expectedWritten)
+			}
+		})
+	}
+}
+
+func TestDeliveryStatus(t *testing.T) {
+	setup(t)
+	settings.Get().CommandTimeout = 0
+
+	acked, err := Send(1, packet.PacketTypeSetHeartbeatInterval, 30)
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	unacked, err := Send(1, packet.PacketTypeIdentify, 0)
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	waitForStatus(t, acked.ID, CommandStatusQueued)
+	waitForStatus(t, unacked.ID, CommandStatusQueued)
+
+	// the queued commands are delivered once the device connects
+	writer := &fakeWriter{}
+	Register(1, writer)
+
+	waitForStatus(t, acked.ID, CommandStatusSent)
+	waitForStatus(t, unacked.ID, CommandStatusSent)
+
+	if writer.written() != 2 {
+		t.Fatalf("written = %d, expected %d", writer.written(), 2)
+	}
+
+	Acknowledge(1, packet.PacketTypeSetHeartbeatInterval, acked.Sequence)
+	waitForStatus(t, acked.ID, CommandStatusAcked)
+
+	// an ack with the wrong packet type does not ack the command with the same sequence
+	Acknowledge(1, packet.PacketTypeReboot, unacked.Sequence)
+	waitForStatus(t, unacked.ID, CommandStatusSent)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	waitForStatus(t, unacked.ID, CommandStatusTimedOut)
+	waitForStatus(t, acked.ID, CommandStatusAcked)
+}
+
+// The writer of a connection to a stuck device, which blocks every write until it is released.
+type blockingWriter struct {
+	fakeWriter
+	writing chan struct{}
+	release chan struct{}
+}
+
+func (w *blockingWriter) WritePacket(p encoding.BinaryMarshaler) error {
+	w.writing <- struct{}{}
+	<-w.release
+	return w.fakeWriter.WritePacket(p)
+}
+
+func TestSendToStuckDevice(t *testing.T) {
+	setup(t)
+
+	if err := db.Get().Create(&db.Device{GateID: 2}).Error; err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	stuck := &blockingWriter{writing: make(chan struct{}), release: make(chan struct{})}
+	connections.writers[1] = stuck
+	writer := &fakeWriter{}
+	connections.writers[2] = writer
+
+	done := make(chan error)
+	go func() {
+		_, err := Send(1, packet.PacketTypeIdentify, 0)
+		done <- err
+	}()
+	<-stuck.writing
+
+	// the deliveries to the stuck device do not hold up the deliveries to the other devices
+	sent := make(chan error)
+	go func() {
+		_, err := Send(2, packet.PacketTypeIdentify, 0)
+		sent <- err
+	}()
+
+	select {
+	case err := <-sent:
+		if err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Send() to gate %d is blocked by the stuck device", 2)
+	}
+
+	if writer.written() != 1 {
+		t.Errorf("written = %d, expected %d", writer.written(), 1)
+	}
+
+	close(stuck.release)
+	if err := <-done; err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if stuck.written() != 1 {
+		t.Errorf("written = %d, expected %d", stuck.written(), 1)
+	}
+}
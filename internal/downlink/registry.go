@@ -0,0 +1,49 @@
+package downlink
+
+import (
+	"encoding"
+	"sync"
+)
+
+// The writer that is able to deliver a packet to a connected device, such as a TCP connection.
+type PacketWriter interface {
+	WritePacket(p encoding.BinaryMarshaler) error
+}
+
+type connectionRegistry struct {
+	sync.RWMutex
+	writers map[uint16]PacketWriter
+}
+
+var connections = &connectionRegistry{
+	writers: make(map[uint16]PacketWriter),
+}
+
+// Register records the writer as the connection of the device, replacing any previous connection, and delivers the
+// commands that were queued while the device was not connected.
+func Register(gateID uint16, writer PacketWriter) {
+	connections.Lock()
+	connections.writers[gateID] = writer
+	connections.Unlock()
+
+	go deliverQueued(gateID)
+}
+
+// Unregister removes the writer as the connection of the device, unless the device has since registered another
+// connection.
+func Unregister(gateID uint16, writer PacketWriter) {
+	connections.Lock()
+	defer connections.Unlock()
+
+	if connections.writers[gateID] == writer {
+		delete(connections.writers, gateID)
+	}
+}
+
+func writerOf(gateID uint16) (PacketWriter, bool) {
+	connections.RLock()
+	defer connections.RUnlock()
+
+	writer, ok := connections.writers[gateID]
+	return writer, ok
+}
@@ -0,0 +1,69 @@
+package downlink
+
+import (
+	"encoding"
+	"errors"
+	"sync"
+	"testing"
+)
+
+var errWriteFailed = errors.New("write failed")
+
+// The writer of a fake connection, which records the packets written to it.
+type fakeWriter struct {
+	sync.Mutex
+	packets []encoding.BinaryMarshaler
+	fail    bool
+}
+
+func (w *fakeWriter) WritePacket(p encoding.BinaryMarshaler) error {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.fail {
+		return errWriteFailed
+	}
+
+	w.packets = append(w.packets, p)
+	return nil
+}
+
+func (w *fakeWriter) written() int {
+	w.Lock()
+	defer w.Unlock()
+
+	return len(w.packets)
+}
+
+func TestRegistry(t *testing.T) {
+	setup(t)
+
+	first := &fakeWriter{}
+	second := &fakeWriter{}
+
+	tests := []struct {
+		name     string
+		apply    func()
+		expected PacketWriter
+	}{
+		{"Not Registered", func() {}, nil},
+		{"Registered", func() { Register(1, first) }, first},
+		{"Replaced By New Connection", func() { Register(1, second) }, second},
+		{"Unregister Stale Connection", func() { Unregister(1, first) }, second},
+		{"Unregister Current Connection", func() { Unregister(1, second) }, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.apply()
+
+			writer, ok := writerOf(1)
+			if ok != (tt.expected != nil) {
+				t.Fatalf("writerOf() ok = %v, expected %v", ok, tt.expected != nil)
+			}
+			if ok && writer != tt.expected {
+				t.Errorf("writerOf() = %p, expected %p", writer, tt.expected)
+			}
+		})
+	}
+}
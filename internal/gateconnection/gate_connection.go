@@ -18,12 +18,9 @@ type GateConnectionStates struct {
 	DeviceStates map[uint16]*DeviceState
 }
 
+// Init loads the connection states of the registered devices. It can be called again to reload the states from the
+// database, while the states are invalidated by the same goroutine.
 func Init() error {
-	states = GateConnectionStates{
-		Mutex:        sync.Mutex{},
-		DeviceStates: make(map[uint16]*DeviceState),
-	}
-
 	devices := []db.Device{}
 
 	result := db.Get().Find(&devices)
@@ -31,20 +28,31 @@ func Init() error {
 		return result.Error
 	}
 
+	deviceStates := make(map[uint16]*DeviceState)
 	for _, d := range devices {
-		states.DeviceStates[d.GateID] = &DeviceState{
+		deviceStates[d.GateID] = &DeviceState{
 			Connected: d.Status,
 			ValidTill: time.Now().Add(60 * time.Second),
 		}
 	}
 
-	go invalidateConnection()
+	// the states are replaced under the lock and invalidated by a single goroutine, so that Init can be called again,
+	// such as for every test, while the states are in use
+	states.Lock()
+	states.DeviceStates = deviceStates
+	states.Unlock()
+
+	invalidateOnce.Do(func() { go invalidateConnection() })
 
 	return nil
 }
 
 var states GateConnectionStates
 
+var invalidateOnce sync.Once
+
+// KeepConnected marks the device as connected for another 60 seconds. A device that was registered after Init starts
+// being tracked here.
 func KeepConnected(gateID uint16) {
 	states.Lock()
 	defer states.Unlock()
@@ -59,6 +67,10 @@ func KeepConnected(gateID uint16) {
 			slog.Error("unknown gateID provided", "gateID", gateID)
 			return
 		}
+
+		// the device was registered after Init, so it starts off as not connected
+		deviceState = &DeviceState{}
+		states.DeviceStates[gateID] = deviceState
 	}
 
 	if deviceState.Connected == 1 {
@@ -0,0 +1,107 @@
+package gateconnection
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+)
+
+func TestKeepConnected(t *testing.T) {
+	if err := db.Init(filepath.Join(t.TempDir(), "rewired.db")); err != nil {
+		t.Fatalf("db.Init() error = %v", err)
+	}
+
+	if err := db.Get().Create(&db.Device{GateID: 1}).Error; err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	if err := Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+
+	// registered after Init, so it is not known to the states yet
+	if err := db.Get().Create(&db.Device{GateID: 2}).Error; err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		gateID    uint16
+		connected bool
+	}{
+		{"Known Device", 1, true},
+		{"Device Registered After Init", 2, true},
+		{"Unknown Device", 99, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			KeepConnected(tt.gateID)
+
+			states.Lock()
+			deviceState, ok := states.DeviceStates[tt.gateID]
+			states.Unlock()
+
+			if ok != tt.connected {
+				t.Fatalf("tracked = %v, expected %v", ok, tt.connected)
+			}
+			if !tt.connected {
+				return
+			}
+			if deviceState.Connected != 1 {
+				t.Errorf("Connected = %v, expected %v", deviceState.Connected, 1)
+			}
+
+			device := &db.Device{}
+			db.Get().Where(&db.Device{GateID: tt.gateID}).First(device)
+			if device.Status != 1 {
+				t.Errorf("Status = %v, expected %v", device.Status, 1)
+			}
+		})
+	}
+}
+
+func TestInitAgain(t *testing.T) {
+	if err := db.Init(filepath.Join(t.TempDir(), "rewired.db")); err != nil {
+		t.Fatalf("db.Init() error = %v", err)
+	}
+
+	if err := db.Get().Create(&db.Device{GateID: 1}).Error; err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	if err := Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+
+	// the states are in use while they are loaded again
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			KeepConnected(1)
+		}
+	}()
+
+	if err := db.Get().Create(&db.Device{GateID: 2, Status: 1}).Error; err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	if err := Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	<-done
+
+	states.Lock()
+	defer states.Unlock()
+
+	// the states are loaded from the database again, so the devices registered since are tracked with their status
+	deviceState, ok := states.DeviceStates[2]
+	if !ok {
+		t.Fatalf("tracked = %v, expected %v", ok, true)
+	}
+	if deviceState.Connected != 1 {
+		t.Errorf("Connected = %v, expected %v", deviceState.Connected, 1)
+	}
+}
@@ -0,0 +1,394 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+)
+
+var ErrUnknownResolution = errors.New("unknown resolution")
+
+// The interval the occupancy history is compacted at, which is also how long after the end of a minute its snapshots are
+// compacted, as a snapshot is stamped before the transaction that records it commits, so it can become visible after a
+// compaction that ran past its time.
+const COMPACT_INTERVAL = time.Minute
+
+// MAX_BUCKETS is the most aggregates of a resolution compacted for a room in one run, so a history that is behind, such
+// as after the server was down, catches up over several runs rather than in one long transaction.
+const MAX_BUCKETS = 1440
+
+// How long the snapshots, and the aggregates of each resolution, are kept for from their time, which is long after they
+// were compacted into the next resolution. The day aggregates are kept forever.
+const (
+	SNAPSHOT_RETENTION = 7 * 24 * time.Hour
+	MINUTE_RETENTION   = 30 * 24 * time.Hour
+	HOUR_RETENTION     = 365 * 24 * time.Hour
+)
+
+// the number of the aggregates of the resolution before it in an aggregate of the resolution, which limits how many
+// aggregates are compacted in one run, so the run reads about as many finer aggregates as MAX_BUCKETS
+var finerBuckets = map[Resolution]int{
+	ResolutionHour: 60,
+	ResolutionDay:  24,
+}
+
+// The resolution of a db.OccupancyAggregate, where ResolutionRaw is the snapshots themselves.
+type Resolution uint8
+
+const (
+	ResolutionRaw Resolution = iota
+	ResolutionMinute
+	ResolutionHour
+	ResolutionDay
+)
+
+func (r Resolution) String() string {
+	switch r {
+	case ResolutionRaw:
+		return "raw"
+	case ResolutionMinute:
+		return "minute"
+	case ResolutionHour:
+		return "hour"
+	case ResolutionDay:
+		return "day"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseResolution returns the resolution of its name.
+func ParseResolution(name string) (Resolution, error) {
+	for _, r := range []Resolution{ResolutionRaw, ResolutionMinute, ResolutionHour, ResolutionDay} {
+		if r.String() == name {
+			return r, nil
+		}
+	}
+	return 0, ErrUnknownResolution
+}
+
+// Truncate returns the start of the aggregate of the resolution the time is in, where the days start at midnight in the
+// local time of the server.
+func (r Resolution) Truncate(t time.Time) time.Time {
+	switch r {
+	case ResolutionMinute:
+		return t.Truncate(time.Minute)
+	case ResolutionHour:
+		return t.Truncate(time.Hour)
+	case ResolutionDay:
+		year, month, day := t.In(time.Local).Date()
+		return time.Date(year, month, day, 0, 0, 0, 0, time.Local)
+	default:
+		return t
+	}
+}
+
+// Next returns the start of the aggregate of the resolution after the aggregate that starts at the time.
+func (r Resolution) Next(t time.Time) time.Time {
+	switch r {
+	case ResolutionMinute:
+		return t.Add(time.Minute)
+	case ResolutionHour:
+		return t.Add(time.Hour)
+	case ResolutionDay:
+		return t.In(time.Local).AddDate(0, 0, 1)
+	default:
+		return t
+	}
+}
+
+// Run compacts the occupancy history, until the context is done.
+func Run(ctx context.Context) {
+	ticker := time.NewTicker(COMPACT_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("exiting occupancy history compaction")
+			return
+		case now := <-ticker.C:
+			if err := Compact(now); err != nil {
+				slog.Error("failed to compact the occupancy history", "error", err)
+			}
+		}
+	}
+}
+
+// Compact aggregates the snapshots of every room into the minutes that ended by COMPACT_INTERVAL before now, the minutes into the hours and the
+// hours into the days, and deletes the snapshots and the aggregates that are past their retention.
+func Compact(now time.Time) error {
+	roomIDs := []uint{}
+	result := db.Get().Model(&db.OccupancySnapshot{}).Distinct().Order("room_id").Pluck("room_id", &roomIDs)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	var errs error
+	for _, roomID := range roomIDs {
+		if err := compactSnapshots(roomID, now); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("room %d minutes: %w", roomID, err))
+			continue
+		}
+
+		for _, resolution := range []Resolution{ResolutionHour, ResolutionDay} {
+			if err := compactAggregates(roomID, resolution); err != nil {
+				errs = errors.Join(errs, fmt.Errorf("room %d %s: %w", roomID, resolution, err))
+				break
+			}
+		}
+	}
+
+	return errors.Join(errs, prune(now))
+}
+
+// compactSnapshots aggregates the snapshots of the room into the minutes after its last minute aggregate that ended by
+// COMPACT_INTERVAL before now, where the population of a minute is carried from the snapshot before it.
+func compactSnapshots(roomID uint, now time.Time) error {
+	start, ok, err := nextStart(roomID, ResolutionMinute)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		first := &db.OccupancySnapshot{}
+		result := db.Get().Where(&db.OccupancySnapshot{RoomID: roomID}).Order("recorded_at").Limit(1).Find(first)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		start = ResolutionMinute.Truncate(first.RecordedAt)
+	}
+
+	end := ResolutionMinute.Truncate(now.Add(-COMPACT_INTERVAL))
+	if limit := start.Add(MAX_BUCKETS * time.Minute); limit.Before(end) {
+		end = limit
+	}
+	if !start.Before(end) {
+		return nil
+	}
+
+	carry := &db.OccupancySnapshot{}
+	result := db.Get().
+		Where(&db.OccupancySnapshot{RoomID: roomID}).
+		Where("recorded_at < ?", start).
+		Order("recorded_at DESC").
+		Order("id DESC").
+		Limit(1).
+		Find(carry)
+	if result.Error != nil {
+		return result.Error
+	}
+	hasCarry := result.RowsAffected > 0
+
+	snapshots := []db.OccupancySnapshot{}
+	result = db.Get().
+		Where(&db.OccupancySnapshot{RoomID: roomID}).
+		Where("recorded_at >= ? AND recorded_at < ?", start, end).
+		Order("recorded_at").
+		Order("id").
+		Find(&snapshots)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	aggregates := []db.OccupancyAggregate{}
+	i := 0
+	for bucketStart := start; bucketStart.Before(end); bucketStart = ResolutionMinute.Next(bucketStart) {
+		bucketEnd := ResolutionMinute.Next(bucketStart)
+
+		a := &accumulator{}
+		if hasCarry {
+			a.add(bucketStart, carry.Population)
+		}
+		for ; i < len(snapshots) && snapshots[i].RecordedAt.Before(bucketEnd); i++ {
+			a.add(snapshots[i].RecordedAt, snapshots[i].Population)
+			carry = &snapshots[i]
+			hasCarry = true
+		}
+
+		if aggregate, ok := a.aggregate(bucketEnd); ok {
+			aggregate.RoomID = roomID
+			aggregate.Resolution = uint8(ResolutionMinute)
+			aggregate.StartTime = bucketStart
+			aggregates = append(aggregates, aggregate)
+		}
+	}
+
+	if len(aggregates) == 0 {
+		return nil
+	}
+	return db.Get().CreateInBatches(aggregates, 100).Error
+}
+
+// compactAggregates aggregates the aggregates of the resolution before the resolution into the aggregates of the
+// resolution after the last one of the room, which the finer aggregates are complete for.
+func compactAggregates(roomID uint, resolution Resolution) error {
+	finer := resolution - 1
+
+	// the finer aggregates are complete up to the start of the next one, and always start on the first one of the room
+	progress, ok, err := nextStart(roomID, finer)
+	if err != nil || !ok {
+		return err
+	}
+
+	start, ok, err := nextStart(roomID, resolution)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		first := &db.OccupancyAggregate{}
+		result := db.Get().
+			Where(&db.OccupancyAggregate{RoomID: roomID, Resolution: uint8(finer)}).
+			Order("start_time").
+			Limit(1).
+			Find(first)
+		if result.Error != nil {
+			return result.Error
+		}
+		start = resolution.Truncate(first.StartTime)
+	}
+
+	end := resolution.Truncate(progress)
+	limit := start
+	for n := 0; n < MAX_BUCKETS/finerBuckets[resolution] && limit.Before(end); n++ {
+		limit = resolution.Next(limit)
+	}
+	end = limit
+	if !start.Before(end) {
+		return nil
+	}
+
+	finerAggregates := []db.OccupancyAggregate{}
+	result := db.Get().
+		Where(&db.OccupancyAggregate{RoomID: roomID, Resolution: uint8(finer)}).
+		Where("start_time >= ? AND start_time < ?", start, end).
+		Order("start_time").
+		Find(&finerAggregates)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	aggregates := []db.OccupancyAggregate{}
+	for _, group := range groupAggregates(finerAggregates, resolution) {
+		aggregate := db.OccupancyAggregate{
+			RoomID:     roomID,
+			Resolution: uint8(resolution),
+			StartTime:  resolution.Truncate(group[0].StartTime),
+			Min:        group[0].Min,
+			Max:        group[0].Max,
+		}
+		for _, finerAggregate := range group {
+			aggregate.Min = min(aggregate.Min, finerAggregate.Min)
+			aggregate.Max = max(aggregate.Max, finerAggregate.Max)
+			aggregate.Avg += finerAggregate.Avg / float64(len(group))
+		}
+		aggregates = append(aggregates, aggregate)
+	}
+
+	if len(aggregates) == 0 {
+		return nil
+	}
+	return db.Get().CreateInBatches(aggregates, 100).Error
+}
+
+// groupAggregates groups the aggregates, in the order of their start time, by the aggregate of the resolution they are
+// in.
+func groupAggregates(aggregates []db.OccupancyAggregate, resolution Resolution) [][]db.OccupancyAggregate {
+	groups := [][]db.OccupancyAggregate{}
+	for i, aggregate := range aggregates {
+		if i == 0 || !resolution.Truncate(aggregate.StartTime).Equal(resolution.Truncate(aggregates[i-1].StartTime)) {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], aggregate)
+	}
+	return groups
+}
+
+// nextStart returns the start of the aggregate of the resolution after the last one of the room, and whether the room
+// has an aggregate of the resolution.
+func nextStart(roomID uint, resolution Resolution) (time.Time, bool, error) {
+	last := &db.OccupancyAggregate{}
+	result := db.Get().
+		Where(&db.OccupancyAggregate{RoomID: roomID, Resolution: uint8(resolution)}).
+		Order("start_time DESC").
+		Limit(1).
+		Find(last)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return time.Time{}, false, result.Error
+	}
+	return resolution.Next(last.StartTime), true, nil
+}
+
+// prune deletes the snapshots and the aggregates that are past their retention, except the last snapshot of every room,
+// which the population of its next minute is carried from.
+func prune(now time.Time) error {
+	result := db.Get().
+		Where("recorded_at < ?", now.Add(-SNAPSHOT_RETENTION)).
+		Where("id NOT IN (?)", db.Get().Model(&db.OccupancySnapshot{}).Select("MAX(id)").Group("room_id")).
+		Delete(&db.OccupancySnapshot{})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	for resolution, retention := range map[Resolution]time.Duration{
+		ResolutionMinute: MINUTE_RETENTION,
+		ResolutionHour:   HOUR_RETENTION,
+	} {
+		result := db.Get().
+			Where("resolution = ? AND start_time < ?", uint8(resolution), now.Add(-retention)).
+			Delete(&db.OccupancyAggregate{})
+		if result.Error != nil {
+			return result.Error
+		}
+	}
+
+	return nil
+}
+
+// accumulator accumulates the populations held over an aggregate, weighting the average by how long each population was
+// held.
+type accumulator struct {
+	started  bool
+	at       time.Time
+	value    uint32
+	min      uint32
+	max      uint32
+	weighted float64
+	duration time.Duration
+}
+
+// add changes the population to the value at the time.
+func (a *accumulator) add(at time.Time, value uint32) {
+	if a.started {
+		held := at.Sub(a.at)
+		a.weighted += float64(a.value) * held.Seconds()
+		a.duration += held
+		a.min = min(a.min, value)
+		a.max = max(a.max, value)
+	} else {
+		a.started = true
+		a.min = value
+		a.max = value
+	}
+
+	a.at = at
+	a.value = value
+}
+
+// aggregate returns the aggregate of the populations up to the end, and whether there was a population at all.
+func (a *accumulator) aggregate(end time.Time) (db.OccupancyAggregate, bool) {
+	if !a.started {
+		return db.OccupancyAggregate{}, false
+	}
+
+	a.add(end, a.value)
+
+	aggregate := db.OccupancyAggregate{Min: a.min, Max: a.max, Avg: float64(a.value)}
+	if a.duration > 0 {
+		aggregate.Avg = a.weighted / a.duration.Seconds()
+	}
+	return aggregate, true
+}
@@ -0,0 +1,167 @@
+package history
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+)
+
+func TestCompact(t *testing.T) {
+	if err := db.Init(filepath.Join(t.TempDir(), "rewired.db")); err != nil {
+		t.Fatalf("db.Init() error = %v", err)
+	}
+
+	start := time.Date(2024, 5, 28, 10, 0, 0, 0, time.Local)
+	at := func(offset time.Duration) time.Time {
+		return start.Add(offset)
+	}
+
+	// the first room holds 2 then 4 for half a minute each, 4 then 1 for half a minute each, then 1 for the rest of the
+	// hour, while the second room holds 5 throughout
+	err := db.Get().Create([]*db.OccupancySnapshot{
+		{RoomID: 1, Population: 2, RecordedAt: at(0)},
+		{RoomID: 2, Population: 5, RecordedAt: at(0)},
+		{RoomID: 1, Population: 4, RecordedAt: at(30 * time.Second)},
+		{RoomID: 1, Population: 1, RecordedAt: at(90 * time.Second)},
+	}).Error
+	if err != nil {
+		t.Fatalf("failed to create snapshots: %v", err)
+	}
+
+	// the minutes are compacted a minute after they end
+	for _, now := range []time.Time{at(4*time.Minute + 10*time.Second), at(time.Hour + time.Minute + 10*time.Second)} {
+		if err := Compact(now); err != nil {
+			t.Fatalf("Compact(%v) error = %v", now, err)
+		}
+	}
+
+	hourAvg := (3 + 2.5 + 58) / 60.0
+	tests := []struct {
+		name       string
+		roomIDs    []uint
+		resolution Resolution
+		since      time.Time
+		until      time.Time
+		expected   []Point
+	}{
+		{
+			name:       "Minutes",
+			roomIDs:    []uint{1},
+			resolution: ResolutionMinute,
+			since:      at(0),
+			until:      at(3 * time.Minute),
+			expected: []Point{
+				{At: at(0), Min: 2, Max: 4, Avg: 3},
+				{At: at(time.Minute), Min: 1, Max: 4, Avg: 2.5},
+				{At: at(2 * time.Minute), Min: 1, Max: 1, Avg: 1},
+			},
+		},
+		{
+			name:       "Hour",
+			roomIDs:    []uint{1},
+			resolution: ResolutionHour,
+			since:      at(0),
+			until:      at(2 * time.Hour),
+			expected:   []Point{{At: at(0), Min: 1, Max: 4, Avg: hourAvg}},
+		},
+		{
+			// the days are only compacted once the hours of the day are
+			name:       "Day",
+			roomIDs:    []uint{1},
+			resolution: ResolutionDay,
+			since:      at(-24 * time.Hour),
+			until:      at(24 * time.Hour),
+			expected:   []Point{},
+		},
+		{
+			name:       "Total Of The Hour",
+			roomIDs:    []uint{1, 2},
+			resolution: ResolutionHour,
+			since:      at(30 * time.Minute),
+			until:      at(2 * time.Hour),
+			expected:   []Point{{At: at(0), Min: 6, Max: 9, Avg: hourAvg + 5}},
+		},
+		{
+			name:       "Total Of The Snapshots",
+			roomIDs:    []uint{1, 2},
+			resolution: ResolutionRaw,
+			since:      at(45 * time.Second),
+			until:      at(time.Hour),
+			expected: []Point{
+				{At: at(45 * time.Second), Min: 9, Max: 9, Avg: 9},
+				{At: at(90 * time.Second), Min: 6, Max: 6, Avg: 6},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			points, err := Query(tt.roomIDs, tt.resolution, tt.since, tt.until)
+			if err != nil {
+				t.Fatalf("Query() error = %v", err)
+			}
+
+			if len(points) != len(tt.expected) {
+				t.Fatalf("Query() = %+v, expected %+v", points, tt.expected)
+			}
+			for i := range points {
+				if !points[i].At.Equal(tt.expected[i].At) || points[i].Min != tt.expected[i].Min ||
+					points[i].Max != tt.expected[i].Max || math.Abs(points[i].Avg-tt.expected[i].Avg) > 1e-9 {
+					t.Errorf("Query()[%d] = %+v, expected %+v", i, points[i], tt.expected[i])
+				}
+			}
+		})
+	}
+
+	t.Run("Compact Again", func(t *testing.T) {
+		// the aggregates that were compacted are not compacted again
+		if err := Compact(at(time.Hour + time.Minute + 10*time.Second)); err != nil {
+			t.Fatalf("Compact() error = %v", err)
+		}
+
+		var count int64
+		if err := db.Get().Model(&db.OccupancyAggregate{}).Where("room_id = ?", 1).Count(&count).Error; err != nil {
+			t.Fatalf("failed to count aggregates: %v", err)
+		}
+		if count != 61 {
+			t.Errorf("aggregates = %d, expected 61", count)
+		}
+	})
+}
+
+func TestCompactLateSnapshot(t *testing.T) {
+	if err := db.Init(filepath.Join(t.TempDir(), "rewired.db")); err != nil {
+		t.Fatalf("db.Init() error = %v", err)
+	}
+
+	start := time.Date(2024, 5, 28, 10, 0, 0, 0, time.Local)
+	if err := db.Get().Create(&db.OccupancySnapshot{RoomID: 1, Population: 2, RecordedAt: start}).Error; err != nil {
+		t.Fatalf("failed to create snapshot: %v", err)
+	}
+
+	// the first minute only just ended, so it is not compacted yet
+	if err := Compact(start.Add(time.Minute + time.Second)); err != nil {
+		t.Fatalf("Compact() error = %v", err)
+	}
+
+	// stamped at the end of the first minute, but committed after the compaction
+	late := &db.OccupancySnapshot{RoomID: 1, Population: 6, RecordedAt: start.Add(59 * time.Second)}
+	if err := db.Get().Create(late).Error; err != nil {
+		t.Fatalf("failed to create snapshot: %v", err)
+	}
+
+	if err := Compact(start.Add(2*time.Minute + time.Second)); err != nil {
+		t.Fatalf("Compact() error = %v", err)
+	}
+
+	points, err := Query([]uint{1}, ResolutionMinute, start, start.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	if len(points) != 1 || points[0].Max != 6 {
+		t.Errorf("Query() = %+v, expected the first minute with a max of 6", points)
+	}
+}
@@ -0,0 +1,102 @@
+package history
+
+import (
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+)
+
+// Point is the population over the aggregate of a resolution that starts at At, or the population from At for the raw
+// snapshots, where the min, max and average are the same.
+type Point struct {
+	At  time.Time
+	Min uint32
+	Max uint32
+	Avg float64
+}
+
+// Query returns the history of the total population of the rooms from since until until at the resolution, including the
+// aggregate since is in. The min and max of several rooms are the totals of their min and max, which bound the min and
+// max of their total, while the average is exact.
+func Query(roomIDs []uint, resolution Resolution, since, until time.Time) ([]Point, error) {
+	if len(roomIDs) == 0 {
+		return []Point{}, nil
+	}
+
+	if resolution == ResolutionRaw {
+		return querySnapshots(roomIDs, since, until)
+	}
+
+	aggregates := []db.OccupancyAggregate{}
+	result := db.Get().
+		Where("room_id IN ?", roomIDs).
+		Where("resolution = ?", uint8(resolution)).
+		Where("start_time >= ? AND start_time < ?", resolution.Truncate(since), until).
+		Order("start_time").
+		Find(&aggregates)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	points := []Point{}
+	for i, aggregate := range aggregates {
+		if i == 0 || !aggregate.StartTime.Equal(aggregates[i-1].StartTime) {
+			points = append(points, Point{At: aggregate.StartTime})
+		}
+
+		point := &points[len(points)-1]
+		point.Min += aggregate.Min
+		point.Max += aggregate.Max
+		point.Avg += aggregate.Avg
+	}
+
+	return points, nil
+}
+
+// querySnapshots returns the total population of the rooms at since, from the last snapshot of each room before it, and
+// after every snapshot until until.
+func querySnapshots(roomIDs []uint, since, until time.Time) ([]Point, error) {
+	carries := []db.OccupancySnapshot{}
+	result := db.Get().
+		Where("id IN (?)", db.Get().
+			Model(&db.OccupancySnapshot{}).
+			Select("MAX(id)").
+			Where("room_id IN ?", roomIDs).
+			Where("recorded_at < ?", since).
+			Group("room_id"),
+		).
+		Find(&carries)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	snapshots := []db.OccupancySnapshot{}
+	result = db.Get().
+		Where("room_id IN ?", roomIDs).
+		Where("recorded_at >= ? AND recorded_at < ?", since, until).
+		Order("recorded_at").
+		Order("id").
+		Find(&snapshots)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	populations := make(map[uint]uint32)
+	var total uint32
+	for _, carry := range carries {
+		populations[carry.RoomID] = carry.Population
+		total += carry.Population
+	}
+
+	points := []Point{}
+	if len(carries) > 0 {
+		points = append(points, Point{At: since, Min: total, Max: total, Avg: float64(total)})
+	}
+	for _, snapshot := range snapshots {
+		total = total - populations[snapshot.RoomID] + snapshot.Population
+		populations[snapshot.RoomID] = snapshot.Population
+		points = append(points, Point{At: snapshot.RecordedAt, Min: total, Max: total, Avg: float64(total)})
+	}
+
+	return points, nil
+}
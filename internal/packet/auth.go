@@ -0,0 +1,99 @@
+package packet
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+
+	"github.com/kKar1503/rewired-server-2024/internal/utils"
+)
+
+const (
+	// The payload is followed by the counter and the truncated HMAC when the packet is authenticated.
+	FlagAuthenticated byte = 1 << iota
+)
+
+const (
+	KNOWN_FLAGS = FlagAuthenticated
+	// The HMAC-SHA256 of the packet is truncated to the first 4 bytes, to keep the packets small.
+	AUTH_MAC_LENGTH = 4
+	// The authentication trailer is made up of the 32 bits counter and the truncated HMAC.
+	AUTH_TRAILER_LENGTH = 4 + AUTH_MAC_LENGTH
+)
+
+// IsAuthenticated reports whether the packet carries a counter and a truncated HMAC.
+func (p *RawPacket) IsAuthenticated() bool {
+	return p.Flags&FlagAuthenticated != 0
+}
+
+// Sign marks the packet as authenticated with the counter, and computes its truncated HMAC with the secret of the
+// device.
+//
+// The counter must be strictly increasing for every packet the device signs, as the server rejects any counter that
+// is not greater than the last one it accepted to prevent replays.
+func (p *RawPacket) Sign(secret []byte, counter uint32) error {
+	if p.Version < VERSION_2 {
+		return ErrVersionMismatch
+	}
+
+	p.Flags |= FlagAuthenticated
+	p.Counter = counter
+
+	mac, err := p.computeMAC(secret)
+	if err != nil {
+		return err
+	}
+
+	p.mac = mac
+
+	return nil
+}
+
+// Verify reports whether the packet is authenticated and its truncated HMAC matches the one computed with the secret
+// of the device.
+func (p *RawPacket) Verify(secret []byte) bool {
+	if !p.IsAuthenticated() || len(p.mac) != AUTH_MAC_LENGTH {
+		return false
+	}
+
+	mac, err := p.computeMAC(secret)
+	if err != nil {
+		return false
+	}
+
+	return hmac.Equal(mac, p.mac)
+}
+
+func (p *RawPacket) computeMAC(secret []byte) ([]byte, error) {
+	data, err := p.signedData()
+	if err != nil {
+		return nil, err
+	}
+
+	h := hmac.New(sha256.New, secret)
+	h.Write(data)
+
+	return h.Sum(nil)[:AUTH_MAC_LENGTH], nil
+}
+
+// signedData returns the version 2 frame up to the truncated HMAC, which is the header, the payload and the counter
+// when the packet is authenticated.
+func (p *RawPacket) signedData() ([]byte, error) {
+	length := len(p.raw)
+	if p.IsAuthenticated() {
+		length += AUTH_TRAILER_LENGTH
+	}
+
+	if length > MAX_PAYLOAD_LENGTH {
+		return nil, ErrPayloadTooLarge
+	}
+
+	data := make([]byte, 0, V2_HEADER_LENGTH+length+V2_TRAILER_LENGTH)
+	data = append(data, utils.Join2FourBitsIntoByte(p.Version, byte(p.PacketType)), p.Flags, byte(length))
+	data = append(data, p.raw...)
+	if p.IsAuthenticated() {
+		data = binary.BigEndian.AppendUint32(data, p.Counter)
+	}
+
+	return data, nil
+}
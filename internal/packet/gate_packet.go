@@ -1,16 +1,33 @@
 package packet
 
 import (
+	"bufio"
 	"bytes"
+	"encoding"
 	"encoding/binary"
 	"errors"
-	"io"
+	"log/slog"
 	"time"
 
 	"github.com/kKar1503/rewired-server-2024/internal/utils"
 )
 
-const CURRENT_VERSION byte = 1
+const (
+	VERSION_1 byte = 1
+	VERSION_2 byte = 2
+	// Version 3 is framed the same as version 2, with a millisecond trigger time in the gate status packet.
+	VERSION_3 byte = 3
+	// The version of the packets the server sends, which devices of every framed version accept.
+	CURRENT_VERSION byte = VERSION_2
+)
+
+const (
+	// The version 2 header is made up of the version and packet type byte, the flags byte and the length byte.
+	V2_HEADER_LENGTH = 3
+	// The version 2 trailer is the CRC-16 checksum of the header and the payload.
+	V2_TRAILER_LENGTH  = 2
+	MAX_PAYLOAD_LENGTH = 64
+)
 
 var (
 	ErrRequireVersionAndPacketType = errors.New("require version and packet type byte to be unmarshal first")
@@ -21,6 +38,10 @@ var (
 	ErrEmptyRawData                = errors.New("empty raw data")
 	ErrInvalidBinarySize           = errors.New("invalid binary size")
 	ErrInvalidTimestamp            = errors.New("invalid timestamp")
+	ErrPayloadTooLarge             = errors.New("payload too large")
+	ErrChecksumMismatch            = errors.New("checksum mismatched")
+	ErrInvalidFlags                = errors.New("invalid flags")
+	ErrUnsigned                    = errors.New("authenticated packet is not signed")
 )
 
 // The raw TCP packet that is received from the device.
@@ -28,10 +49,38 @@ var (
 // The first byte of the raw packet contains the version number and the packet type with 4 bits each.
 //
 // With the packet type and the version, we can determine the packet struct to unmarshal the binary to.
+//
+// Version 1 packets are made up of only the first byte followed by a payload with a size fixed by the packet type.
+// Version 2 and 3 packets are framed with a flags byte and a length byte after the first byte, and a CRC-16 trailer
+// after the payload, which allows the reader to detect a corrupted frame and resynchronise on the next one.
+//
+// Version 2 packets with the FlagAuthenticated flag carry a counter and a truncated HMAC after the payload, see Sign.
 type RawPacket struct {
 	raw        []byte
+	mac        []byte
+	frame      []byte
 	Version    byte
 	PacketType PacketType
+	Flags      byte
+	Counter    uint32
+	// The address the packet was received from and the time it was received, set by the server that read it; they are
+	// not part of the encoded packet.
+	RemoteAddr string
+	ReceivedAt time.Time
+	// Whether the packet is passed through the pipeline again from a dead letter, rather than received by a server.
+	Redriven bool
+	// The connection the packet was received from, set by the server that read it; nil for packets that were not
+	// received by a server, such as replayed packets.
+	Source Source
+}
+
+// The connection a packet was received from.
+type Source interface {
+	// Authenticated is called once a packet from the gate passed authentication, which makes the connection trusted as
+	// the connection of the gate.
+	Authenticated(gateID uint16)
+	// WritePacket writes the packet back to where the packet was received from, such as its ack.
+	WritePacket(p encoding.BinaryMarshaler) error
 }
 
 func (p *RawPacket) MarshalBinary() ([]byte, error) {
@@ -41,54 +90,208 @@ func (p *RawPacket) MarshalBinary() ([]byte, error) {
 
 	versionAndPacketType := utils.Join2FourBitsIntoByte(p.Version, byte(p.PacketType))
 
-	data := append([]byte{versionAndPacketType}, p.raw...)
+	switch p.Version {
+	case VERSION_1:
+		if p.Flags != 0 {
+			return nil, ErrInvalidFlags
+		}
+
+		data := append([]byte{versionAndPacketType}, p.raw...)
+
+		return data, nil
+	case VERSION_2, VERSION_3:
+		if p.Flags&^KNOWN_FLAGS != 0 {
+			return nil, ErrInvalidFlags
+		}
+
+		if p.IsAuthenticated() && len(p.mac) != AUTH_MAC_LENGTH {
+			return nil, ErrUnsigned
+		}
 
-	return data, nil
+		data, err := p.signedData()
+		if err != nil {
+			return nil, err
+		}
+
+		data = append(data, p.mac...)
+		data = binary.BigEndian.AppendUint16(data, utils.CRC16CCITT(data))
+
+		return data, nil
+	default:
+		return nil, ErrVersionMismatch
+	}
 }
 
-func (p *RawPacket) ReadPackets(connReader io.Reader) error {
-	firstPacketBuf := make([]byte, 1)
+// UnmarshalBinary unmarshals exactly one framed packet, validating the version, packet type, size and for framed
+// packets, the checksum.
+func (p *RawPacket) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 {
+		return ErrEmptyRawData
+	}
 
-	_, err := connReader.Read(firstPacketBuf)
+	frameLength, err := frameLength(data)
 	if err != nil {
 		return err
 	}
 
-	firstPacket := firstPacketBuf[0]
-	version, packetType := utils.SplitByteInto2FourBits(firstPacket)
+	if len(data) != frameLength {
+		return ErrInvalidBinarySize
+	}
 
-	if version != CURRENT_VERSION {
-		return ErrVersionMismatch
+	version, packetType := utils.SplitByteInto2FourBits(data[0])
+
+	switch version {
+	case VERSION_1:
+		p.Flags = 0
+		p.raw = append([]byte(nil), data[1:]...)
+	case VERSION_2, VERSION_3:
+		checksumOffset := len(data) - V2_TRAILER_LENGTH
+		if utils.CRC16CCITT(data[:checksumOffset]) != binary.BigEndian.Uint16(data[checksumOffset:]) {
+			return ErrChecksumMismatch
+		}
+
+		p.Flags = data[1]
+		payload := data[V2_HEADER_LENGTH:checksumOffset]
+
+		if p.IsAuthenticated() {
+			if len(payload) <= AUTH_TRAILER_LENGTH {
+				return ErrInvalidBinarySize
+			}
+
+			trailerOffset := len(payload) - AUTH_TRAILER_LENGTH
+			p.Counter = binary.BigEndian.Uint32(payload[trailerOffset:])
+			p.mac = append([]byte(nil), payload[trailerOffset+4:]...)
+			payload = payload[:trailerOffset]
+		}
+
+		p.raw = append([]byte(nil), payload...)
 	}
 
 	p.Version = version
+	p.PacketType = PacketType(packetType)
+	p.frame = append([]byte(nil), data...)
 
-	switch PacketType(packetType) {
-	case PacketTypeHeartbeat, PacketTypeDecrement, PacketTypeIncrement:
-		p.PacketType = PacketType(packetType)
+	return nil
+}
 
-		packetData := make([]byte, 2)
-		_, err := connReader.Read(packetData)
+// ReadPackets reads the next valid packet from the connection.
+//
+// Bytes that do not start a valid frame (unknown version or packet type, oversized length or checksum mismatch)
+// are discarded one at a time until the reader is aligned with a valid frame again, so a corrupted byte on the wire
+// only costs the packets it overlaps with instead of the whole connection. Only errors from the underlying reader
+// are returned.
+func (p *RawPacket) ReadPackets(connReader *bufio.Reader) error {
+	discarded := 0
+
+	for {
+		header, err := connReader.Peek(1)
 		if err != nil {
 			return err
 		}
 
-		p.raw = packetData
-	case PacketTypeGateStatus:
-		p.PacketType = PacketTypeGateStatus
+		if version, _ := utils.SplitByteInto2FourBits(header[0]); isFramed(version) {
+			header, err = connReader.Peek(V2_HEADER_LENGTH)
+			if err != nil {
+				return err
+			}
+		}
 
-		gateStatusPacketData := make([]byte, 7)
-		_, err := connReader.Read(gateStatusPacketData)
-		if err != nil {
+		length, frameErr := frameLength(header)
+		if frameErr == nil {
+			frame, err := connReader.Peek(length)
+			if err != nil {
+				return err
+			}
+
+			frameErr = p.UnmarshalBinary(frame)
+			if frameErr == nil {
+				if _, err := connReader.Discard(length); err != nil {
+					return err
+				}
+
+				if discarded > 0 {
+					slog.Warn("discarded bytes to resynchronise packet stream", "discarded", discarded)
+				}
+
+				return nil
+			}
+		}
+
+		slog.Debug("discarding byte of invalid frame", "error", frameErr)
+		if _, err := connReader.Discard(1); err != nil {
 			return err
 		}
+		discarded++
+	}
+}
+
+// GateID reads the ID of the device from the payload, which every packet type starts with.
+func (p *RawPacket) GateID() (uint16, error) {
+	if len(p.raw) < 2 {
+		return 0, ErrInvalidBinarySize
+	}
+
+	return binary.BigEndian.Uint16(p.raw), nil
+}
+
+// Frame returns a copy of the bytes the packet was unmarshalled from, exactly as they were received, or nil when the
+// packet was not unmarshalled.
+func (p *RawPacket) Frame() []byte {
+	return bytes.Clone(p.frame)
+}
+
+// Payload returns a copy of the payload of the packet.
+func (p *RawPacket) Payload() []byte {
+	return bytes.Clone(p.raw)
+}
+
+// frameLength determines the total length of the frame from its header.
+//
+// Version 1 frames only require the first byte, while framed versions require the full V2_HEADER_LENGTH bytes.
+func frameLength(header []byte) (int, error) {
+	version, packetType := utils.SplitByteInto2FourBits(header[0])
+
+	switch version {
+	case VERSION_1:
+		switch PacketType(packetType) {
+		case PacketTypeHeartbeat, PacketTypeDecrement, PacketTypeIncrement:
+			return 3, nil
+		case PacketTypeGateStatus:
+			return 8, nil
+		default:
+			return 0, ErrInvalidPacketType
+		}
+	case VERSION_2, VERSION_3:
+		if !PacketType(packetType).IsValid() {
+			return 0, ErrInvalidPacketType
+		}
+
+		if len(header) < V2_HEADER_LENGTH {
+			return 0, ErrInvalidBinarySize
+		}
+
+		if header[1]&^KNOWN_FLAGS != 0 {
+			return 0, ErrInvalidFlags
+		}
+
+		payloadLength := int(header[2])
+		if payloadLength == 0 {
+			return 0, ErrEmptyRawData
+		}
+
+		if payloadLength > MAX_PAYLOAD_LENGTH {
+			return 0, ErrPayloadTooLarge
+		}
 
-		p.raw = gateStatusPacketData
+		return V2_HEADER_LENGTH + payloadLength + V2_TRAILER_LENGTH, nil
 	default:
-		return ErrInvalidPacketType
+		return 0, ErrVersionMismatch
 	}
+}
 
-	return nil
+// isFramed reports whether the packets of the version are framed with the version 2 header and trailer.
+func isFramed(version byte) bool {
+	return version == VERSION_2 || version == VERSION_3
 }
 
 // The heartbeat packet is received from the device.
@@ -114,8 +317,7 @@ func (p *HeartbeatPacket) Parse(rawPacket *RawPacket) error {
 	}
 
 	p.GateID = binary.BigEndian.Uint16(rawPacket.raw)
-	p.Version = rawPacket.Version
-	p.PacketType = rawPacket.PacketType
+	p.RawPacket = *rawPacket
 
 	return nil
 }
@@ -133,34 +335,47 @@ func (p *HeartbeatPacket) SetGateID(gateID uint16) {
 //
 // This packet will be in the size of 4 + 4 + 16 = 24 bits indicating the version of the API + packet type
 // + ID of the device.
+//
+// From version 2 onwards, the packet can optionally carry a 16 bits per-device sequence number after the ID, which
+// the server acknowledges with an AckPacket and uses to ignore retried duplicates.
 type IncrementPacket struct {
 	RawPacket
 
-	GateID uint16
+	GateID   uint16
+	Sequence *uint16
 }
 
 func (p *IncrementPacket) Parse(rawPacket *RawPacket) error {
-	if len(rawPacket.raw) != 2 {
-		return ErrInvalidBinarySize
-	}
-
 	if rawPacket.PacketType != PacketTypeIncrement {
 		return ErrPacketTypeMismatch
 	}
 
-	p.GateID = binary.BigEndian.Uint16(rawPacket.raw)
-	p.Version = rawPacket.Version
-	p.PacketType = rawPacket.PacketType
+	gateID, sequence, err := parseGateIDAndSequence(rawPacket)
+	if err != nil {
+		return err
+	}
+
+	p.GateID = gateID
+	p.Sequence = sequence
+	p.RawPacket = *rawPacket
 
 	return nil
 }
 
 func (p *IncrementPacket) SetGateID(gateID uint16) {
 	p.GateID = gateID
-	p.raw = make([]byte, 2)
+	if len(p.raw) == 0 {
+		p.raw = make([]byte, 2)
+	}
+
 	binary.BigEndian.PutUint16(p.raw, gateID)
 }
 
+func (p *IncrementPacket) SetSequence(sequence uint16) {
+	p.Sequence = &sequence
+	p.raw = setSequence(p.raw, sequence)
+}
+
 // The decrement packet is received from the device.
 //
 // The ID is used to decrement the population counter that the gate is responsible for, either of the gateID
@@ -168,40 +383,242 @@ func (p *IncrementPacket) SetGateID(gateID uint16) {
 //
 // This packet will be in the size of 4 + 4 + 16 = 24 bits indicating the version of the API + packet type
 // + ID of the device.
+//
+// From version 2 onwards, the packet can optionally carry a 16 bits per-device sequence number after the ID, which
+// the server acknowledges with an AckPacket and uses to ignore retried duplicates.
 type DecrementPacket struct {
 	RawPacket
 
-	GateID uint16
+	GateID   uint16
+	Sequence *uint16
 }
 
 func (p *DecrementPacket) Parse(rawPacket *RawPacket) error {
-	if len(rawPacket.raw) != 2 {
-		return ErrInvalidBinarySize
-	}
-
 	if rawPacket.PacketType != PacketTypeDecrement {
 		return ErrPacketTypeMismatch
 	}
 
-	p.GateID = binary.BigEndian.Uint16(rawPacket.raw)
-	p.Version = rawPacket.Version
-	p.PacketType = rawPacket.PacketType
+	gateID, sequence, err := parseGateIDAndSequence(rawPacket)
+	if err != nil {
+		return err
+	}
+
+	p.GateID = gateID
+	p.Sequence = sequence
+	p.RawPacket = *rawPacket
 
 	return nil
 }
 
 func (p *DecrementPacket) SetGateID(gateID uint16) {
 	p.GateID = gateID
-	p.raw = make([]byte, 2)
+	if len(p.raw) == 0 {
+		p.raw = make([]byte, 2)
+	}
+
 	binary.BigEndian.PutUint16(p.raw, gateID)
 }
 
+func (p *DecrementPacket) SetSequence(sequence uint16) {
+	p.Sequence = &sequence
+	p.raw = setSequence(p.raw, sequence)
+}
+
+// parseGateIDAndSequence parses the payload shared by the increment and decrement packets, which is the gate ID
+// optionally followed by a sequence number for version 2 packets.
+func parseGateIDAndSequence(rawPacket *RawPacket) (uint16, *uint16, error) {
+	switch {
+	case len(rawPacket.raw) == 2:
+		return binary.BigEndian.Uint16(rawPacket.raw), nil, nil
+	case len(rawPacket.raw) == 4 && rawPacket.Version >= VERSION_2:
+		sequence := binary.BigEndian.Uint16(rawPacket.raw[2:])
+		return binary.BigEndian.Uint16(rawPacket.raw), &sequence, nil
+	default:
+		return 0, nil, ErrInvalidBinarySize
+	}
+}
+
+func setSequence(raw []byte, sequence uint16) []byte {
+	if len(raw) < 4 {
+		raw = append(raw, make([]byte, 4-len(raw))...)
+	}
+
+	binary.BigEndian.PutUint16(raw[2:], sequence)
+
+	return raw
+}
+
+// The ack packet is sent from the server to the device to acknowledge a packet that carried a sequence number, and
+// from the device to the server to acknowledge a downlink packet.
+//
+// As the packet is only meaningful with a sequence number, it is only available from version 2 onwards.
+//
+// This packet will have a payload in the size of 16 + 8 + 16 = 40 bits indicating the ID of the device + packet type
+// that is being acknowledged + the sequence number that is being acknowledged.
+type AckPacket struct {
+	RawPacket
+
+	GateID          uint16
+	AckedPacketType PacketType
+	Sequence        uint16
+}
+
+func (p *AckPacket) Parse(rawPacket *RawPacket) error {
+	if len(rawPacket.raw) != 5 {
+		return ErrInvalidBinarySize
+	}
+
+	if rawPacket.Version < VERSION_2 {
+		return ErrVersionMismatch
+	}
+
+	if rawPacket.PacketType != PacketTypeAck {
+		return ErrPacketTypeMismatch
+	}
+
+	p.GateID = binary.BigEndian.Uint16(rawPacket.raw[:2])
+	p.AckedPacketType = PacketType(rawPacket.raw[2])
+	p.Sequence = binary.BigEndian.Uint16(rawPacket.raw[3:])
+	p.RawPacket = *rawPacket
+
+	return nil
+}
+
+func (p *AckPacket) SetGateID(gateID uint16) {
+	p.GateID = gateID
+	if len(p.raw) == 0 {
+		p.raw = make([]byte, 5)
+	}
+
+	binary.BigEndian.PutUint16(p.raw[:2], gateID)
+}
+
+func (p *AckPacket) SetAckedPacketType(packetType PacketType) {
+	p.AckedPacketType = packetType
+	if len(p.raw) == 0 {
+		p.raw = make([]byte, 5)
+	}
+
+	p.raw[2] = byte(packetType)
+}
+
+func (p *AckPacket) SetSequence(sequence uint16) {
+	p.Sequence = sequence
+	if len(p.raw) == 0 {
+		p.raw = make([]byte, 5)
+	}
+
+	binary.BigEndian.PutUint16(p.raw[3:], sequence)
+}
+
+// NewAckFor creates the AckPacket that acknowledges the raw packet, returning false when the raw packet does not
+// carry a sequence number and therefore does not need to be acknowledged.
+func NewAckFor(rawPacket *RawPacket) (*AckPacket, bool) {
+	if rawPacket.PacketType != PacketTypeIncrement && rawPacket.PacketType != PacketTypeDecrement {
+		return nil, false
+	}
+
+	gateID, sequence, err := parseGateIDAndSequence(rawPacket)
+	if err != nil || sequence == nil {
+		return nil, false
+	}
+
+	ackPacket := &AckPacket{RawPacket: RawPacket{Version: CURRENT_VERSION, PacketType: PacketTypeAck}}
+	ackPacket.SetGateID(gateID)
+	ackPacket.SetAckedPacketType(rawPacket.PacketType)
+	ackPacket.SetSequence(*sequence)
+
+	return ackPacket, true
+}
+
+// The command packet is sent from the server to the device to instruct it to perform an action.
+//
+// The packet type determines the command:
+//   - PacketTypeReboot reboots the device.
+//   - PacketTypeSetHeartbeatInterval sets the interval between heartbeats in seconds to the argument.
+//   - PacketTypeSetIRSensitivity sets the sensitivity of the IR sensor to the argument.
+//   - PacketTypeIdentify blinks the LED of the device so it can be found physically.
+//
+// The device is expected to reply with an AckPacket carrying the packet type and sequence number of the command.
+//
+// As the packet requires a sequence number, it is only available from version 2 onwards.
+//
+// This packet will have a payload in the size of 16 + 16 = 32 bits indicating the ID of the device + sequence
+// number, followed by a 16 bits argument for the commands that require one.
+type CommandPacket struct {
+	RawPacket
+
+	GateID   uint16
+	Sequence uint16
+	Argument uint16
+}
+
+func (p *CommandPacket) Parse(rawPacket *RawPacket) error {
+	if rawPacket.Version < VERSION_2 {
+		return ErrVersionMismatch
+	}
+
+	if !rawPacket.PacketType.IsCommand() {
+		return ErrPacketTypeMismatch
+	}
+
+	if len(rawPacket.raw) != rawPacket.PacketType.commandPayloadLength() {
+		return ErrInvalidBinarySize
+	}
+
+	p.GateID = binary.BigEndian.Uint16(rawPacket.raw[:2])
+	p.Sequence = binary.BigEndian.Uint16(rawPacket.raw[2:4])
+	p.Argument = 0
+	if len(rawPacket.raw) == 6 {
+		p.Argument = binary.BigEndian.Uint16(rawPacket.raw[4:])
+	}
+	p.RawPacket = *rawPacket
+
+	return nil
+}
+
+func (p *CommandPacket) SetGateID(gateID uint16) {
+	p.GateID = gateID
+	if len(p.raw) == 0 {
+		p.raw = make([]byte, p.PacketType.commandPayloadLength())
+	}
+
+	binary.BigEndian.PutUint16(p.raw[:2], gateID)
+}
+
+func (p *CommandPacket) SetSequence(sequence uint16) {
+	p.Sequence = sequence
+	if len(p.raw) == 0 {
+		p.raw = make([]byte, p.PacketType.commandPayloadLength())
+	}
+
+	binary.BigEndian.PutUint16(p.raw[2:4], sequence)
+}
+
+func (p *CommandPacket) SetArgument(argument uint16) error {
+	if !p.PacketType.HasArgument() {
+		return ErrPacketTypeMismatch
+	}
+
+	p.Argument = argument
+	if len(p.raw) == 0 {
+		p.raw = make([]byte, 6)
+	}
+
+	binary.BigEndian.PutUint16(p.raw[4:], argument)
+
+	return nil
+}
+
 // The gate status packet is receivedd from the device when there is a change in state.
 //
 // The status is sent from the device when there is a change in status.
 //
 // This packet will be in the size of 4 + 4 + 16 + 8 + 32 = 64 bits indicating the version of the API
-// + packet type + ID + status + trigger time of the status.
+// + packet type + ID + status + trigger time of the status in seconds.
+//
+// From version 3 onwards, the trigger time is the 64 bits unix time in milliseconds instead, as a second is too coarse
+// to tell apart the gates of a door.
 type GateStatusPacket struct {
 	RawPacket
 
@@ -211,7 +628,7 @@ type GateStatusPacket struct {
 }
 
 func (p *GateStatusPacket) Parse(rawPacket *RawPacket) error {
-	if len(rawPacket.raw) != 7 {
+	if len(rawPacket.raw) != gateStatusPayloadLength(rawPacket.Version) {
 		return ErrInvalidBinarySize
 	}
 
@@ -220,16 +637,19 @@ func (p *GateStatusPacket) Parse(rawPacket *RawPacket) error {
 	}
 
 	p.GateID = binary.BigEndian.Uint16(rawPacket.raw[:2])
-	p.Version = rawPacket.Version
-	p.PacketType = rawPacket.PacketType
-
-	var timeData int32
-	buf := bytes.NewReader(rawPacket.raw[3:])
-	err := binary.Read(buf, binary.BigEndian, &timeData)
-	if err != nil {
-		return ErrInvalidTimestamp
+	p.RawPacket = *rawPacket
+
+	if rawPacket.Version >= VERSION_3 {
+		p.TriggerTime = time.UnixMilli(int64(binary.BigEndian.Uint64(rawPacket.raw[3:])))
+	} else {
+		var timeData int32
+		buf := bytes.NewReader(rawPacket.raw[3:])
+		err := binary.Read(buf, binary.BigEndian, &timeData)
+		if err != nil {
+			return ErrInvalidTimestamp
+		}
+		p.TriggerTime = time.Unix(int64(timeData), 0)
 	}
-	p.TriggerTime = time.Unix(int64(timeData), 0)
 
 	switch GateStatus(rawPacket.raw[2]) {
 	case GateStatusTurnOn, GateStatusUnblocked, GateStatusBlocked, GateStatusFaulty:
@@ -244,7 +664,7 @@ func (p *GateStatusPacket) Parse(rawPacket *RawPacket) error {
 func (p *GateStatusPacket) SetGateID(gateID uint16) {
 	p.GateID = gateID
 	if len(p.raw) == 0 {
-		p.raw = make([]byte, 7)
+		p.raw = make([]byte, gateStatusPayloadLength(p.Version))
 	}
 
 	p.raw[0] = byte(gateID >> 8)
@@ -254,13 +674,24 @@ func (p *GateStatusPacket) SetGateID(gateID uint16) {
 func (p *GateStatusPacket) SetStatus(status GateStatus) {
 	p.Status = status
 	if len(p.raw) == 0 {
-		p.raw = make([]byte, 7)
+		p.raw = make([]byte, gateStatusPayloadLength(p.Version))
 	}
 
 	p.raw[2] = byte(status)
 }
 
 func (p *GateStatusPacket) SetTimestamp(triggerTime time.Time) error {
+	if p.Version >= VERSION_3 {
+		p.TriggerTime = triggerTime
+		if len(p.raw) == 0 {
+			p.raw = make([]byte, gateStatusPayloadLength(p.Version))
+		}
+
+		binary.BigEndian.PutUint64(p.raw[3:], uint64(triggerTime.UnixMilli()))
+
+		return nil
+	}
+
 	int32Time, err := utils.TimeToInt32(triggerTime)
 	if err != nil {
 		return err
@@ -268,7 +699,7 @@ func (p *GateStatusPacket) SetTimestamp(triggerTime time.Time) error {
 
 	p.TriggerTime = triggerTime
 	if len(p.raw) == 0 {
-		p.raw = make([]byte, 7)
+		p.raw = make([]byte, gateStatusPayloadLength(p.Version))
 	}
 
 	p.raw[3] = byte(int32Time >> 24)
@@ -279,6 +710,18 @@ func (p *GateStatusPacket) SetTimestamp(triggerTime time.Time) error {
 	return nil
 }
 
+// HasPreciseTriggerTime reports whether the trigger time has millisecond resolution.
+func (p *GateStatusPacket) HasPreciseTriggerTime() bool {
+	return p.Version >= VERSION_3
+}
+
+func gateStatusPayloadLength(version byte) int {
+	if version >= VERSION_3 {
+		return 11
+	}
+	return 7
+}
+
 // The gate status is the enum of all possible status from the device in the GateStatusPacket.
 type GateStatus uint8
 
@@ -298,4 +741,64 @@ const (
 	PacketTypeGateStatus
 	PacketTypeIncrement
 	PacketTypeDecrement
+	PacketTypeAck
+	PacketTypeReboot
+	PacketTypeSetHeartbeatInterval
+	PacketTypeSetIRSensitivity
+	PacketTypeIdentify
 )
+
+func (t PacketType) String() string {
+	switch t {
+	case PacketTypeHeartbeat:
+		return "heartbeat"
+	case PacketTypeGateStatus:
+		return "gate-status"
+	case PacketTypeIncrement:
+		return "increment"
+	case PacketTypeDecrement:
+		return "decrement"
+	case PacketTypeAck:
+		return "ack"
+	case PacketTypeReboot:
+		return "reboot"
+	case PacketTypeSetHeartbeatInterval:
+		return "heartbeat-interval"
+	case PacketTypeSetIRSensitivity:
+		return "ir-sensitivity"
+	case PacketTypeIdentify:
+		return "identify"
+	default:
+		return "unknown"
+	}
+}
+
+func (t PacketType) IsValid() bool {
+	switch t {
+	case PacketTypeHeartbeat, PacketTypeGateStatus, PacketTypeIncrement, PacketTypeDecrement, PacketTypeAck:
+		return true
+	default:
+		return t.IsCommand()
+	}
+}
+
+// IsCommand reports whether the packet type is a downlink command sent from the server to the device.
+func (t PacketType) IsCommand() bool {
+	return t.commandPayloadLength() != 0
+}
+
+// HasArgument reports whether the packet type is a downlink command that carries an argument.
+func (t PacketType) HasArgument() bool {
+	return t.commandPayloadLength() == 6
+}
+
+func (t PacketType) commandPayloadLength() int {
+	switch t {
+	case PacketTypeReboot, PacketTypeIdentify:
+		return 4
+	case PacketTypeSetHeartbeatInterval, PacketTypeSetIRSensitivity:
+		return 6
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,536 @@
+package packet
+
+import (
+	"bufio"
+	"bytes"
+	"encoding"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/utils"
+)
+
+func newGateStatusPacket(t *testing.T, version byte, gateID uint16, status GateStatus, triggerTime time.Time) *GateStatusPacket {
+	t.Helper()
+
+	p := &GateStatusPacket{RawPacket: RawPacket{Version: version, PacketType: PacketTypeGateStatus}}
+	p.SetGateID(gateID)
+	p.SetStatus(status)
+	if err := p.SetTimestamp(triggerTime); err != nil {
+		t.Fatalf("SetTimestamp() error = %v", err)
+	}
+
+	return p
+}
+
+func TestV2RoundTrip(t *testing.T) {
+	triggerTime := time.Unix(1716912942, 0)
+
+	heartbeatPacket := &HeartbeatPacket{RawPacket: RawPacket{Version: VERSION_2, PacketType: PacketTypeHeartbeat}}
+	heartbeatPacket.SetGateID(0x1234)
+
+	incrementPacket := &IncrementPacket{RawPacket: RawPacket{Version: VERSION_2, PacketType: PacketTypeIncrement}}
+	incrementPacket.SetGateID(0xBEEF)
+
+	decrementPacket := &DecrementPacket{RawPacket: RawPacket{Version: VERSION_2, PacketType: PacketTypeDecrement}}
+	decrementPacket.SetGateID(1)
+
+	sequencedIncrementPacket := &IncrementPacket{RawPacket: RawPacket{Version: VERSION_2, PacketType: PacketTypeIncrement}}
+	sequencedIncrementPacket.SetGateID(0xBEEF)
+	sequencedIncrementPacket.SetSequence(0xFFFE)
+
+	ackPacket, ok := NewAckFor(&sequencedIncrementPacket.RawPacket)
+	if !ok {
+		t.Fatalf("NewAckFor() of sequenced increment = false, expected true")
+	}
+
+	tests := []struct {
+		name   string
+		packet encoding.BinaryMarshaler
+		parse  func(rawPacket *RawPacket) (uint16, error)
+		gateID uint16
+	}{
+		{
+			name:   "Heartbeat",
+			packet: heartbeatPacket,
+			parse: func(rawPacket *RawPacket) (uint16, error) {
+				p := &HeartbeatPacket{}
+				err := p.Parse(rawPacket)
+				return p.GateID, err
+			},
+			gateID: 0x1234,
+		},
+		{
+			name:   "Increment",
+			packet: incrementPacket,
+			parse: func(rawPacket *RawPacket) (uint16, error) {
+				p := &IncrementPacket{}
+				err := p.Parse(rawPacket)
+				return p.GateID, err
+			},
+			gateID: 0xBEEF,
+		},
+		{
+			name:   "Decrement",
+			packet: decrementPacket,
+			parse: func(rawPacket *RawPacket) (uint16, error) {
+				p := &DecrementPacket{}
+				err := p.Parse(rawPacket)
+				return p.GateID, err
+			},
+			gateID: 1,
+		},
+		{
+			name:   "Sequenced Increment",
+			packet: sequencedIncrementPacket,
+			parse: func(rawPacket *RawPacket) (uint16, error) {
+				p := &IncrementPacket{}
+				err := p.Parse(rawPacket)
+				if err == nil && (p.Sequence == nil || *p.Sequence != 0xFFFE) {
+					err = errors.New("sequence mismatched")
+				}
+				return p.GateID, err
+			},
+			gateID: 0xBEEF,
+		},
+		{
+			name:   "Ack",
+			packet: ackPacket,
+			parse: func(rawPacket *RawPacket) (uint16, error) {
+				p := &AckPacket{}
+				err := p.Parse(rawPacket)
+				if err == nil && (p.AckedPacketType != PacketTypeIncrement || p.Sequence != 0xFFFE) {
+					err = errors.New("acked packet type or sequence mismatched")
+				}
+				return p.GateID, err
+			},
+			gateID: 0xBEEF,
+		},
+		{
+			name:   "Gate Status",
+			packet: newGateStatusPacket(t, VERSION_2, 0x0102, GateStatusBlocked, triggerTime),
+			parse: func(rawPacket *RawPacket) (uint16, error) {
+				p := &GateStatusPacket{}
+				err := p.Parse(rawPacket)
+				if err == nil && (p.Status != GateStatusBlocked || !p.TriggerTime.Equal(triggerTime)) {
+					err = errors.New("status or trigger time mismatched")
+				}
+				return p.GateID, err
+			},
+			gateID: 0x0102,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := tt.packet.MarshalBinary()
+			if err != nil {
+				t.Fatalf("MarshalBinary() error = %v", err)
+			}
+
+			if data[0]>>4 != VERSION_2 {
+				t.Fatalf("MarshalBinary() version = %d, expected %d", data[0]>>4, VERSION_2)
+			}
+
+			if int(data[2]) != len(data)-V2_HEADER_LENGTH-V2_TRAILER_LENGTH {
+				t.Fatalf("MarshalBinary() length = %d, expected %d", data[2], len(data)-V2_HEADER_LENGTH-V2_TRAILER_LENGTH)
+			}
+
+			rawPacket := &RawPacket{}
+			if err := rawPacket.ReadPackets(bufio.NewReader(bytes.NewReader(data))); err != nil {
+				t.Fatalf("ReadPackets() error = %v", err)
+			}
+
+			gateID, err := tt.parse(rawPacket)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+
+			if gateID != tt.gateID {
+				t.Errorf("Parse() gateID = %X, expected %X", gateID, tt.gateID)
+			}
+
+			remarshalled, err := rawPacket.MarshalBinary()
+			if err != nil {
+				t.Fatalf("MarshalBinary() of read packet error = %v", err)
+			}
+
+			if !bytes.Equal(remarshalled, data) {
+				t.Errorf("MarshalBinary() of read packet = %X, expected %X", remarshalled, data)
+			}
+		})
+	}
+}
+
+func TestCommandRoundTrip(t *testing.T) {
+	tests := []struct {
+		name          string
+		packetType    PacketType
+		argument      uint16
+		payloadLength int
+		hasArgument   bool
+	}{
+		{"Reboot", PacketTypeReboot, 0, 4, false},
+		{"Set Heartbeat Interval", PacketTypeSetHeartbeatInterval, 30, 6, true},
+		{"Set IR Sensitivity", PacketTypeSetIRSensitivity, 0xFFFF, 6, true},
+		{"Identify", PacketTypeIdentify, 0, 4, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !tt.packetType.IsCommand() {
+				t.Fatalf("IsCommand() = false, expected true")
+			}
+
+			if tt.packetType.HasArgument() != tt.hasArgument {
+				t.Fatalf("HasArgument() = %v, expected %v", tt.packetType.HasArgument(), tt.hasArgument)
+			}
+
+			commandPacket := &CommandPacket{RawPacket: RawPacket{Version: CURRENT_VERSION, PacketType: tt.packetType}}
+			commandPacket.SetGateID(0xBEEF)
+			commandPacket.SetSequence(42)
+
+			err := commandPacket.SetArgument(tt.argument)
+			if tt.hasArgument && err != nil {
+				t.Fatalf("SetArgument() error = %v", err)
+			}
+			if !tt.hasArgument && !errors.Is(err, ErrPacketTypeMismatch) {
+				t.Fatalf("SetArgument() error = %v, expected %v", err, ErrPacketTypeMismatch)
+			}
+
+			data, err := commandPacket.MarshalBinary()
+			if err != nil {
+				t.Fatalf("MarshalBinary() error = %v", err)
+			}
+
+			if int(data[2]) != tt.payloadLength {
+				t.Fatalf("MarshalBinary() length = %d, expected %d", data[2], tt.payloadLength)
+			}
+
+			rawPacket := &RawPacket{}
+			if err := rawPacket.ReadPackets(bufio.NewReader(bytes.NewReader(data))); err != nil {
+				t.Fatalf("ReadPackets() error = %v", err)
+			}
+
+			parsed := &CommandPacket{}
+			if err := parsed.Parse(rawPacket); err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+
+			if parsed.PacketType != tt.packetType {
+				t.Errorf("Parse() packet type = %v, expected %v", parsed.PacketType, tt.packetType)
+			}
+
+			if parsed.GateID != 0xBEEF || parsed.Sequence != 42 {
+				t.Errorf("Parse() gateID, sequence = %X, %d, expected %X, %d", parsed.GateID, parsed.Sequence, 0xBEEF, 42)
+			}
+
+			expectedArgument := uint16(0)
+			if tt.hasArgument {
+				expectedArgument = tt.argument
+			}
+			if parsed.Argument != expectedArgument {
+				t.Errorf("Parse() argument = %d, expected %d", parsed.Argument, expectedArgument)
+			}
+		})
+	}
+}
+
+func TestV1StillReadable(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      []byte
+		packetType PacketType
+	}{
+		{
+			name:       "Heartbeat",
+			input:      []byte{0x11, 0x12, 0x34},
+			packetType: PacketTypeHeartbeat,
+		},
+		{
+			name:       "Gate Status",
+			input:      []byte{0x12, 0x12, 0x34, 0x03, 0x66, 0x55, 0x6B, 0x2E},
+			packetType: PacketTypeGateStatus,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rawPacket := &RawPacket{}
+			if err := rawPacket.ReadPackets(bufio.NewReader(bytes.NewReader(tt.input))); err != nil {
+				t.Fatalf("ReadPackets() error = %v", err)
+			}
+
+			if rawPacket.Version != VERSION_1 || rawPacket.PacketType != tt.packetType {
+				t.Errorf(
+					"ReadPackets() = (%d, %d), expected (%d, %d)",
+					rawPacket.Version,
+					rawPacket.PacketType,
+					VERSION_1,
+					tt.packetType,
+				)
+			}
+
+			data, err := rawPacket.MarshalBinary()
+			if err != nil {
+				t.Fatalf("MarshalBinary() error = %v", err)
+			}
+
+			if !bytes.Equal(data, tt.input) {
+				t.Errorf("MarshalBinary() = %X, expected %X", data, tt.input)
+			}
+		})
+	}
+}
+
+func TestV3GateStatusRoundTrip(t *testing.T) {
+	triggerTime := time.UnixMilli(1716912942123)
+	gateStatusPacket := newGateStatusPacket(t, VERSION_3, 0x1234, GateStatusBlocked, triggerTime)
+
+	data, err := gateStatusPacket.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary() error = %v", err)
+	}
+
+	rawPacket := &RawPacket{}
+	if err := rawPacket.ReadPackets(bufio.NewReader(bytes.NewReader(data))); err != nil {
+		t.Fatalf("ReadPackets() error = %v", err)
+	}
+
+	p := &GateStatusPacket{}
+	if err := p.Parse(rawPacket); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if p.GateID != 0x1234 || p.Status != GateStatusBlocked || !p.TriggerTime.Equal(triggerTime) {
+		t.Errorf(
+			"Parse() = (%X, %v, %v), expected (%X, %v, %v)",
+			p.GateID,
+			p.Status,
+			p.TriggerTime,
+			0x1234,
+			GateStatusBlocked,
+			triggerTime,
+		)
+	}
+	if !p.HasPreciseTriggerTime() {
+		t.Errorf("HasPreciseTriggerTime() = false, expected true")
+	}
+
+	// the version 2 payload is too short to be a version 3 gate status
+	rawPacket.Version = VERSION_2
+	if err := (&GateStatusPacket{}).Parse(rawPacket); !errors.Is(err, ErrInvalidBinarySize) {
+		t.Errorf("Parse() as version 2 error = %v, expected %v", err, ErrInvalidBinarySize)
+	}
+}
+
+func TestNewAckForUnsequenced(t *testing.T) {
+	incrementPacket := &IncrementPacket{RawPacket: RawPacket{Version: VERSION_2, PacketType: PacketTypeIncrement}}
+	incrementPacket.SetGateID(1)
+
+	if _, ok := NewAckFor(&incrementPacket.RawPacket); ok {
+		t.Errorf("NewAckFor() of unsequenced increment = true, expected false")
+	}
+
+	// version 1 packets cannot carry a sequence number
+	v1IncrementPacket := &RawPacket{}
+	if err := v1IncrementPacket.UnmarshalBinary([]byte{0x13, 0x00, 0x01, 0x00, 0x02}); err == nil {
+		t.Errorf("UnmarshalBinary() of oversized version 1 increment error = nil, expected error")
+	}
+}
+
+func TestUnmarshalBinaryRejectsCorruption(t *testing.T) {
+	heartbeatPacket := &HeartbeatPacket{RawPacket: RawPacket{Version: VERSION_2, PacketType: PacketTypeHeartbeat}}
+	heartbeatPacket.SetGateID(0x1234)
+	valid, err := heartbeatPacket.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary() error = %v", err)
+	}
+
+	corrupt := func(i int, b byte) []byte {
+		data := append([]byte(nil), valid...)
+		data[i] = b
+		return data
+	}
+
+	tests := []struct {
+		name    string
+		input   []byte
+		wantErr error
+	}{
+		{
+			name:    "Flipped Payload Byte",
+			input:   corrupt(3, 0xFF),
+			wantErr: ErrChecksumMismatch,
+		},
+		{
+			name:    "Flipped Checksum Byte",
+			input:   corrupt(len(valid)-1, valid[len(valid)-1]^0x01),
+			wantErr: ErrChecksumMismatch,
+		},
+		{
+			name:    "Unknown Version",
+			input:   corrupt(0, 0x71),
+			wantErr: ErrVersionMismatch,
+		},
+		{
+			name:    "Unknown Packet Type",
+			input:   corrupt(0, 0x2F),
+			wantErr: ErrInvalidPacketType,
+		},
+		{
+			name:    "Oversized Length",
+			input:   corrupt(2, MAX_PAYLOAD_LENGTH+1),
+			wantErr: ErrPayloadTooLarge,
+		},
+		{
+			name:    "Truncated",
+			input:   valid[:len(valid)-1],
+			wantErr: ErrInvalidBinarySize,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&RawPacket{}).UnmarshalBinary(tt.input)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("UnmarshalBinary(%X) error = %v, expected %v", tt.input, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadPacketsResynchronises(t *testing.T) {
+	first := &HeartbeatPacket{RawPacket: RawPacket{Version: VERSION_2, PacketType: PacketTypeHeartbeat}}
+	first.SetGateID(1)
+	firstData, _ := first.MarshalBinary()
+
+	second := &IncrementPacket{RawPacket: RawPacket{Version: VERSION_2, PacketType: PacketTypeIncrement}}
+	second.SetGateID(2)
+	secondData, _ := second.MarshalBinary()
+
+	corruptedFirst := append([]byte(nil), firstData...)
+	corruptedFirst[4] ^= 0xFF
+
+	stream := &bytes.Buffer{}
+	stream.Write([]byte{0x00, 0xFF})
+	stream.Write(corruptedFirst)
+	stream.Write(secondData)
+	stream.Write([]byte{0x11, 0x00, 0x03})
+
+	reader := bufio.NewReader(stream)
+
+	expected := [][]byte{secondData, {0x11, 0x00, 0x03}}
+
+	for _, e := range expected {
+		rawPacket := &RawPacket{}
+		if err := rawPacket.ReadPackets(reader); err != nil {
+			t.Fatalf("ReadPackets() error = %v", err)
+		}
+
+		data, err := rawPacket.MarshalBinary()
+		if err != nil {
+			t.Fatalf("MarshalBinary() error = %v", err)
+		}
+
+		if !bytes.Equal(data, e) {
+			t.Errorf("ReadPackets() = %X, expected %X", data, e)
+		}
+	}
+
+	if err := (&RawPacket{}).ReadPackets(reader); !errors.Is(err, io.EOF) {
+		t.Errorf("ReadPackets() at end of stream error = %v, expected %v", err, io.EOF)
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	secret := []byte("device secret")
+
+	incrementPacket := &IncrementPacket{RawPacket: RawPacket{Version: VERSION_2, PacketType: PacketTypeIncrement}}
+	incrementPacket.SetGateID(0x0102)
+	incrementPacket.SetSequence(7)
+	if err := incrementPacket.Sign(secret, 42); err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	valid, err := incrementPacket.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary() error = %v", err)
+	}
+
+	if int(valid[2]) != 4+AUTH_TRAILER_LENGTH {
+		t.Fatalf("MarshalBinary() length = %d, expected %d", valid[2], 4+AUTH_TRAILER_LENGTH)
+	}
+
+	// tamper rewrites a payload byte and fixes up the checksum, so only the HMAC can detect it
+	tamper := func(i int) []byte {
+		data := append([]byte(nil), valid...)
+		data[i] ^= 0x01
+		checksumOffset := len(data) - V2_TRAILER_LENGTH
+		crc := utils.CRC16CCITT(data[:checksumOffset])
+		data[checksumOffset] = byte(crc >> 8)
+		data[checksumOffset+1] = byte(crc)
+		return data
+	}
+
+	tests := []struct {
+		name     string
+		input    []byte
+		secret   []byte
+		expected bool
+	}{
+		{
+			name:     "Valid",
+			input:    valid,
+			secret:   secret,
+			expected: true,
+		},
+		{
+			name:     "Wrong Secret",
+			input:    valid,
+			secret:   []byte("another secret"),
+			expected: false,
+		},
+		{
+			name:     "Tampered Gate ID",
+			input:    tamper(V2_HEADER_LENGTH),
+			secret:   secret,
+			expected: false,
+		},
+		{
+			name:     "Tampered Counter",
+			input:    tamper(V2_HEADER_LENGTH + 4),
+			secret:   secret,
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rawPacket := &RawPacket{}
+			if err := rawPacket.UnmarshalBinary(tt.input); err != nil {
+				t.Fatalf("UnmarshalBinary() error = %v", err)
+			}
+
+			if result := rawPacket.Verify(tt.secret); result != tt.expected {
+				t.Errorf("Verify() = %v, expected %v", result, tt.expected)
+			}
+		})
+	}
+
+	rawPacket := &RawPacket{}
+	if err := rawPacket.UnmarshalBinary(valid); err != nil {
+		t.Fatalf("UnmarshalBinary() error = %v", err)
+	}
+
+	parsed := &IncrementPacket{}
+	if err := parsed.Parse(rawPacket); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if parsed.GateID != 0x0102 || parsed.Sequence == nil || *parsed.Sequence != 7 || parsed.Counter != 42 {
+		t.Errorf("Parse() = (%X, %v, %d), expected (%X, 7, 42)", parsed.GateID, parsed.Sequence, parsed.Counter, 0x0102)
+	}
+}
@@ -0,0 +1,81 @@
+package packetauth
+
+import (
+	"encoding/hex"
+	"errors"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+	"github.com/kKar1503/rewired-server-2024/internal/packet"
+	"github.com/kKar1503/rewired-server-2024/internal/settings"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrUnknownDevice   = errors.New("device is not registered")
+	ErrUnauthenticated = errors.New("packet is not authenticated")
+	ErrNoSecret        = errors.New("device has no secret to verify the packet with")
+	ErrInvalidSecret   = errors.New("device secret is not valid hex")
+	ErrForged          = errors.New("packet signature mismatched")
+	ErrReplayed        = errors.New("packet counter was already used")
+)
+
+// Verify checks that the packet was signed by the device it claims to be from, and that its counter was not used
+// before.
+//
+// Devices without a secret are only allowed to send unauthenticated packets when settings.AllowLegacyDevices is set,
+// while devices with a secret must always sign their packets.
+func Verify(rawPacket *packet.RawPacket) error {
+	gateID, err := rawPacket.GateID()
+	if err != nil {
+		return err
+	}
+
+	device := &db.Device{}
+	result := db.Get().Where(&db.Device{GateID: gateID}).First(device)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return ErrUnknownDevice
+	}
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if device.Secret == "" {
+		if rawPacket.IsAuthenticated() {
+			return ErrNoSecret
+		}
+
+		if !settings.Get().AllowLegacyDevices {
+			return ErrUnauthenticated
+		}
+
+		return nil
+	}
+
+	if !rawPacket.IsAuthenticated() {
+		return ErrUnauthenticated
+	}
+
+	secret, err := hex.DecodeString(device.Secret)
+	if err != nil {
+		return ErrInvalidSecret
+	}
+
+	if !rawPacket.Verify(secret) {
+		return ErrForged
+	}
+
+	// the counter is only moved forward when it is greater than the last accepted, which rejects replays atomically
+	result = db.Get().
+		Model(&db.Device{}).
+		Where("id = ? AND auth_counter < ?", device.ID, rawPacket.Counter).
+		Update("auth_counter", rawPacket.Counter)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrReplayed
+	}
+
+	return nil
+}
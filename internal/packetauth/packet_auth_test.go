@@ -0,0 +1,112 @@
+package packetauth
+
+import (
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+	"github.com/kKar1503/rewired-server-2024/internal/packet"
+	"github.com/kKar1503/rewired-server-2024/internal/settings"
+)
+
+const (
+	signingGateID       uint16 = 1
+	legacyGateID        uint16 = 2
+	invalidSecretGateID uint16 = 3
+	unknownGateID       uint16 = 99
+)
+
+var (
+	secret      = []byte("0123456789abcdef")
+	otherSecret = []byte("fedcba9876543210")
+)
+
+func increment(gateID uint16, sequence uint16) *packet.RawPacket {
+	incrementPacket := &packet.IncrementPacket{
+		RawPacket: packet.RawPacket{Version: packet.VERSION_2, PacketType: packet.PacketTypeIncrement},
+	}
+	incrementPacket.SetGateID(gateID)
+	incrementPacket.SetSequence(sequence)
+	return &incrementPacket.RawPacket
+}
+
+func signed(t *testing.T, gateID uint16, secret []byte, counter uint32) *packet.RawPacket {
+	t.Helper()
+
+	rawPacket := increment(gateID, 1)
+	if err := rawPacket.Sign(secret, counter); err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	return rawPacket
+}
+
+func TestVerify(t *testing.T) {
+	if err := db.Init(filepath.Join(t.TempDir(), "rewired.db")); err != nil {
+		t.Fatalf("db.Init() error = %v", err)
+	}
+
+	allowLegacyDevices := settings.Get().AllowLegacyDevices
+	t.Cleanup(func() { settings.Get().AllowLegacyDevices = allowLegacyDevices })
+
+	err := db.Get().Create([]*db.Device{
+		{GateID: signingGateID, Secret: hex.EncodeToString(secret), AuthCounter: 10},
+		{GateID: legacyGateID},
+		{GateID: invalidSecretGateID, Secret: "not hex"},
+	}).Error
+	if err != nil {
+		t.Fatalf("failed to create devices: %v", err)
+	}
+
+	// the sequence is changed after the packet was signed
+	tampered := &packet.IncrementPacket{
+		RawPacket: packet.RawPacket{Version: packet.VERSION_2, PacketType: packet.PacketTypeIncrement},
+	}
+	tampered.SetGateID(signingGateID)
+	tampered.SetSequence(1)
+	if err := tampered.Sign(secret, 30); err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	tampered.SetSequence(2)
+
+	// the cases run in order, as the counter of the signing device moves forward with every packet accepted
+	tests := []struct {
+		name        string
+		rawPacket   *packet.RawPacket
+		allowLegacy bool
+		expected    error
+		counter     uint32 // the counter of the signing device after the packet
+	}{
+		{"Valid MAC", signed(t, signingGateID, secret, 11), false, nil, 11},
+		{"Replayed Counter", signed(t, signingGateID, secret, 11), false, ErrReplayed, 11},
+		{"Lower Counter", signed(t, signingGateID, secret, 5), false, ErrReplayed, 11},
+		{"Forged MAC", signed(t, signingGateID, otherSecret, 20), false, ErrForged, 11},
+		{"Tampered Payload", &tampered.RawPacket, false, ErrForged, 11},
+		{"Unsigned From Device With Secret", increment(signingGateID, 1), true, ErrUnauthenticated, 11},
+		{"Next Counter", signed(t, signingGateID, secret, 12), false, nil, 12},
+		{"Unknown Device", signed(t, unknownGateID, secret, 1), true, ErrUnknownDevice, 12},
+		{"Signed From Device Without Secret", signed(t, legacyGateID, secret, 1), true, ErrNoSecret, 12},
+		{"Invalid Secret", signed(t, invalidSecretGateID, secret, 1), true, ErrInvalidSecret, 12},
+		{"Legacy Allowed", increment(legacyGateID, 1), true, nil, 12},
+		{"Legacy Rejected", increment(legacyGateID, 1), false, ErrUnauthenticated, 12},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings.Get().AllowLegacyDevices = tt.allowLegacy
+
+			if err := Verify(tt.rawPacket); !errors.Is(err, tt.expected) {
+				t.Errorf("Verify() error = %v, expected %v", err, tt.expected)
+			}
+
+			device := &db.Device{}
+			if err := db.Get().Where(&db.Device{GateID: signingGateID}).First(device).Error; err != nil {
+				t.Fatalf("failed to find device: %v", err)
+			}
+			if device.AuthCounter != tt.counter {
+				t.Errorf("AuthCounter = %d, expected %d", device.AuthCounter, tt.counter)
+			}
+		})
+	}
+}
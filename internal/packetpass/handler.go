@@ -0,0 +1,111 @@
+package packetpass
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+	"github.com/kKar1503/rewired-server-2024/internal/packet"
+)
+
+var (
+	ErrUnhandledPacketType = errors.New("no handler registered for packet type")
+	ErrUndecodable         = errors.New("failed to decode packet")
+)
+
+// The packet going through the handlers, holding the raw packet, the packet decoded from it and the device it is from
+// once it has been resolved.
+type Packet struct {
+	Raw     *packet.RawPacket
+	Decoded any
+	GateID  uint16
+	Device  *db.Device
+}
+
+// The handler of a decoded packet.
+type Handler interface {
+	Handle(p *Packet) error
+}
+
+type HandlerFunc func(p *Packet) error
+
+func (f HandlerFunc) Handle(p *Packet) error {
+	return f(p)
+}
+
+// The middleware wraps every handler in the registry, to run logic such as logging, metrics and authentication
+// around the handlers.
+type Middleware func(next Handler) Handler
+
+// The decoder of the raw packet into the packet struct of its packet type.
+type Decoder func(rawPacket *packet.RawPacket) (any, error)
+
+// ANY_VERSION registers the decoder and handler for every version of the packet type without a version specific
+// registration.
+const ANY_VERSION byte = 0
+
+type route struct {
+	version    byte
+	packetType packet.PacketType
+}
+
+type registration struct {
+	decoder Decoder
+	handler Handler
+}
+
+// The registry of decoders and handlers by packet type and version.
+type Registry struct {
+	routes      map[route]registration
+	middlewares []Middleware
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		routes: make(map[route]registration),
+	}
+}
+
+// Register registers the decoder and handler for every version of the packet type.
+func (r *Registry) Register(packetType packet.PacketType, decoder Decoder, handler Handler) {
+	r.RegisterVersion(ANY_VERSION, packetType, decoder, handler)
+}
+
+// RegisterVersion registers the decoder and handler for a specific version of the packet type, which takes precedence
+// over the registration for every version.
+func (r *Registry) RegisterVersion(version byte, packetType packet.PacketType, decoder Decoder, handler Handler) {
+	r.routes[route{version: version, packetType: packetType}] = registration{decoder: decoder, handler: handler}
+}
+
+// Use adds the middlewares to the registry, where the first middleware added is the outermost.
+func (r *Registry) Use(middlewares ...Middleware) {
+	r.middlewares = append(r.middlewares, middlewares...)
+}
+
+// Dispatch decodes the raw packet and runs it through the middlewares and the handler of its packet type and version.
+func (r *Registry) Dispatch(rawPacket *packet.RawPacket) error {
+	reg, ok := r.routes[route{version: rawPacket.Version, packetType: rawPacket.PacketType}]
+	if !ok {
+		reg, ok = r.routes[route{version: ANY_VERSION, packetType: rawPacket.PacketType}]
+	}
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnhandledPacketType, rawPacket.PacketType)
+	}
+
+	decoded, err := reg.decoder(rawPacket)
+	if err != nil {
+		return fmt.Errorf("%w: %s packet: %w", ErrUndecodable, rawPacket.PacketType, err)
+	}
+
+	gateID, err := rawPacket.GateID()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrUndecodable, err)
+	}
+
+	handler := reg.handler
+	for i := len(r.middlewares) - 1; i >= 0; i-- {
+		handler = r.middlewares[i](handler)
+	}
+
+	return handler.Handle(&Packet{Raw: rawPacket, Decoded: decoded, GateID: gateID})
+}
@@ -0,0 +1,177 @@
+package packetpass
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/clockskew"
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+	"github.com/kKar1503/rewired-server-2024/internal/devicelog"
+	"github.com/kKar1503/rewired-server-2024/internal/doorpass"
+	"github.com/kKar1503/rewired-server-2024/internal/downlink"
+	"github.com/kKar1503/rewired-server-2024/internal/gateconnection"
+	"github.com/kKar1503/rewired-server-2024/internal/packet"
+	"github.com/kKar1503/rewired-server-2024/internal/population"
+)
+
+// NewDefaultRegistry creates the registry with the handlers of every packet type the devices send.
+func NewDefaultRegistry(bytesEgress chan<- []byte) *Registry {
+	registry := NewRegistry()
+	registry.Use(Metrics, Authenticate, Acknowledge, Deduplicate, Debug(bytesEgress), ResolveDevice)
+
+	registry.Register(packet.PacketTypeHeartbeat, DecodeHeartbeat, HandlerFunc(HandleHeartbeat))
+	registry.Register(packet.PacketTypeGateStatus, DecodeGateStatus, HandlerFunc(HandleGateStatus))
+	registry.Register(packet.PacketTypeIncrement, DecodeIncrement, HandlerFunc(HandleIncrement))
+	registry.Register(packet.PacketTypeDecrement, DecodeDecrement, HandlerFunc(HandleDecrement))
+	registry.Register(packet.PacketTypeAck, DecodeAck, HandlerFunc(HandleAck))
+
+	return registry
+}
+
+func DecodeHeartbeat(rawPacket *packet.RawPacket) (any, error) {
+	heartbeatPacket := &packet.HeartbeatPacket{}
+	return heartbeatPacket, heartbeatPacket.Parse(rawPacket)
+}
+
+func DecodeGateStatus(rawPacket *packet.RawPacket) (any, error) {
+	gateStatusPacket := &packet.GateStatusPacket{}
+	return gateStatusPacket, gateStatusPacket.Parse(rawPacket)
+}
+
+func DecodeIncrement(rawPacket *packet.RawPacket) (any, error) {
+	incrementPacket := &packet.IncrementPacket{}
+	return incrementPacket, incrementPacket.Parse(rawPacket)
+}
+
+func DecodeDecrement(rawPacket *packet.RawPacket) (any, error) {
+	decrementPacket := &packet.DecrementPacket{}
+	return decrementPacket, decrementPacket.Parse(rawPacket)
+}
+
+func DecodeAck(rawPacket *packet.RawPacket) (any, error) {
+	ackPacket := &packet.AckPacket{}
+	return ackPacket, ackPacket.Parse(rawPacket)
+}
+
+func HandleHeartbeat(p *Packet) error {
+	slog.Info("received a heartbeat", "gateID", p.GateID)
+
+	appendDeviceLog(p, &db.DeviceLog{LogType: 1})
+
+	gateconnection.KeepConnected(p.GateID)
+
+	return nil
+}
+
+func HandleGateStatus(p *Packet) error {
+	gateStatusPacket := p.Decoded.(*packet.GateStatusPacket)
+
+	slog.Info("received a status",
+		"gateID",
+		gateStatusPacket.GateID,
+		"status",
+		gateStatusPacket.Status,
+		"timestamp",
+		gateStatusPacket.TriggerTime,
+	)
+
+	appendDeviceLog(p, &db.DeviceLog{
+		LogType:     2,
+		Status:      (*uint8)(&gateStatusPacket.Status),
+		TriggerTime: &gateStatusPacket.TriggerTime,
+	})
+
+	// a redriven status was received long before the live statuses of its gate, so it is only logged, as the doors
+	// expect the activity of their gates in time order, and neither its clock skew nor its turn on is current
+	if p.Raw.Redriven {
+		slog.Info("skipped door detection of redriven status", "gateID", gateStatusPacket.GateID)
+		return nil
+	}
+
+	// the device restarts its sequence from 0 when it turns on, which must not be ignored as duplicates
+	if gateStatusPacket.Status == packet.GateStatusTurnOn {
+		sequences.reset(gateStatusPacket.GateID)
+	}
+
+	// every status is a sample of the clock skew of the gate, not only the ones the door passes are detected from
+	at := triggeredAt(p, gateStatusPacket)
+	if gateStatusPacket.Status == packet.GateStatusUnblocked {
+		doorpass.GateActive(gateStatusPacket.GateID, at)
+	}
+
+	return nil
+}
+
+func HandleIncrement(p *Packet) error {
+	slog.Info("received an increment", "gateID", p.GateID)
+
+	// the packet is failed rather than acked when it was not counted, so the device retries it, and it is only logged
+	// once counted, so the retries are not logged again
+	if err := population.IncrementPopulation(p.GateID, receivedAt(p)); err != nil {
+		return err
+	}
+
+	appendDeviceLog(p, &db.DeviceLog{LogType: 3})
+
+	return nil
+}
+
+func HandleDecrement(p *Packet) error {
+	slog.Info("received a decrement", "gateID", p.GateID)
+
+	// as with the increments, the decrement is only acked and logged once counted
+	if err := population.DecrementPopulation(p.GateID, receivedAt(p)); err != nil {
+		return err
+	}
+
+	appendDeviceLog(p, &db.DeviceLog{LogType: 4})
+
+	return nil
+}
+
+func HandleAck(p *Packet) error {
+	ackPacket := p.Decoded.(*packet.AckPacket)
+
+	slog.Info("received an ack",
+		"gateID",
+		ackPacket.GateID,
+		"ackedPacketType",
+		ackPacket.AckedPacketType,
+		"sequence",
+		ackPacket.Sequence,
+	)
+
+	downlink.Acknowledge(ackPacket.GateID, ackPacket.AckedPacketType, ackPacket.Sequence)
+
+	return nil
+}
+
+// appendDeviceLog queues the device log of the packet to be inserted by the write-behind buffer, so the handler does not
+// wait on the insert.
+func appendDeviceLog(p *Packet, deviceLog *db.DeviceLog) {
+	at := receivedAt(p)
+	deviceLog.DeviceID = p.Device.ID
+	deviceLog.ReceivedAt = &at
+	devicelog.Get().Append(deviceLog)
+}
+
+// triggeredAt returns the time the status was triggered in the time of the server, corrected by the clock skew of the
+// gate. The trigger time of packets before version 3 is in seconds, which is too coarse for the door passes, so the
+// receive time is used for them instead.
+func triggeredAt(p *Packet, gateStatusPacket *packet.GateStatusPacket) time.Time {
+	if !gateStatusPacket.HasPreciseTriggerTime() {
+		return receivedAt(p)
+	}
+
+	clockskew.Get().Observe(gateStatusPacket.GateID, gateStatusPacket.TriggerTime, receivedAt(p))
+	return clockskew.Get().Correct(gateStatusPacket.GateID, gateStatusPacket.TriggerTime, receivedAt(p))
+}
+
+// receivedAt returns the time the packet was received, falling back to now for packets that were not received by a
+// server.
+func receivedAt(p *Packet) time.Time {
+	if p.Raw.ReceivedAt.IsZero() {
+		return time.Now()
+	}
+	return p.Raw.ReceivedAt
+}
@@ -0,0 +1,688 @@
+package packetpass
+
+import (
+	"encoding"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/clockskew"
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+	"github.com/kKar1503/rewired-server-2024/internal/devicelog"
+	"github.com/kKar1503/rewired-server-2024/internal/doorpass"
+	"github.com/kKar1503/rewired-server-2024/internal/downlink"
+	"github.com/kKar1503/rewired-server-2024/internal/gateconnection"
+	"github.com/kKar1503/rewired-server-2024/internal/packet"
+	"github.com/kKar1503/rewired-server-2024/internal/settings"
+	"gorm.io/gorm"
+)
+
+const (
+	innerGateID    uint16 = 1
+	outerGateID    uint16 = 2
+	unpairedGateID uint16 = 3
+	unknownGateID  uint16 = 99
+)
+
+type fixture struct {
+	registry    *Registry
+	devices     map[uint16]*db.Device
+	innerRoomID uint
+	outerRoomID uint
+}
+
+// setup creates a fresh database with a device pair between an inner and an outer room, and an unpaired device.
+func setup(t *testing.T) *fixture {
+	t.Helper()
+
+	if err := db.Init(filepath.Join(t.TempDir(), "rewired.db")); err != nil {
+		t.Fatalf("db.Init() error = %v", err)
+	}
+	// the device logs left in the buffer are inserted before the next test replaces the database
+	t.Cleanup(func() { devicelog.Get().Flush() })
+
+	settings.Get().AllowLegacyDevices = true
+	clockskew.Init(clockskew.DEFAULT_MAX_SKEW)
+	sequences = &sequenceTracker{windows: make(map[uint16]*sequenceWindow)}
+
+	f := &fixture{devices: make(map[uint16]*db.Device)}
+
+	err := db.Get().Transaction(func(tx *gorm.DB) error {
+		user := &db.User{Name: "owner"}
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+
+		for _, gateID := range []uint16{innerGateID, outerGateID, unpairedGateID} {
+			device := &db.Device{GateID: gateID}
+			if err := tx.Create(device).Error; err != nil {
+				return err
+			}
+			f.devices[gateID] = device
+		}
+
+		innerRoom := &db.Room{Name: "room", OwnerID: user.ID}
+		outerRoom := &db.Room{Name: "corridor", OwnerID: user.ID}
+		for _, room := range []*db.Room{innerRoom, outerRoom} {
+			if err := tx.Create(room).Error; err != nil {
+				return err
+			}
+			if err := tx.Create(&db.RoomPopulation{RoomID: room.ID}).Error; err != nil {
+				return err
+			}
+		}
+		f.innerRoomID = innerRoom.ID
+		f.outerRoomID = outerRoom.ID
+
+		return tx.Create(&db.Door{
+			OwnerID: user.ID,
+			Gates: []db.DoorGate{
+				{Position: 0, DeviceID: f.devices[outerGateID].ID},
+				{Position: 1, DeviceID: f.devices[innerGateID].ID},
+			},
+			Rooms: []db.DoorRoom{
+				{Position: 0, RoomID: outerRoom.ID},
+				{Position: 2, RoomID: innerRoom.ID},
+			},
+		}).Error
+	})
+	if err != nil {
+		t.Fatalf("failed to seed database: %v", err)
+	}
+
+	if err := gateconnection.Init(); err != nil {
+		t.Fatalf("gateconnection.Init() error = %v", err)
+	}
+
+	if err := doorpass.Init(doorpass.VERSION_1); err != nil {
+		t.Fatalf("doorpass.Init() error = %v", err)
+	}
+
+	bytesEgress := make(chan []byte)
+	go func() {
+		for range bytesEgress {
+		}
+	}()
+	t.Cleanup(func() { close(bytesEgress) })
+
+	f.registry = NewDefaultRegistry(bytesEgress)
+
+	return f
+}
+
+func (f *fixture) population(t *testing.T, roomID uint) uint32 {
+	t.Helper()
+
+	roomPopulation := &db.RoomPopulation{}
+	if err := db.Get().Where(&db.RoomPopulation{RoomID: roomID}).First(roomPopulation).Error; err != nil {
+		t.Fatalf("failed to find room population: %v", err)
+	}
+
+	return roomPopulation.Population
+}
+
+func (f *fixture) setPopulation(t *testing.T, roomID uint, population uint32) {
+	t.Helper()
+
+	result := db.Get().
+		Model(&db.RoomPopulation{}).
+		Where("room_id = ?", roomID).
+		Updates(map[string]any{"population": population, "net_population": population})
+	if result.Error != nil {
+		t.Fatalf("failed to set room population: %v", result.Error)
+	}
+}
+
+func (f *fixture) passEvents(t *testing.T) []db.PassEvent {
+	t.Helper()
+
+	passEvents := []db.PassEvent{}
+	if err := db.Get().Order("id").Find(&passEvents).Error; err != nil {
+		t.Fatalf("failed to find pass events: %v", err)
+	}
+
+	return passEvents
+}
+
+func (f *fixture) deviceLogs(t *testing.T, gateID uint16) []db.DeviceLog {
+	t.Helper()
+
+	if err := devicelog.Get().Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+
+	deviceLogs := []db.DeviceLog{}
+	if err := db.Get().Where(&db.DeviceLog{DeviceID: f.devices[gateID].ID}).Find(&deviceLogs).Error; err != nil {
+		t.Fatalf("failed to find device logs: %v", err)
+	}
+
+	return deviceLogs
+}
+
+func heartbeat(gateID uint16) *packet.RawPacket {
+	heartbeatPacket := &packet.HeartbeatPacket{
+		RawPacket: packet.RawPacket{Version: packet.VERSION_2, PacketType: packet.PacketTypeHeartbeat},
+	}
+	heartbeatPacket.SetGateID(gateID)
+	return &heartbeatPacket.RawPacket
+}
+
+func increment(gateID uint16, sequence *uint16) *packet.RawPacket {
+	incrementPacket := &packet.IncrementPacket{
+		RawPacket: packet.RawPacket{Version: packet.VERSION_2, PacketType: packet.PacketTypeIncrement},
+	}
+	incrementPacket.SetGateID(gateID)
+	if sequence != nil {
+		incrementPacket.SetSequence(*sequence)
+	}
+	return &incrementPacket.RawPacket
+}
+
+func decrement(gateID uint16) *packet.RawPacket {
+	decrementPacket := &packet.DecrementPacket{
+		RawPacket: packet.RawPacket{Version: packet.VERSION_1, PacketType: packet.PacketTypeDecrement},
+	}
+	decrementPacket.SetGateID(gateID)
+	return &decrementPacket.RawPacket
+}
+
+func gateStatus(gateID uint16, status packet.GateStatus) *packet.RawPacket {
+	gateStatusPacket := &packet.GateStatusPacket{
+		RawPacket: packet.RawPacket{Version: packet.VERSION_2, PacketType: packet.PacketTypeGateStatus},
+	}
+	gateStatusPacket.SetGateID(gateID)
+	gateStatusPacket.SetStatus(status)
+	gateStatusPacket.SetTimestamp(time.Unix(1716912942, 0))
+	return &gateStatusPacket.RawPacket
+}
+
+// preciseGateStatus is a version 3 unblocked status triggered at triggerTime and received at receivedAt.
+func preciseGateStatus(gateID uint16, triggerTime time.Time, receivedAt time.Time) *packet.RawPacket {
+	gateStatusPacket := &packet.GateStatusPacket{
+		RawPacket: packet.RawPacket{Version: packet.VERSION_3, PacketType: packet.PacketTypeGateStatus},
+	}
+	gateStatusPacket.SetGateID(gateID)
+	gateStatusPacket.SetStatus(packet.GateStatusUnblocked)
+	gateStatusPacket.SetTimestamp(triggerTime)
+	gateStatusPacket.ReceivedAt = receivedAt
+	return &gateStatusPacket.RawPacket
+}
+
+func ack(gateID uint16, packetType packet.PacketType, sequence uint16) *packet.RawPacket {
+	ackPacket := &packet.AckPacket{RawPacket: packet.RawPacket{Version: packet.VERSION_2, PacketType: packet.PacketTypeAck}}
+	ackPacket.SetGateID(gateID)
+	ackPacket.SetAckedPacketType(packetType)
+	ackPacket.SetSequence(sequence)
+	return &ackPacket.RawPacket
+}
+
+func sequence(s uint16) *uint16 {
+	return &s
+}
+
+type handlerTest struct {
+	name    string
+	setup   func(t *testing.T, f *fixture)
+	packets []*packet.RawPacket
+	wantErr bool
+	check   func(t *testing.T, f *fixture)
+}
+
+func runHandlerTests(t *testing.T, tests []handlerTest) {
+	t.Helper()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := setup(t)
+			if tt.setup != nil {
+				tt.setup(t, f)
+			}
+
+			var err error
+			for _, rawPacket := range tt.packets {
+				err = errors.Join(err, f.registry.Dispatch(rawPacket))
+			}
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Dispatch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.check != nil {
+				tt.check(t, f)
+			}
+		})
+	}
+}
+
+func TestHandleHeartbeat(t *testing.T) {
+	runHandlerTests(t, []handlerTest{
+		{
+			name:    "Known Gate",
+			packets: []*packet.RawPacket{heartbeat(innerGateID)},
+			check: func(t *testing.T, f *fixture) {
+				deviceLogs := f.deviceLogs(t, innerGateID)
+				if len(deviceLogs) != 1 || deviceLogs[0].LogType != 1 {
+					t.Errorf("device logs = %+v, expected 1 heartbeat log", deviceLogs)
+				}
+
+				device := &db.Device{}
+				db.Get().First(device, f.devices[innerGateID].ID)
+				if device.Status != 1 {
+					t.Errorf("device status = %d, expected 1", device.Status)
+				}
+			},
+		},
+		{
+			name:    "Unknown Gate",
+			packets: []*packet.RawPacket{heartbeat(unknownGateID)},
+			wantErr: true,
+		},
+	})
+}
+
+func TestHandleGateStatus(t *testing.T) {
+	// the clock of the devices is 800ms behind the server
+	deviceTime := time.Now().Add(-time.Minute)
+	skew := 800 * time.Millisecond
+	received := deviceTime.Add(skew + 2*time.Second)
+
+	runHandlerTests(t, []handlerTest{
+		{
+			name:    "Blocked",
+			packets: []*packet.RawPacket{gateStatus(outerGateID, packet.GateStatusBlocked)},
+			check: func(t *testing.T, f *fixture) {
+				deviceLogs := f.deviceLogs(t, outerGateID)
+				if len(deviceLogs) != 1 || deviceLogs[0].LogType != 2 || *deviceLogs[0].Status != 3 {
+					t.Errorf("device logs = %+v, expected 1 blocked status log", deviceLogs)
+				}
+
+				if !deviceLogs[0].TriggerTime.Equal(time.Unix(1716912942, 0)) {
+					t.Errorf("device log trigger time = %v, expected %v", deviceLogs[0].TriggerTime, time.Unix(1716912942, 0))
+				}
+			},
+		},
+		{
+			name:    "Unblocked On Unpaired Gate",
+			packets: []*packet.RawPacket{gateStatus(unpairedGateID, packet.GateStatusUnblocked)},
+			check: func(t *testing.T, f *fixture) {
+				deviceLogs := f.deviceLogs(t, unpairedGateID)
+				if len(deviceLogs) != 1 || *deviceLogs[0].Status != 2 {
+					t.Errorf("device logs = %+v, expected 1 unblocked status log", deviceLogs)
+				}
+			},
+		},
+		{
+			// the end of the walk in is held up on the network, which would look like a walk out by the receive times
+			name: "Passes On Trigger Time",
+			packets: []*packet.RawPacket{
+				preciseGateStatus(outerGateID, deviceTime, deviceTime.Add(skew)),
+				preciseGateStatus(innerGateID, deviceTime, deviceTime.Add(skew)),
+				preciseGateStatus(innerGateID, deviceTime.Add(300*time.Millisecond), received),
+				preciseGateStatus(outerGateID, deviceTime.Add(700*time.Millisecond), received.Add(400*time.Millisecond)),
+				preciseGateStatus(innerGateID, deviceTime.Add(1000*time.Millisecond), received.Add(700*time.Millisecond)),
+			},
+			check: func(t *testing.T, f *fixture) {
+				if got := f.population(t, f.innerRoomID); got != 1 {
+					t.Errorf("inner room population = %d, expected 1", got)
+				}
+
+				passEvents := f.passEvents(t)
+				if len(passEvents) != 1 {
+					t.Fatalf("pass events = %d, expected 1", len(passEvents))
+				}
+				// the pass is timed by the trigger times of the blocks, corrected by the clock skew of the gates
+				start, end := deviceTime.Add(skew), deviceTime.Add(skew+time.Second)
+				if !passEvents[0].StartTime.Equal(start) || !passEvents[0].EndTime.Equal(end) {
+					t.Errorf("pass event times = (%v, %v), expected (%v, %v)",
+						passEvents[0].StartTime,
+						passEvents[0].EndTime,
+						start,
+						end,
+					)
+				}
+			},
+		},
+		{
+			// the clock of the gates is an hour behind and stuck, so the receive times are used instead
+			name: "Falls Back On Skewed Clock",
+			packets: []*packet.RawPacket{
+				preciseGateStatus(outerGateID, deviceTime.Add(-time.Hour), received),
+				preciseGateStatus(innerGateID, deviceTime.Add(-time.Hour), received),
+				preciseGateStatus(innerGateID, deviceTime.Add(-time.Hour), received.Add(300*time.Millisecond)),
+				preciseGateStatus(outerGateID, deviceTime.Add(-time.Hour), received.Add(700*time.Millisecond)),
+				preciseGateStatus(innerGateID, deviceTime.Add(-time.Hour), received.Add(1000*time.Millisecond)),
+			},
+			check: func(t *testing.T, f *fixture) {
+				if got := f.population(t, f.innerRoomID); got != 1 {
+					t.Errorf("inner room population = %d, expected 1", got)
+				}
+			},
+		},
+		{
+			name:    "Unknown Gate",
+			packets: []*packet.RawPacket{gateStatus(unknownGateID, packet.GateStatusBlocked)},
+			wantErr: true,
+		},
+	})
+}
+
+func TestHandleIncrement(t *testing.T) {
+	// an increment of a gate that is not on a door, which cannot be counted, from a source that records its acks
+	uncounted := increment(unpairedGateID, sequence(7))
+	uncountedSource := &fakeSource{}
+	uncounted.Source = uncountedSource
+
+	runHandlerTests(t, []handlerTest{
+		{
+			name:    "Increments Inner Room",
+			packets: []*packet.RawPacket{increment(outerGateID, nil), increment(innerGateID, nil)},
+			check: func(t *testing.T, f *fixture) {
+				if population := f.population(t, f.innerRoomID); population != 2 {
+					t.Errorf("inner room population = %d, expected 2", population)
+				}
+
+				if population := f.population(t, f.outerRoomID); population != 0 {
+					t.Errorf("outer room population = %d, expected 0", population)
+				}
+
+				if deviceLogs := f.deviceLogs(t, innerGateID); len(deviceLogs) != 1 || deviceLogs[0].LogType != 3 {
+					t.Errorf("device logs = %+v, expected 1 increment log", deviceLogs)
+				}
+
+				passEvents := f.passEvents(t)
+				if len(passEvents) != 2 {
+					t.Fatalf("pass events = %d, expected 2", len(passEvents))
+				}
+				for _, passEvent := range passEvents {
+					if passEvent.Source != 2 || passEvent.Direction != 1 || passEvent.FromRoomID != nil ||
+						*passEvent.ToRoomID != f.innerRoomID {
+						t.Errorf("pass event = %+v, expected a manual pass into the inner room", passEvent)
+					}
+				}
+			},
+		},
+		{
+			name:    "Ignores Duplicate Sequence",
+			packets: []*packet.RawPacket{increment(innerGateID, sequence(5)), increment(innerGateID, sequence(5))},
+			check: func(t *testing.T, f *fixture) {
+				if population := f.population(t, f.innerRoomID); population != 1 {
+					t.Errorf("inner room population = %d, expected 1", population)
+				}
+			},
+		},
+		{
+			name:    "Counts Distinct Sequences",
+			packets: []*packet.RawPacket{increment(innerGateID, sequence(5)), increment(innerGateID, sequence(6))},
+			check: func(t *testing.T, f *fixture) {
+				if population := f.population(t, f.innerRoomID); population != 2 {
+					t.Errorf("inner room population = %d, expected 2", population)
+				}
+			},
+		},
+		{
+			// the device restarts its sequence after turning on
+			name: "Counts Sequences Again After Turn On",
+			packets: []*packet.RawPacket{
+				increment(innerGateID, sequence(0)),
+				gateStatus(innerGateID, packet.GateStatusTurnOn),
+				increment(innerGateID, sequence(0)),
+			},
+			check: func(t *testing.T, f *fixture) {
+				if population := f.population(t, f.innerRoomID); population != 2 {
+					t.Errorf("inner room population = %d, expected 2", population)
+				}
+			},
+		},
+		{
+			// the increment is neither acked nor recorded as seen, so the device retries it until it is counted
+			name:    "Fails When Not Counted",
+			packets: []*packet.RawPacket{uncounted},
+			wantErr: true,
+			check: func(t *testing.T, f *fixture) {
+				if len(uncountedSource.acks) != 0 {
+					t.Errorf("acks = %d, expected 0", len(uncountedSource.acks))
+				}
+				if sequences.isDuplicate(unpairedGateID, 7) {
+					t.Errorf("isDuplicate() = %v, expected %v", true, false)
+				}
+				if deviceLogs := f.deviceLogs(t, unpairedGateID); len(deviceLogs) != 0 {
+					t.Errorf("device logs = %+v, expected none", deviceLogs)
+				}
+			},
+		},
+		{
+			name:    "Unknown Gate",
+			packets: []*packet.RawPacket{increment(unknownGateID, nil)},
+			wantErr: true,
+		},
+	})
+}
+
+func TestHandleDecrement(t *testing.T) {
+	runHandlerTests(t, []handlerTest{
+		{
+			name: "Decrements Inner Room",
+			setup: func(t *testing.T, f *fixture) {
+				f.setPopulation(t, f.innerRoomID, 2)
+			},
+			packets: []*packet.RawPacket{decrement(innerGateID)},
+			check: func(t *testing.T, f *fixture) {
+				if population := f.population(t, f.innerRoomID); population != 1 {
+					t.Errorf("inner room population = %d, expected 1", population)
+				}
+
+				if deviceLogs := f.deviceLogs(t, innerGateID); len(deviceLogs) != 1 || deviceLogs[0].LogType != 4 {
+					t.Errorf("device logs = %+v, expected 1 decrement log", deviceLogs)
+				}
+			},
+		},
+		{
+			name:    "Does Not Go Below Zero",
+			packets: []*packet.RawPacket{decrement(innerGateID)},
+			check: func(t *testing.T, f *fixture) {
+				if population := f.population(t, f.innerRoomID); population != 0 {
+					t.Errorf("inner room population = %d, expected 0", population)
+				}
+			},
+		},
+		{
+			name:    "Tracks Net Population Below Zero",
+			packets: []*packet.RawPacket{decrement(innerGateID), decrement(innerGateID), increment(innerGateID, nil)},
+			check: func(t *testing.T, f *fixture) {
+				roomPopulation := &db.RoomPopulation{}
+				if err := db.Get().Where(&db.RoomPopulation{RoomID: f.innerRoomID}).First(roomPopulation).Error; err != nil {
+					t.Fatalf("failed to find room population: %v", err)
+				}
+				if roomPopulation.Population != 1 || roomPopulation.NetPopulation != -1 || roomPopulation.Drift() != 2 {
+					t.Errorf("room population = (%d, %d), expected (1, -1) with a drift of 2",
+						roomPopulation.Population,
+						roomPopulation.NetPopulation,
+					)
+				}
+
+				// the room is only alerted on once it goes below 0
+				alerts := []db.Alert{}
+				if err := db.Get().Find(&alerts).Error; err != nil {
+					t.Fatalf("failed to find alerts: %v", err)
+				}
+				if len(alerts) != 1 {
+					t.Fatalf("alerts = %+v, expected 1", alerts)
+				}
+				if alerts[0].AlertType != 1 || alerts[0].RoomID != f.innerRoomID || alerts[0].DoorID == nil ||
+					alerts[0].Population != -1 {
+					t.Errorf("alert = %+v, expected a negative population alert of the door", alerts[0])
+				}
+			},
+		},
+		{
+			name:    "Fails When Not Counted",
+			packets: []*packet.RawPacket{decrement(unpairedGateID)},
+			wantErr: true,
+			check: func(t *testing.T, f *fixture) {
+				if deviceLogs := f.deviceLogs(t, unpairedGateID); len(deviceLogs) != 0 {
+					t.Errorf("device logs = %+v, expected none", deviceLogs)
+				}
+			},
+		},
+		{
+			name:    "Unknown Gate",
+			packets: []*packet.RawPacket{decrement(unknownGateID)},
+			wantErr: true,
+		},
+	})
+}
+
+func TestHandleAck(t *testing.T) {
+	var command *db.DeviceCommand
+
+	runHandlerTests(t, []handlerTest{
+		{
+			name: "Acks Sent Command",
+			setup: func(t *testing.T, f *fixture) {
+				sentAt := time.Now()
+				command = &db.DeviceCommand{
+					DeviceID:    f.devices[innerGateID].ID,
+					CommandType: uint8(packet.PacketTypeIdentify),
+					Sequence:    3,
+					Status:      uint8(downlink.CommandStatusSent),
+					SentAt:      &sentAt,
+				}
+				if err := db.Get().Create(command).Error; err != nil {
+					t.Fatalf("failed to create command: %v", err)
+				}
+			},
+			packets: []*packet.RawPacket{ack(innerGateID, packet.PacketTypeIdentify, 3)},
+			check: func(t *testing.T, f *fixture) {
+				db.Get().First(command, command.ID)
+				if downlink.CommandStatus(command.Status) != downlink.CommandStatusAcked || command.AckedAt == nil {
+					t.Errorf("command status = %s, expected %s", downlink.CommandStatus(command.Status), downlink.CommandStatusAcked)
+				}
+			},
+		},
+		{
+			name:    "Unknown Gate",
+			packets: []*packet.RawPacket{ack(unknownGateID, packet.PacketTypeIdentify, 3)},
+			wantErr: true,
+		},
+	})
+}
+
+func TestDispatchUnhandledPacketType(t *testing.T) {
+	f := setup(t)
+
+	commandPacket := &packet.CommandPacket{
+		RawPacket: packet.RawPacket{Version: packet.VERSION_2, PacketType: packet.PacketTypeReboot},
+	}
+	commandPacket.SetGateID(innerGateID)
+
+	if err := f.registry.Dispatch(&commandPacket.RawPacket); !errors.Is(err, ErrUnhandledPacketType) {
+		t.Errorf("Dispatch() error = %v, expected %v", err, ErrUnhandledPacketType)
+	}
+}
+
+// The source of a fake connection, which records the acks written to it.
+type fakeSource struct {
+	acks []*packet.AckPacket
+}
+
+func (s *fakeSource) Authenticated(gateID uint16) {}
+
+func (s *fakeSource) WritePacket(p encoding.BinaryMarshaler) error {
+	s.acks = append(s.acks, p.(*packet.AckPacket))
+	return nil
+}
+
+func TestAcknowledge(t *testing.T) {
+	errHandler := errors.New("handler failed")
+
+	tests := []struct {
+		name string
+		// the sequence of the increments sent, and whether the handler fails each of them
+		sequences []*uint16
+		failures  []bool
+		handled   int
+		acks      []uint16
+	}{
+		{
+			name:      "Acks Handled",
+			sequences: []*uint16{sequence(1), sequence(2)},
+			failures:  []bool{false, false},
+			handled:   2,
+			acks:      []uint16{1, 2},
+		},
+		{
+			name:      "Does Not Ack Unsequenced",
+			sequences: []*uint16{nil},
+			failures:  []bool{false},
+			handled:   1,
+			acks:      []uint16{},
+		},
+		{
+			// the failed packet is not recorded as seen, so its retry is handled
+			name:      "Does Not Ack Failed",
+			sequences: []*uint16{sequence(1), sequence(1)},
+			failures:  []bool{true, false},
+			handled:   1,
+			acks:      []uint16{1},
+		},
+		{
+			// the ack of the first was lost, so the retry is acked again without being handled
+			name:      "Acks Duplicate",
+			sequences: []*uint16{sequence(1), sequence(1)},
+			failures:  []bool{false, false},
+			handled:   1,
+			acks:      []uint16{1, 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sequences = &sequenceTracker{windows: make(map[uint16]*sequenceWindow)}
+
+			handled := 0
+			fail := false
+
+			registry := NewRegistry()
+			registry.Use(Acknowledge, Deduplicate)
+			registry.Register(packet.PacketTypeIncrement, DecodeIncrement, HandlerFunc(func(p *Packet) error {
+				if fail {
+					return errHandler
+				}
+				handled++
+				return nil
+			}))
+
+			source := &fakeSource{}
+			for i, s := range tt.sequences {
+				fail = tt.failures[i]
+
+				rawPacket := increment(innerGateID, s)
+				rawPacket.Source = source
+
+				if err := registry.Dispatch(rawPacket); (err != nil) != fail {
+					t.Fatalf("Dispatch() error = %v, expected failure %v", err, fail)
+				}
+			}
+
+			if handled != tt.handled {
+				t.Errorf("handled = %d, expected %d", handled, tt.handled)
+			}
+
+			if len(source.acks) != len(tt.acks) {
+				t.Fatalf("acks = %d, expected %d", len(source.acks), len(tt.acks))
+			}
+			for i, ackPacket := range source.acks {
+				if ackPacket.Sequence != tt.acks[i] || ackPacket.AckedPacketType != packet.PacketTypeIncrement {
+					t.Errorf("ack %d = %v %d, expected %v %d",
+						i,
+						ackPacket.AckedPacketType,
+						ackPacket.Sequence,
+						packet.PacketTypeIncrement,
+						tt.acks[i],
+					)
+				}
+			}
+		})
+	}
+}
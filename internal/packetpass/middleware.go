@@ -0,0 +1,136 @@
+package packetpass
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"log/slog"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+	"github.com/kKar1503/rewired-server-2024/internal/packet"
+	"github.com/kKar1503/rewired-server-2024/internal/packetauth"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrDuplicate = errors.New("duplicate sequence number")
+	ErrRejected  = errors.New("packet rejected")
+)
+
+var (
+	packetsHandled = expvar.NewMap("packets_handled")
+	packetsFailed  = expvar.NewMap("packets_failed")
+)
+
+// Metrics counts the packets handled and failed by packet type, published with expvar.
+func Metrics(next Handler) Handler {
+	return HandlerFunc(func(p *Packet) error {
+		err := next.Handle(p)
+		if err != nil {
+			packetsFailed.Add(p.Raw.PacketType.String(), 1)
+		} else {
+			packetsHandled.Add(p.Raw.PacketType.String(), 1)
+		}
+		return err
+	})
+}
+
+// Authenticate rejects the packets that fail packetauth.Verify, and lets the source of the packets that pass know that it
+// is trusted as the connection of the gate.
+func Authenticate(next Handler) Handler {
+	return HandlerFunc(func(p *Packet) error {
+		if err := packetauth.Verify(p.Raw); err != nil {
+			slog.Warn("rejected packet", "error", err, "gateID", p.GateID, "packetType", p.Raw.PacketType)
+			return errors.Join(ErrRejected, err)
+		}
+		if p.Raw.Source != nil {
+			p.Raw.Source.Authenticated(p.GateID)
+		}
+		return next.Handle(p)
+	})
+}
+
+// Acknowledge writes the ack of the packets with a sequence number back to their source once they were handled, so the
+// device keeps retrying the packets that failed or were lost on the way.
+func Acknowledge(next Handler) Handler {
+	return HandlerFunc(func(p *Packet) error {
+		if err := next.Handle(p); err != nil {
+			return err
+		}
+
+		ackPacket, ok := packet.NewAckFor(p.Raw)
+		if !ok || p.Raw.Source == nil {
+			return nil
+		}
+
+		if err := p.Raw.Source.WritePacket(ackPacket); err != nil {
+			slog.Error("failed to write ack packet", "error", err, "gateID", ackPacket.GateID)
+		}
+
+		return nil
+	})
+}
+
+// Deduplicate ignores the increment and decrement packets with a sequence number that was recently handled for the
+// gate.
+func Deduplicate(next Handler) Handler {
+	return HandlerFunc(func(p *Packet) error {
+		var sequence *uint16
+		switch decoded := p.Decoded.(type) {
+		case *packet.IncrementPacket:
+			sequence = decoded.Sequence
+		case *packet.DecrementPacket:
+			sequence = decoded.Sequence
+		}
+
+		if sequence == nil {
+			return next.Handle(p)
+		}
+
+		if sequences.isDuplicate(p.GateID, *sequence) {
+			slog.Info("ignoring duplicate packet", "gateID", p.GateID, "packetType", p.Raw.PacketType, "sequence", *sequence)
+			return nil
+		}
+
+		if err := next.Handle(p); err != nil {
+			return err
+		}
+
+		// the sequence is only recorded once handled, so the retry of a packet that failed is not ignored; the packets of
+		// a gate are handled in order by the same worker, so no retry can be handled in between
+		sequences.record(p.GateID, *sequence)
+
+		return nil
+	})
+}
+
+// Debug passes the decoded packets as JSON to the bytesEgress for debugging.
+func Debug(bytesEgress chan<- []byte) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(p *Packet) error {
+			if jsonData, err := json.Marshal(p.Decoded); err == nil {
+				bytesEgress <- jsonData
+			}
+			return next.Handle(p)
+		})
+	}
+}
+
+// ResolveDevice finds the device the packet is from, failing the packet when the device is not registered.
+func ResolveDevice(next Handler) Handler {
+	return HandlerFunc(func(p *Packet) error {
+		device := &db.Device{}
+		result := db.Get().Where(&db.Device{GateID: p.GateID}).First(device)
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			slog.Error("failed to find the device", "error", result.Error, "gateID", p.GateID)
+			return packetauth.ErrUnknownDevice
+		}
+		if result.Error != nil {
+			slog.Error("failed to find the device", "error", result.Error, "gateID", p.GateID)
+			return result.Error
+		}
+
+		p.Device = device
+		return next.Handle(p)
+	})
+}
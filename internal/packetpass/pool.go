@@ -0,0 +1,204 @@
+package packetpass
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+
+	"github.com/kKar1503/rewired-server-2024/internal/deadletter"
+	"github.com/kKar1503/rewired-server-2024/internal/packet"
+	"github.com/kKar1503/rewired-server-2024/internal/packetauth"
+)
+
+var ErrInvalidQueuePolicy = errors.New("invalid queue policy")
+
+// The policy of the pool when the queue of a worker is full.
+type QueuePolicy uint8
+
+const (
+	// Block waits for the worker to make space in its queue, applying back pressure to the readers.
+	QueuePolicyBlock QueuePolicy = iota + 1
+	// Drop drops the packet, so a slow worker does not stall the readers of the other gates.
+	QueuePolicyDrop
+)
+
+func ParseQueuePolicy(s string) (QueuePolicy, error) {
+	switch s {
+	case "block":
+		return QueuePolicyBlock, nil
+	case "drop":
+		return QueuePolicyDrop, nil
+	default:
+		return 0, ErrInvalidQueuePolicy
+	}
+}
+
+var packetsDropped = expvar.NewInt("packets_dropped")
+
+// the pool that is currently running, for its queue lengths to be published with expvar
+var runningPool atomic.Pointer[Pool]
+
+func init() {
+	expvar.Publish("packet_queue_lengths", expvar.Func(func() any {
+		pool := runningPool.Load()
+		if pool == nil {
+			return []int{}
+		}
+		return pool.QueueLengths()
+	}))
+}
+
+// The pool of workers that handle the packets in parallel.
+//
+// The packets are partitioned by the partition key of their gate ID into the queues of the workers, so the packets
+// with the same key are always handled in order by the same worker, while packets with different keys are handled in
+// parallel.
+type Pool struct {
+	registry *Registry
+	queues   []chan *packet.RawPacket
+	policy   QueuePolicy
+	// PartitionKey maps the gate ID to the key the packets are partitioned by, which is the gate ID itself when nil.
+	PartitionKey func(gateID uint16) uint16
+}
+
+func NewPool(registry *Registry, workers, queueDepth int, policy QueuePolicy) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+
+	queues := make([]chan *packet.RawPacket, workers)
+	for i := range queues {
+		queues[i] = make(chan *packet.RawPacket, queueDepth)
+	}
+
+	return &Pool{
+		registry: registry,
+		queues:   queues,
+		policy:   policy,
+	}
+}
+
+// Run partitions the packets from the packetIngress into the workers until the context is done, after which the
+// packets that are already queued are handled before returning.
+func (p *Pool) Run(ctx context.Context, packetIngress <-chan *packet.RawPacket) {
+	runningPool.Store(p)
+	defer runningPool.CompareAndSwap(p, nil)
+
+	wg := &sync.WaitGroup{}
+	for i, queue := range p.queues {
+		wg.Add(1)
+		go func(worker int, queue <-chan *packet.RawPacket) {
+			defer wg.Done()
+			p.work(worker, queue)
+		}(i, queue)
+	}
+
+	defer func() {
+		for _, queue := range p.queues {
+			close(queue)
+		}
+		wg.Wait()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("exiting go func egress")
+			return
+		case rawPacket := <-packetIngress:
+			p.enqueue(ctx, rawPacket)
+		}
+	}
+}
+
+func (p *Pool) QueueLengths() []int {
+	lengths := make([]int, len(p.queues))
+	for i, queue := range p.queues {
+		lengths[i] = len(queue)
+	}
+	return lengths
+}
+
+func (p *Pool) enqueue(ctx context.Context, rawPacket *packet.RawPacket) {
+	// packets without a gate ID fail to be handled regardless, so they can go to any worker
+	gateID, _ := rawPacket.GateID()
+	key := gateID
+	if p.PartitionKey != nil {
+		key = p.PartitionKey(gateID)
+	}
+	queue := p.queues[int(key)%len(p.queues)]
+
+	// the sequenced packets are presses the devices wait to be acked for, which would only be retried into the full queue
+	// when dropped, so they always wait for space
+	_, sequenced := packet.NewAckFor(rawPacket)
+
+	if p.policy == QueuePolicyDrop && !sequenced {
+		select {
+		case queue <- rawPacket:
+		default:
+			packetsDropped.Add(1)
+			slog.Warn("dropped packet on full queue", "gateID", gateID, "packetType", rawPacket.PacketType)
+		}
+		return
+	}
+
+	// the packet is queued when there is space even if the context is done, so the packets received right before the
+	// shutdown are still handled
+	select {
+	case queue <- rawPacket:
+		return
+	default:
+	}
+
+	select {
+	case queue <- rawPacket:
+	case <-ctx.Done():
+		packetsDropped.Add(1)
+		slog.Warn("dropped packet on shutdown", "gateID", gateID, "packetType", rawPacket.PacketType)
+	}
+}
+
+func (p *Pool) work(worker int, queue <-chan *packet.RawPacket) {
+	for rawPacket := range queue {
+		err := p.registry.Dispatch(rawPacket)
+		if err != nil {
+			deadLetter(rawPacket, err)
+		}
+		if err != nil && !errors.Is(err, ErrRejected) {
+			gateID, _ := rawPacket.GateID()
+			slog.Error("failed to handle packet",
+				"error",
+				err,
+				"gateID",
+				gateID,
+				"packetType",
+				rawPacket.PacketType,
+				"worker",
+				worker,
+			)
+		}
+	}
+}
+
+// deadLetter stores the packet that failed to be handled when it could be handled after a fix on the server, such as
+// registering the device it is from.
+func deadLetter(rawPacket *packet.RawPacket, err error) {
+	var reason deadletter.Reason
+	switch {
+	case errors.Is(err, ErrUndecodable):
+		reason = deadletter.ReasonUndecodable
+	case errors.Is(err, ErrUnhandledPacketType):
+		reason = deadletter.ReasonUnhandled
+	case errors.Is(err, packetauth.ErrUnknownDevice):
+		reason = deadletter.ReasonUnknownDevice
+	default:
+		return
+	}
+
+	if err := deadletter.Store(rawPacket, reason, err); err != nil {
+		slog.Error("failed to store the dead letter", "error", err, "reason", reason)
+	}
+}
@@ -0,0 +1,267 @@
+package packetpass
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+	"github.com/kKar1503/rewired-server-2024/internal/deadletter"
+	"github.com/kKar1503/rewired-server-2024/internal/packet"
+	"github.com/kKar1503/rewired-server-2024/internal/packetauth"
+)
+
+func TestPoolKeepsOrderPerGate(t *testing.T) {
+	const (
+		gates          = 8
+		packetsPerGate = 100
+		workers        = 3
+		queueDepth     = 4
+	)
+
+	mu := sync.Mutex{}
+	handled := map[uint16][]uint16{}
+
+	registry := NewRegistry()
+	registry.Register(packet.PacketTypeIncrement, DecodeIncrement, HandlerFunc(func(p *Packet) error {
+		incrementPacket := p.Decoded.(*packet.IncrementPacket)
+
+		mu.Lock()
+		defer mu.Unlock()
+		handled[p.GateID] = append(handled[p.GateID], *incrementPacket.Sequence)
+		return nil
+	}))
+
+	ingress := make(chan *packet.RawPacket)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		NewPool(registry, workers, queueDepth, QueuePolicyBlock).Run(ctx, ingress)
+	}()
+
+	for s := uint16(0); s < packetsPerGate; s++ {
+		for gateID := uint16(1); gateID <= gates; gateID++ {
+			ingress <- increment(gateID, sequence(s))
+		}
+	}
+	cancel()
+	<-done
+
+	for gateID := uint16(1); gateID <= gates; gateID++ {
+		sequences := handled[gateID]
+		if len(sequences) != packetsPerGate {
+			t.Errorf("gate %d handled %d packets, expected %d", gateID, len(sequences), packetsPerGate)
+			continue
+		}
+		for i, s := range sequences {
+			if s != uint16(i) {
+				t.Errorf("gate %d handled sequence %d at %d, expected in order", gateID, s, i)
+				break
+			}
+		}
+	}
+}
+
+func TestPoolDeadLettersAndRedrives(t *testing.T) {
+	f := setup(t)
+
+	ingress := make(chan *packet.RawPacket)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		NewPool(f.registry, 2, 4, QueuePolicyBlock).Run(ctx, ingress)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	waitFor := func(t *testing.T, condition func() bool) {
+		t.Helper()
+
+		deadline := time.Now().Add(5 * time.Second)
+		for !condition() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for the packet to be handled")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	rawPacket := heartbeat(unknownGateID)
+	rawPacket.RemoteAddr = "127.0.0.1:5000"
+	ingress <- rawPacket
+
+	deadLetter := &db.DeadLetter{}
+	waitFor(t, func() bool {
+		return db.Get().First(deadLetter).Error == nil
+	})
+
+	if deadLetter.Reason != uint8(deadletter.ReasonUnknownDevice) {
+		t.Errorf("dead letter reason = %v, expected %v", deadLetter.Reason, deadletter.ReasonUnknownDevice)
+	}
+	if deadLetter.GateID == nil || *deadLetter.GateID != unknownGateID {
+		t.Errorf("dead letter gateID = %v, expected %d", deadLetter.GateID, unknownGateID)
+	}
+	if deadLetter.RemoteAddr != "127.0.0.1:5000" {
+		t.Errorf("dead letter remoteAddr = %q, expected %q", deadLetter.RemoteAddr, "127.0.0.1:5000")
+	}
+
+	// registering the device lets the redriven packet be handled
+	device := &db.Device{GateID: unknownGateID}
+	if err := db.Get().Create(device).Error; err != nil {
+		t.Fatalf("failed to create device: %v", err)
+	}
+	f.devices[unknownGateID] = device
+
+	if err := deadletter.Redrive(ctx, deadLetter, ingress); err != nil {
+		t.Fatalf("Redrive() error = %v", err)
+	}
+
+	waitFor(t, func() bool {
+		return len(f.deviceLogs(t, unknownGateID)) == 1
+	})
+
+	if err := deadletter.Redrive(ctx, deadLetter, ingress); !errors.Is(err, deadletter.ErrAlreadyRedriven) {
+		t.Errorf("second Redrive() error = %v, expected %v", err, deadletter.ErrAlreadyRedriven)
+	}
+}
+
+func TestPoolDropPolicyKeepsSequenced(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+
+	mu := sync.Mutex{}
+	handled := []string{}
+
+	registry := NewRegistry()
+	registry.Register(packet.PacketTypeIncrement, DecodeIncrement, HandlerFunc(func(p *Packet) error {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+
+		incrementPacket := p.Decoded.(*packet.IncrementPacket)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if incrementPacket.Sequence == nil {
+			handled = append(handled, "unsequenced")
+		} else {
+			handled = append(handled, "sequenced")
+		}
+		return nil
+	}))
+
+	ingress := make(chan *packet.RawPacket)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		NewPool(registry, 1, 1, QueuePolicyDrop).Run(ctx, ingress)
+	}()
+
+	dropped := packetsDropped.Value()
+
+	// the first packet keeps the worker busy, and the second fills up its queue
+	ingress <- increment(1, nil)
+	<-started
+	ingress <- increment(1, nil)
+
+	ingress <- increment(1, nil)
+	ingress <- increment(1, sequence(1))
+
+	close(release)
+
+	// the pool is only stopped once the sequenced packet is handled, as it would be dropped on shutdown otherwise
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		count := len(handled)
+		mu.Unlock()
+
+		if count == 3 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	<-done
+
+	if got := packetsDropped.Value() - dropped; got != 1 {
+		t.Errorf("dropped = %d, expected 1", got)
+	}
+
+	expected := []string{"unsequenced", "unsequenced", "sequenced"}
+	if len(handled) != len(expected) {
+		t.Fatalf("handled = %v, expected %v", handled, expected)
+	}
+	for i := range expected {
+		if handled[i] != expected[i] {
+			t.Errorf("handled = %v, expected %v", handled, expected)
+			break
+		}
+	}
+}
+
+func TestPoolRedrivenGateStatusSkipsDoorPass(t *testing.T) {
+	f := setup(t)
+
+	ingress := make(chan *packet.RawPacket)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		NewPool(f.registry, 1, 4, QueuePolicyBlock).Run(ctx, ingress)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// the statuses of a walk in, dead lettered an hour ago, which would be a pass if they were handled live
+	deviceTime := time.Now().Add(-time.Hour)
+	rawPackets := []*packet.RawPacket{
+		preciseGateStatus(outerGateID, deviceTime, deviceTime),
+		preciseGateStatus(innerGateID, deviceTime, deviceTime),
+		preciseGateStatus(innerGateID, deviceTime.Add(300*time.Millisecond), deviceTime.Add(300*time.Millisecond)),
+		preciseGateStatus(outerGateID, deviceTime.Add(700*time.Millisecond), deviceTime.Add(700*time.Millisecond)),
+		preciseGateStatus(innerGateID, deviceTime.Add(1000*time.Millisecond), deviceTime.Add(1000*time.Millisecond)),
+	}
+	for _, rawPacket := range rawPackets {
+		if err := deadletter.Store(rawPacket, deadletter.ReasonUnknownDevice, packetauth.ErrUnknownDevice); err != nil {
+			t.Fatalf("Store() error = %v", err)
+		}
+	}
+
+	deadLetters := []db.DeadLetter{}
+	if err := db.Get().Order("id").Find(&deadLetters).Error; err != nil {
+		t.Fatalf("failed to find dead letters: %v", err)
+	}
+	for i := range deadLetters {
+		if err := deadletter.Redrive(ctx, &deadLetters[i], ingress); err != nil {
+			t.Fatalf("Redrive() error = %v", err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(f.deviceLogs(t, innerGateID))+len(f.deviceLogs(t, outerGateID)) < len(rawPackets) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the packets to be handled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if passEvents := f.passEvents(t); len(passEvents) != 0 {
+		t.Errorf("pass events = %+v, expected none", passEvents)
+	}
+	if got := f.population(t, f.innerRoomID); got != 0 {
+		t.Errorf("inner room population = %d, expected 0", got)
+	}
+}
@@ -0,0 +1,74 @@
+package packetpass
+
+import (
+	"sync"
+)
+
+// The number of most recent sequence numbers remembered per gate to detect retried duplicates.
+//
+// A window is used instead of only comparing against the latest sequence number, so the retries of packets that arrive
+// out of order are still detected. A device that reboots restarts its sequence from 0, which would be mistaken as
+// retries of the sequences in the window, so the window of the gate is cleared when it reports that it turned on.
+//
+// The windows are only kept in memory, so a retry of a packet handled before the server restarted is handled again.
+const SEQUENCE_WINDOW = 32
+
+type sequenceWindow struct {
+	seen  [SEQUENCE_WINDOW]uint16
+	count int
+	next  int
+}
+
+type sequenceTracker struct {
+	sync.Mutex
+	windows map[uint16]*sequenceWindow
+}
+
+var sequences = &sequenceTracker{
+	windows: make(map[uint16]*sequenceWindow),
+}
+
+// isDuplicate reports whether the sequence number was recently recorded for the gate.
+func (t *sequenceTracker) isDuplicate(gateID uint16, sequence uint16) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	window, ok := t.windows[gateID]
+	if !ok {
+		return false
+	}
+
+	for i := 0; i < window.count; i++ {
+		if window.seen[i] == sequence {
+			return true
+		}
+	}
+
+	return false
+}
+
+// record records the sequence number as handled for the gate, forgetting the oldest one once the window is full.
+func (t *sequenceTracker) record(gateID uint16, sequence uint16) {
+	t.Lock()
+	defer t.Unlock()
+
+	window, ok := t.windows[gateID]
+	if !ok {
+		window = &sequenceWindow{}
+		t.windows[gateID] = window
+	}
+
+	window.seen[window.next] = sequence
+	window.next = (window.next + 1) % SEQUENCE_WINDOW
+	if window.count < SEQUENCE_WINDOW {
+		window.count++
+	}
+}
+
+// reset forgets the sequence numbers of the gate.
+func (t *sequenceTracker) reset(gateID uint16) {
+	t.Lock()
+	defer t.Unlock()
+
+	delete(t.windows, gateID)
+}
@@ -8,11 +8,13 @@ import (
 
 	"github.com/kKar1503/rewired-server-2024/internal/db"
 	"github.com/kKar1503/rewired-server-2024/internal/ws"
+	"github.com/kKar1503/rewired-server-2024/internal/zone"
 )
 
 type ServerStatus struct {
 	Devices []DeviceStatus `json:"devices"`
 	Rooms   []RoomStatus   `json:"rooms"`
+	Zones   []ZoneStatus   `json:"zones"`
 }
 
 type DeviceStatus struct {
@@ -21,8 +23,38 @@ type DeviceStatus struct {
 }
 
 type RoomStatus struct {
+	ID         uint   `json:"id"`
+	ZoneID     *uint  `json:"zoneId"` // nil when the room is not in a zone
 	Name       string `json:"name"`
 	Population uint32 `json:"population"`
+	// the net of the passes into and out of the room, which is below the population when the room was miscounted
+	NetPopulation int32  `json:"netPopulation"`
+	Capacity      uint32 `json:"capacity"` // 0 when the room has no capacity
+	// the population as a percentage of the capacity, nil when the room has no capacity
+	OccupancyPercent *float64 `json:"occupancyPercent"`
+}
+
+// ZoneStatus is the population of a building or a floor, which is the total of the rooms in it.
+type ZoneStatus struct {
+	ID            uint   `json:"id"`
+	ParentID      *uint  `json:"parentId"` // the building of a floor; nil for a building
+	Name          string `json:"name"`
+	Type          string `json:"type"`
+	Population    uint32 `json:"population"`
+	NetPopulation int32  `json:"netPopulation"`
+	Capacity      uint32 `json:"capacity"` // the total of the capacities of the rooms that have a capacity
+}
+
+// the signal of the status changing outside of the packets, buffered so that the signals made while the status is
+// being sent are merged into one
+var statusChanged = make(chan struct{}, 1)
+
+// StatusChanged makes the ServerStatusPasser send the status to the clients immediately, rather than on its next tick.
+func StatusChanged() {
+	select {
+	case statusChanged <- struct{}{}:
+	default:
+	}
 }
 
 func ServerStatusPasser(ctx context.Context, bytesEgress chan<- []byte) {
@@ -34,52 +66,83 @@ func ServerStatusPasser(ctx context.Context, bytesEgress chan<- []byte) {
 			slog.Info("exiting go func egress")
 			return
 		case <-ticker.C:
-			if !ws.HasClients() {
-				break
-			}
-			serverStatus := &ServerStatus{}
-
-			devicePairs := []db.DevicePair{}
-			result := db.Get().Where(&db.DevicePair{OwnerID: 1}).Joins("InnerGate").Joins("OuterGate").Find(&devicePairs)
-			if result.Error != nil {
-				slog.Error("failed to retrieve device pair for user", "error", result.Error, "userID", 1)
-				continue
-			}
-
-			for _, devicePair := range devicePairs {
-				serverStatus.Devices = append(serverStatus.Devices, []DeviceStatus{
-					{
-						ID:     devicePair.InnerGate.GateID,
-						Status: devicePair.InnerGate.Status,
-					},
-					{
-						ID:     devicePair.OuterGate.GateID,
-						Status: devicePair.OuterGate.Status,
-					},
-				}...)
-			}
-
-			rooms := []db.Room{}
-			result = db.Get().Where(&db.Room{OwnerID: 1}).Joins("RoomPopulation").Find(&rooms)
-			if result.Error != nil {
-				slog.Error("failed to retrieve rooms for user", "error", result.Error, "userID", 1)
-				continue
-			}
-
-			for _, room := range rooms {
-				serverStatus.Rooms = append(serverStatus.Rooms, RoomStatus{
-					Name:       room.Name,
-					Population: room.RoomPopulation.Population,
-				})
-			}
-
-			data, err := json.Marshal(serverStatus)
-			if err != nil {
-				slog.Error("failed to retrieve rooms for user", "error", err, "userID", 1)
-				continue
-			}
-
-			bytesEgress <- data
+			passServerStatus(bytesEgress)
+		case <-statusChanged:
+			passServerStatus(bytesEgress)
+		}
+	}
+}
+
+func passServerStatus(bytesEgress chan<- []byte) {
+	if !ws.HasClients() {
+		return
+	}
+	serverStatus := &ServerStatus{}
+
+	doors := []db.Door{}
+	result := db.Get().Where(&db.Door{OwnerID: 1}).Scopes(db.PreloadDoor).Find(&doors)
+	if result.Error != nil {
+		slog.Error("failed to retrieve doors for user", "error", result.Error, "userID", 1)
+		return
+	}
+
+	for _, door := range doors {
+		for _, gate := range door.Gates {
+			serverStatus.Devices = append(serverStatus.Devices, DeviceStatus{
+				ID:     gate.Device.GateID,
+				Status: gate.Device.Status,
+			})
 		}
 	}
+
+	rooms := []db.Room{}
+	result = db.Get().Where(&db.Room{OwnerID: 1}).Joins("RoomPopulation").Find(&rooms)
+	if result.Error != nil {
+		slog.Error("failed to retrieve rooms for user", "error", result.Error, "userID", 1)
+		return
+	}
+
+	for _, room := range rooms {
+		roomStatus := RoomStatus{
+			ID:            room.ID,
+			ZoneID:        room.ZoneID,
+			Name:          room.Name,
+			Population:    room.RoomPopulation.Population,
+			NetPopulation: room.RoomPopulation.NetPopulation,
+			Capacity:      room.Capacity,
+		}
+		if occupancyPercent, ok := room.OccupancyPercent(); ok {
+			roomStatus.OccupancyPercent = &occupancyPercent
+		}
+		serverStatus.Rooms = append(serverStatus.Rooms, roomStatus)
+	}
+
+	zones := []db.Zone{}
+	result = db.Get().Where(&db.Zone{OwnerID: 1}).Order("id").Find(&zones)
+	if result.Error != nil {
+		slog.Error("failed to retrieve zones for user", "error", result.Error, "userID", 1)
+		return
+	}
+
+	populations := zone.Aggregate(zones, rooms)
+	for _, z := range zones {
+		population := populations[z.ID]
+		serverStatus.Zones = append(serverStatus.Zones, ZoneStatus{
+			ID:            z.ID,
+			ParentID:      z.ParentID,
+			Name:          z.Name,
+			Type:          zone.Type(z.ZoneType).String(),
+			Population:    population.Population,
+			NetPopulation: population.NetPopulation,
+			Capacity:      population.Capacity,
+		})
+	}
+
+	data, err := json.Marshal(serverStatus)
+	if err != nil {
+		slog.Error("failed to retrieve rooms for user", "error", err, "userID", 1)
+		return
+	}
+
+	bytesEgress <- data
 }
@@ -0,0 +1,111 @@
+package population
+
+import (
+	"github.com/kKar1503/rewired-server-2024/internal/alert"
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+	"gorm.io/gorm"
+)
+
+// HYSTERESIS_PERCENT is the hysteresis of the capacity levels of a room as a percentage of its capacity, or of its
+// warning threshold when it has no capacity, and at least 1 person, so a room that is passed in and out of at a threshold
+// is only alerted on once.
+const HYSTERESIS_PERCENT = 10
+
+// The capacity levels of db.RoomPopulation.
+const (
+	levelNormal uint8 = iota
+	levelWarning
+	levelOverCapacity
+)
+
+var levelAlerts = map[uint8]alert.Type{
+	levelWarning:      alert.TypeCapacityWarning,
+	levelOverCapacity: alert.TypeOverCapacity,
+}
+
+// SetCapacity replaces the capacity and the warning threshold of the room, where 0 removes them, and raises an alert when
+// the room is already over them, in a single transaction.
+func SetCapacity(roomID uint, capacity uint32, warningThreshold uint32) error {
+	return db.Get().Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&db.Room{}).
+			Where("id = ?", roomID).
+			Updates(map[string]any{"capacity": capacity, "warning_threshold": warningThreshold})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		room, err := findRoom(tx, roomID)
+		if err != nil {
+			return err
+		}
+
+		return checkCapacity(tx, room, nil)
+	})
+}
+
+// checkCapacity updates the capacity level of the room, which must have its RoomPopulation loaded, from its population,
+// raising an alert when the level rises from the pass event, which is nil when the population was not changed by a pass.
+func checkCapacity(tx *gorm.DB, room *db.Room, event *db.PassEvent) error {
+	roomPopulation := &room.RoomPopulation
+	oldLevel := roomPopulation.CapacityLevel
+	level := capacityLevel(room, roomPopulation.Population, oldLevel)
+	if level == oldLevel {
+		return nil
+	}
+
+	result := tx.Model(roomPopulation).Update("capacity_level", level)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if level < oldLevel {
+		return nil
+	}
+
+	capacityAlert := &db.Alert{
+		AlertType:  uint8(levelAlerts[level]),
+		RoomID:     room.ID,
+		Population: int32(roomPopulation.Population),
+	}
+	if event != nil {
+		capacityAlert.DoorID = &event.DoorID
+		capacityAlert.PassEventID = &event.ID
+	}
+
+	return alert.Raise(tx, capacityAlert)
+}
+
+// capacityLevel returns the capacity level of the room at the population, from the level it was at, where the level is
+// only lowered once the population is below the threshold of the level by more than the hysteresis.
+func capacityLevel(room *db.Room, population uint32, level uint8) uint8 {
+	hysteresis := max(max(room.Capacity, room.WarningThreshold)*HYSTERESIS_PERCENT/100, 1)
+
+	for l := levelOverCapacity; l > levelNormal; l-- {
+		threshold, ok := levelThreshold(room, l)
+		if !ok {
+			continue
+		}
+
+		if population >= threshold || (l <= level && population+hysteresis >= threshold) {
+			return l
+		}
+	}
+
+	return levelNormal
+}
+
+// levelThreshold returns the population the room is at the capacity level from, and whether the room has the level.
+func levelThreshold(room *db.Room, level uint8) (uint32, bool) {
+	switch level {
+	case levelWarning:
+		return room.WarningThreshold, room.WarningThreshold > 0
+	case levelOverCapacity:
+		return room.Capacity + 1, room.Capacity > 0
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,107 @@
+package population
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/kKar1503/rewired-server-2024/internal/alert"
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+)
+
+func TestCapacityLevel(t *testing.T) {
+	// the hysteresis of the room is 2
+	room := &db.Room{Capacity: 20, WarningThreshold: 16}
+
+	tests := []struct {
+		name       string
+		room       *db.Room
+		population uint32
+		level      uint8
+		expected   uint8
+	}{
+		{"Under The Warning Threshold", room, 15, levelNormal, levelNormal},
+		{"At The Warning Threshold", room, 16, levelNormal, levelWarning},
+		{"At The Capacity", room, 20, levelWarning, levelWarning},
+		{"Over The Capacity", room, 21, levelWarning, levelOverCapacity},
+		{"Straight Over The Capacity", room, 25, levelNormal, levelOverCapacity},
+		{"Within The Hysteresis Of The Capacity", room, 19, levelOverCapacity, levelOverCapacity},
+		{"Below The Hysteresis Of The Capacity", room, 18, levelOverCapacity, levelWarning},
+		{"Within The Hysteresis Of The Warning Threshold", room, 14, levelWarning, levelWarning},
+		{"Below The Hysteresis Of The Warning Threshold", room, 13, levelWarning, levelNormal},
+		{"Below Both Hysteresis", room, 10, levelOverCapacity, levelNormal},
+		{"Without A Warning Threshold", &db.Room{Capacity: 20}, 20, levelNormal, levelNormal},
+		{"Over Capacity Without A Warning Threshold", &db.Room{Capacity: 20}, 21, levelNormal, levelOverCapacity},
+		{"Without A Capacity", &db.Room{WarningThreshold: 10}, 30, levelNormal, levelWarning},
+		{"Capacity Removed", &db.Room{}, 30, levelOverCapacity, levelNormal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if level := capacityLevel(tt.room, tt.population, tt.level); level != tt.expected {
+				t.Errorf("capacityLevel() = %d, expected %d", level, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCapacityAlerts(t *testing.T) {
+	if err := db.Init(filepath.Join(t.TempDir(), "rewired.db")); err != nil {
+		t.Fatalf("db.Init() error = %v", err)
+	}
+
+	room := &db.Room{Name: "room", Capacity: 3, WarningThreshold: 2}
+	if err := db.Get().Create(room).Error; err != nil {
+		t.Fatalf("failed to create room: %v", err)
+	}
+	if err := db.Get().Create(&db.RoomPopulation{RoomID: room.ID}).Error; err != nil {
+		t.Fatalf("failed to create room population: %v", err)
+	}
+
+	// the population goes 1, 2, 1, 2, 3, 4, 3, 4, where the room is warned on at the first 2 and over capacity at the
+	// first 4, and the passes in and out at the thresholds are within the hysteresis
+	for _, in := range []bool{true, true, false, true, true, true, false, true} {
+		event := &db.PassEvent{DoorID: 1, Direction: 2, FromRoomID: &room.ID}
+		if in {
+			event = &db.PassEvent{DoorID: 1, Direction: 1, ToRoomID: &room.ID}
+		}
+		if err := Pass(event); err != nil {
+			t.Fatalf("Pass() error = %v", err)
+		}
+	}
+
+	alerts := []db.Alert{}
+	if err := db.Get().Order("id").Find(&alerts).Error; err != nil {
+		t.Fatalf("failed to find alerts: %v", err)
+	}
+
+	expected := []struct {
+		alertType  alert.Type
+		population int32
+	}{
+		{alert.TypeCapacityWarning, 2},
+		{alert.TypeOverCapacity, 4},
+	}
+	if len(alerts) != len(expected) {
+		t.Fatalf("alerts = %+v, expected %+v", alerts, expected)
+	}
+	for i, a := range alerts {
+		if alert.Type(a.AlertType) != expected[i].alertType || a.Population != expected[i].population ||
+			a.PassEventID == nil {
+			t.Errorf("alerts[%d] = %+v, expected %+v of a pass event", i, a, expected[i])
+		}
+	}
+
+	t.Run("Correction Below The Hysteresis", func(t *testing.T) {
+		if err := Correct(&db.PopulationCorrection{RoomID: room.ID, Source: 2, NewPopulation: 0}); err != nil {
+			t.Fatalf("Correct() error = %v", err)
+		}
+
+		roomPopulation := &db.RoomPopulation{}
+		if err := db.Get().Where(&db.RoomPopulation{RoomID: room.ID}).First(roomPopulation).Error; err != nil {
+			t.Fatalf("failed to find room population: %v", err)
+		}
+		if roomPopulation.CapacityLevel != levelNormal {
+			t.Errorf("CapacityLevel = %d, expected %d", roomPopulation.CapacityLevel, levelNormal)
+		}
+	})
+}
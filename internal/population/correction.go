@@ -0,0 +1,91 @@
+package population
+
+import (
+	"log/slog"
+	"math"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+	"gorm.io/gorm"
+)
+
+// Correct replaces the population and the net population of the room of the correction with its new population, and
+// records the correction with the populations it replaced, in a single transaction. A skipped correction is only
+// recorded.
+func Correct(correction *db.PopulationCorrection) error {
+	return db.Get().Transaction(func(tx *gorm.DB) error {
+		return CorrectTx(tx, correction)
+	})
+}
+
+// CorrectTx is Correct within the transaction, so the correction is made together with the other changes of the caller.
+func CorrectTx(tx *gorm.DB, correction *db.PopulationCorrection) error {
+	newPopulation := correction.NewPopulation
+	return correct(tx, correction, func(*db.RoomPopulation) (uint32, int32) {
+		return newPopulation, int32(min(newPopulation, math.MaxInt32))
+	})
+}
+
+// Adjust changes the population and the net population of the room of the correction by delta, where the population is
+// clamped at 0 as by a pass, and records the correction like Correct. The adjusted populations are computed in the
+// transaction, so the passes made while adjusting are not lost.
+func Adjust(correction *db.PopulationCorrection, delta int64) error {
+	return db.Get().Transaction(func(tx *gorm.DB) error {
+		return correct(tx, correction, func(roomPopulation *db.RoomPopulation) (uint32, int32) {
+			population := min(max(int64(roomPopulation.Population)+delta, 0), math.MaxUint32)
+			netPopulation := min(max(int64(roomPopulation.NetPopulation)+delta, math.MinInt32), math.MaxInt32)
+			return uint32(population), int32(netPopulation)
+		})
+	})
+}
+
+// correct records the correction and replaces the populations of its room with the ones returned by newPopulations from
+// the populations it replaces.
+func correct(
+	tx *gorm.DB,
+	correction *db.PopulationCorrection,
+	newPopulations func(roomPopulation *db.RoomPopulation) (uint32, int32),
+) error {
+	roomPopulation := &db.RoomPopulation{}
+	result := tx.Where(&db.RoomPopulation{RoomID: correction.RoomID}).First(roomPopulation)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	correction.OldPopulation = roomPopulation.Population
+	correction.OldNetPopulation = roomPopulation.NetPopulation
+	newPopulation, newNetPopulation := newPopulations(roomPopulation)
+	correction.NewPopulation = newPopulation
+	if err := tx.Create(correction).Error; err != nil {
+		return err
+	}
+
+	if correction.Skipped {
+		slog.Info("skipped population correction", "room.ID", correction.RoomID, "reason", correction.Reason)
+		return nil
+	}
+
+	result = tx.Model(roomPopulation).Updates(map[string]any{
+		"population":     correction.NewPopulation,
+		"net_population": newNetPopulation,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if err := changed(tx, correction.RoomID, nil); err != nil {
+		return err
+	}
+
+	slog.Info("corrected population",
+		"room.ID",
+		correction.RoomID,
+		"oldPopulation",
+		correction.OldPopulation,
+		"newPopulation",
+		correction.NewPopulation,
+		"reason",
+		correction.Reason,
+	)
+
+	return nil
+}
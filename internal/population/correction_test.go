@@ -0,0 +1,131 @@
+package population
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+)
+
+func TestCorrect(t *testing.T) {
+	if err := db.Init(filepath.Join(t.TempDir(), "rewired.db")); err != nil {
+		t.Fatalf("db.Init() error = %v", err)
+	}
+
+	room := &db.Room{Name: "room"}
+	if err := db.Get().Create(room).Error; err != nil {
+		t.Fatalf("failed to create room: %v", err)
+	}
+	roomPopulation := &db.RoomPopulation{RoomID: room.ID}
+	if err := db.Get().Create(roomPopulation).Error; err != nil {
+		t.Fatalf("failed to create room population: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		population    uint32
+		netPopulation int32
+		set           *uint32
+		delta         int64
+		expected      uint32
+		expectedNet   int32
+	}{
+		{
+			name:          "Set",
+			population:    4,
+			netPopulation: 2,
+			set:           new(uint32),
+			expected:      0,
+		},
+		{
+			name:          "Adjust Up",
+			population:    4,
+			netPopulation: 4,
+			delta:         3,
+			expected:      7,
+			expectedNet:   7,
+		},
+		{
+			name:          "Adjust Down",
+			population:    4,
+			netPopulation: 4,
+			delta:         -3,
+			expected:      1,
+			expectedNet:   1,
+		},
+		{
+			// the net population keeps the decrements that are lost to the population, as a pass would
+			name:          "Adjust Below Zero",
+			population:    2,
+			netPopulation: -1,
+			delta:         -3,
+			expected:      0,
+			expectedNet:   -4,
+		},
+		{
+			name:          "Adjust With Drift",
+			population:    2,
+			netPopulation: -1,
+			delta:         3,
+			expected:      5,
+			expectedNet:   2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := db.Get().Model(roomPopulation).Updates(map[string]any{
+				"population":     tt.population,
+				"net_population": tt.netPopulation,
+			})
+			if result.Error != nil {
+				t.Fatalf("failed to set room population: %v", result.Error)
+			}
+
+			correction := &db.PopulationCorrection{RoomID: room.ID, Source: 2, Reason: "recount"}
+			var err error
+			if tt.set != nil {
+				correction.NewPopulation = *tt.set
+				err = Correct(correction)
+			} else {
+				err = Adjust(correction, tt.delta)
+			}
+			if err != nil {
+				t.Fatalf("correction error = %v", err)
+			}
+
+			if correction.OldPopulation != tt.population || correction.OldNetPopulation != tt.netPopulation {
+				t.Errorf("correction old populations = %d, %d, expected %d, %d",
+					correction.OldPopulation,
+					correction.OldNetPopulation,
+					tt.population,
+					tt.netPopulation,
+				)
+			}
+			if correction.NewPopulation != tt.expected {
+				t.Errorf("correction.NewPopulation = %d, expected %d", correction.NewPopulation, tt.expected)
+			}
+
+			stored := &db.RoomPopulation{}
+			if err := db.Get().Where(&db.RoomPopulation{RoomID: room.ID}).First(stored).Error; err != nil {
+				t.Fatalf("failed to find room population: %v", err)
+			}
+			if stored.Population != tt.expected || stored.NetPopulation != tt.expectedNet {
+				t.Errorf("room populations = %d, %d, expected %d, %d",
+					stored.Population,
+					stored.NetPopulation,
+					tt.expected,
+					tt.expectedNet,
+				)
+			}
+
+			snapshot := &db.OccupancySnapshot{}
+			if err := db.Get().Where(&db.OccupancySnapshot{RoomID: room.ID}).Last(snapshot).Error; err != nil {
+				t.Fatalf("failed to find occupancy snapshot: %v", err)
+			}
+			if snapshot.Population != tt.expected {
+				t.Errorf("snapshot.Population = %d, expected %d", snapshot.Population, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package population
+
+import (
+	"expvar"
+	"log/slog"
+	"strconv"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+)
+
+// the drifts are read from the database whenever they are published, so they follow the populations however they are
+// changed
+func init() {
+	expvar.Publish("population_drifts", expvar.Func(func() any {
+		drifts, err := Drifts()
+		if err != nil {
+			slog.Error("failed to find the population drifts", "error", err)
+		}
+		return drifts
+	}))
+}
+
+// Drifts returns the drift of the population of every room by room ID, which is how many decrements of the room were
+// lost while it was empty.
+func Drifts() (map[string]int64, error) {
+	drifts := make(map[string]int64)
+	if db.Get() == nil {
+		return drifts, nil
+	}
+
+	roomPopulations := []db.RoomPopulation{}
+	result := db.Get().Find(&roomPopulations)
+	if result.Error != nil {
+		return drifts, result.Error
+	}
+
+	for _, roomPopulation := range roomPopulations {
+		drifts[strconv.FormatUint(uint64(roomPopulation.RoomID), 10)] = roomPopulation.Drift()
+	}
+
+	return drifts, nil
+}
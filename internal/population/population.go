@@ -1,68 +1,173 @@
 package population
 
 import (
-	"log/slog"
+	"fmt"
+	"time"
 
+	"github.com/kKar1503/rewired-server-2024/internal/alert"
 	"github.com/kKar1503/rewired-server-2024/internal/db"
+	"gorm.io/gorm"
 )
 
-func IncrementPopulation(gateID uint16) {
+// IncrementPopulation records the manual increment of the door of the gate at the time, as a pass into its inner room.
+// The increment is not counted when an error is returned, such as when the gate is not on a door.
+func IncrementPopulation(gateID uint16, at time.Time) error {
+	door, err := findDoor(gateID)
+	if err != nil {
+		return err
+	}
+
+	// Increment only increment for the room that is inside, thus we will only need to increment the inner room population
+	innerRoomID := door.InnerRoomID()
+	err = Pass(&db.PassEvent{
+		DoorID:     door.ID,
+		ToRoomID:   &innerRoomID,
+		Direction:  1,
+		StartTime:  at,
+		EndTime:    at,
+		Source:     2,
+		Confidence: 1,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to increment the population of room %d: %w", innerRoomID, err)
+	}
+
+	return nil
+}
+
+// DecrementPopulation records the manual decrement of the door of the gate at the time, as a pass out of its inner room.
+// The decrement is not counted when an error is returned, such as when the gate is not on a door.
+func DecrementPopulation(gateID uint16, at time.Time) error {
+	door, err := findDoor(gateID)
+	if err != nil {
+		return err
+	}
+
+	// Decrement only decrement for the room that is inside, thus we will only need to decrement the inner room population
+	innerRoomID := door.InnerRoomID()
+	err = Pass(&db.PassEvent{
+		DoorID:     door.ID,
+		FromRoomID: &innerRoomID,
+		Direction:  2,
+		StartTime:  at,
+		EndTime:    at,
+		Source:     2,
+		Confidence: 1,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to decrement the population of room %d: %w", innerRoomID, err)
+	}
+
+	return nil
+}
+
+// Pass records the pass event and moves a person from its room to its other room in a single transaction, where the
+// populations are left as they are when the pass is flagged, and alerts on the rooms that cross their capacity.
+func Pass(event *db.PassEvent) error {
+	return db.Get().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(event).Error; err != nil {
+			return err
+		}
+
+		if event.Flagged {
+			return nil
+		}
+
+		if event.ToRoomID != nil {
+			if err := increment(tx, *event.ToRoomID); err != nil {
+				return err
+			}
+			if err := changed(tx, *event.ToRoomID, event); err != nil {
+				return err
+			}
+		}
+
+		if event.FromRoomID != nil {
+			if err := decrement(tx, *event.FromRoomID, event); err != nil {
+				return err
+			}
+			return changed(tx, *event.FromRoomID, event)
+		}
+
+		return nil
+	})
+}
+
+func findDoor(gateID uint16) (*db.Door, error) {
 	device := &db.Device{}
 	result := db.Get().Where(&db.Device{GateID: gateID}).First(device)
 	if result.Error != nil {
-		slog.Error("failed to find the device", "error", result.Error, "gateID", gateID)
-		return
+		return nil, fmt.Errorf("failed to find the device of gate %d: %w", gateID, result.Error)
 	}
 
-	devicePair := &db.DevicePair{}
-	result = db.Get().
-		Where(&db.DevicePair{InnerGateID: device.ID}).
-		Or(&db.DevicePair{OuterGateID: device.ID}).
-		Joins("InnerRoom.RoomPopulation").
-		First(devicePair)
+	doorGate := &db.DoorGate{}
+	result = db.Get().Where(&db.DoorGate{DeviceID: device.ID}).First(doorGate)
 	if result.Error != nil {
-		slog.Error("failed to find the device pair", "error", result.Error, "device.ID", device.ID)
-		return
+		return nil, fmt.Errorf("failed to find the door gate of device %d: %w", device.ID, result.Error)
 	}
 
-	// Increment only increment for the room that is inside, thus we will only need to increment the inner room population
-	devicePair.InnerRoom.RoomPopulation.Population += 1
-	result = db.Get().Save(&devicePair.InnerRoom.RoomPopulation)
+	door := &db.Door{}
+	result = db.Get().Scopes(db.PreloadDoor).First(door, doorGate.DoorID)
 	if result.Error != nil {
-		slog.Error("failed to increment the room population", "error", result.Error, "room.ID", devicePair.InnerRoomID)
-		return
+		return nil, fmt.Errorf("failed to find door %d: %w", doorGate.DoorID, result.Error)
 	}
+
+	return door, nil
 }
 
-func DecrementPopulation(gateID uint16) {
-	device := &db.Device{}
-	result := db.Get().Where(&db.Device{GateID: gateID}).First(device)
+// increment increments the population of the room in the database itself, so concurrent updates are not lost.
+func increment(tx *gorm.DB, roomID uint) error {
+	result := tx.Model(&db.RoomPopulation{}).
+		Where(&db.RoomPopulation{RoomID: roomID}).
+		Updates(map[string]any{
+			"population":     gorm.Expr("population + 1"),
+			"net_population": gorm.Expr("net_population + 1"),
+		})
 	if result.Error != nil {
-		slog.Error("failed to find the device", "error", result.Error, "gateID", gateID)
-		return
+		return result.Error
 	}
 
-	devicePair := &db.DevicePair{}
-	result = db.Get().
-		Where(&db.DevicePair{InnerGateID: device.ID}).
-		Or(&db.DevicePair{OuterGateID: device.ID}).
-		Joins("InnerRoom.RoomPopulation").
-		First(devicePair)
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// decrement decrements the net population of the room in the database itself, and the population unless it is already
+// 0, raising an alert when the net population of the room goes below 0 from the pass event.
+func decrement(tx *gorm.DB, roomID uint, event *db.PassEvent) error {
+	result := tx.Model(&db.RoomPopulation{}).
+		Where(&db.RoomPopulation{RoomID: roomID}).
+		Updates(map[string]any{
+			// don't decrement when the number is 0
+			"population":     gorm.Expr("CASE WHEN population > 0 THEN population - 1 ELSE 0 END"),
+			"net_population": gorm.Expr("net_population - 1"),
+		})
 	if result.Error != nil {
-		slog.Error("failed to find the device pair", "error", result.Error, "device.ID", device.ID)
-		return
+		return result.Error
 	}
 
-	// Decrement only decrement for the room that is inside, thus we will only need to decrement the inner room population
-	if devicePair.InnerRoom.RoomPopulation.Population == 0 {
-		// if 0, no-op
-		return
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
 	}
 
-	devicePair.InnerRoom.RoomPopulation.Population -= 1
-	result = db.Get().Save(&devicePair.InnerRoom.RoomPopulation)
+	roomPopulation := &db.RoomPopulation{}
+	result = tx.Where(&db.RoomPopulation{RoomID: roomID}).First(roomPopulation)
 	if result.Error != nil {
-		slog.Error("failed to decrement the room population", "error", result.Error, "room.ID", devicePair.InnerRoomID)
-		return
+		return result.Error
+	}
+
+	// the room is only alerted on once it goes below 0, rather than on every decrement while it is below 0
+	if roomPopulation.NetPopulation != -1 {
+		return nil
 	}
+
+	return alert.Raise(tx, &db.Alert{
+		AlertType:   uint8(alert.TypeNegativePopulation),
+		RoomID:      roomID,
+		DoorID:      &event.DoorID,
+		PassEventID: &event.ID,
+		Population:  roomPopulation.NetPopulation,
+	})
 }
@@ -0,0 +1,262 @@
+package population
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+	"gorm.io/gorm"
+)
+
+var ErrRebuildConflict = errors.New("population changed since it was rebuilt")
+
+// RoomDiff is the population of a room as it is stored, and as it is rebuilt from the event log.
+type RoomDiff struct {
+	RoomID    uint
+	Name      string
+	Stored    uint32
+	StoredNet int32
+	Rebuilt   uint32
+}
+
+// Changed reports whether the rebuilt population is different from the stored population, or from the stored net
+// population, which is replaced by the rebuilt population as well.
+func (d *RoomDiff) Changed() bool {
+	return d.Stored != d.Rebuilt || int64(d.StoredNet) != int64(d.Rebuilt)
+}
+
+// A change of the population of a room at a time.
+type change struct {
+	roomID uint
+	delta  int64
+	// the population is replaced with the delta rather than changed by it, as by a correction
+	absolute bool
+	at       time.Time
+}
+
+// Rebuild recomputes the population of every room from the event log between since and until, starting every room empty
+// at since, such as at the last reset. The zero since rebuilds from the start of the event log, and the zero until up to
+// now.
+//
+// The event log is the pass events that were not flagged, and the increment and decrement device logs from before the
+// first pass event, as the manual presses were only logged by the devices until then. A room that was corrected in the
+// window starts over from the population of its last correction.
+func Rebuild(since, until time.Time) ([]RoomDiff, error) {
+	if until.IsZero() {
+		until = time.Now()
+	}
+
+	changes, err := passEventChanges(since, until)
+	if err != nil {
+		return nil, err
+	}
+
+	deviceLogChanges, err := deviceLogChanges(since, until)
+	if err != nil {
+		return nil, err
+	}
+	changes = append(changes, deviceLogChanges...)
+
+	correctionChanges, err := correctionChanges(since, until)
+	if err != nil {
+		return nil, err
+	}
+	changes = append(changes, correctionChanges...)
+
+	// the changes are applied in the order they happened, as a decrement of an empty room is lost
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].at.Before(changes[j].at) })
+
+	populations := make(map[uint]int64)
+	for _, c := range changes {
+		if c.absolute {
+			populations[c.roomID] = c.delta
+			continue
+		}
+		populations[c.roomID] = max(populations[c.roomID]+c.delta, 0)
+	}
+
+	rooms := []db.Room{}
+	result := db.Get().Preload("RoomPopulation").Order("id").Find(&rooms)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	diffs := make([]RoomDiff, 0, len(rooms))
+	for _, room := range rooms {
+		diffs = append(diffs, RoomDiff{
+			RoomID:    room.ID,
+			Name:      room.Name,
+			Stored:    room.RoomPopulation.Population,
+			StoredNet: room.RoomPopulation.NetPopulation,
+			Rebuilt:   uint32(populations[room.ID]),
+		})
+	}
+
+	return diffs, nil
+}
+
+// ApplyRebuild replaces the stored population of every room whose rebuilt population is different, in a single
+// transaction, where the net population of the room is replaced as well, so the room starts over without drift.
+//
+// Every room that is replaced is recorded as a rebuild correction on behalf of the user, which is nil when the rebuild
+// is not made by a user, such as from the command line.
+//
+// Nothing is replaced and ErrRebuildConflict is returned when the stored population or net population of a room changed
+// since it was rebuilt, such as by a pass in the meantime, as the rebuilt population would lose the change.
+func ApplyRebuild(diffs []RoomDiff, userID *uint) error {
+	return db.Get().Transaction(func(tx *gorm.DB) error {
+		for _, diff := range diffs {
+			if !diff.Changed() {
+				continue
+			}
+
+			result := tx.Model(&db.RoomPopulation{}).
+				Where("room_id = ? AND population = ? AND net_population = ?", diff.RoomID, diff.Stored, diff.StoredNet).
+				Updates(map[string]any{"population": diff.Rebuilt, "net_population": diff.Rebuilt})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return ErrRebuildConflict
+			}
+
+			correction := &db.PopulationCorrection{
+				RoomID:           diff.RoomID,
+				Source:           3,
+				UserID:           userID,
+				OldPopulation:    diff.Stored,
+				OldNetPopulation: diff.StoredNet,
+				NewPopulation:    diff.Rebuilt,
+				Reason:           "rebuilt from the event log",
+			}
+			if err := tx.Create(correction).Error; err != nil {
+				return err
+			}
+
+			if err := changed(tx, diff.RoomID, nil); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func passEventChanges(since, until time.Time) ([]change, error) {
+	passEvents := []db.PassEvent{}
+	result := db.Get().
+		Where("flagged = ?", false).
+		Where("end_time >= ? AND end_time < ?", since, until).
+		Order("end_time").
+		Order("id").
+		Find(&passEvents)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	changes := []change{}
+	for _, passEvent := range passEvents {
+		if passEvent.ToRoomID != nil {
+			changes = append(changes, change{roomID: *passEvent.ToRoomID, delta: 1, at: passEvent.EndTime})
+		}
+		if passEvent.FromRoomID != nil {
+			changes = append(changes, change{roomID: *passEvent.FromRoomID, delta: -1, at: passEvent.EndTime})
+		}
+	}
+
+	return changes, nil
+}
+
+// deviceLogChanges returns the changes of the increment and decrement device logs from before the first pass event,
+// which change the inner room of the door of their device.
+//
+// The device logs are timed by when their packets were received, as they are inserted in batches after, or long after
+// when replayed.
+func deviceLogChanges(since, until time.Time) ([]change, error) {
+	first := &db.PassEvent{}
+	result := db.Get().Unscoped().Order("end_time").Limit(1).Find(first)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected > 0 && first.EndTime.Before(until) {
+		until = first.EndTime
+	}
+
+	// the logs from before the receive time was recorded were inserted as they were received
+	const receivedAt = "COALESCE(received_at, created_at)"
+
+	deviceLogs := []db.DeviceLog{}
+	result = db.Get().
+		Where("log_type IN ?", []int{3, 4}).
+		Where(receivedAt+" >= ? AND "+receivedAt+" < ?", since, until).
+		Order(receivedAt).
+		Order("id").
+		Find(&deviceLogs)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if len(deviceLogs) == 0 {
+		return nil, nil
+	}
+
+	doors := []db.Door{}
+	result = db.Get().Scopes(db.PreloadDoor).Find(&doors)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	innerRoomIDs := make(map[uint]uint)
+	for _, door := range doors {
+		for _, gate := range door.Gates {
+			innerRoomIDs[gate.DeviceID] = door.InnerRoomID()
+		}
+	}
+
+	changes := []change{}
+	for _, deviceLog := range deviceLogs {
+		roomID, ok := innerRoomIDs[deviceLog.DeviceID]
+		if !ok {
+			continue
+		}
+
+		delta := int64(1)
+		if deviceLog.LogType == 4 {
+			delta = -1
+		}
+
+		at := deviceLog.CreatedAt
+		if deviceLog.ReceivedAt != nil {
+			at = *deviceLog.ReceivedAt
+		}
+		changes = append(changes, change{roomID: roomID, delta: delta, at: at})
+	}
+
+	return changes, nil
+}
+
+// correctionChanges returns the corrections that were not skipped, which replace the population of their room.
+func correctionChanges(since, until time.Time) ([]change, error) {
+	corrections := []db.PopulationCorrection{}
+	result := db.Get().
+		Where("skipped = ?", false).
+		Where("created_at >= ? AND created_at < ?", since, until).
+		Order("created_at").
+		Order("id").
+		Find(&corrections)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	changes := make([]change, 0, len(corrections))
+	for _, correction := range corrections {
+		changes = append(changes, change{
+			roomID:   correction.RoomID,
+			delta:    int64(correction.NewPopulation),
+			absolute: true,
+			at:       correction.CreatedAt,
+		})
+	}
+
+	return changes, nil
+}
@@ -0,0 +1,226 @@
+package population
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+	"gorm.io/gorm"
+)
+
+func TestRebuild(t *testing.T) {
+	if err := db.Init(filepath.Join(t.TempDir(), "rewired.db")); err != nil {
+		t.Fatalf("db.Init() error = %v", err)
+	}
+
+	now := time.Now()
+	innerRoom := &db.Room{Name: "room"}
+	outerRoom := &db.Room{Name: "corridor"}
+	err := db.Get().Transaction(func(tx *gorm.DB) error {
+		gate := &db.Device{GateID: 1}
+		for _, v := range []any{gate, innerRoom, outerRoom} {
+			if err := tx.Create(v).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Create(&db.RoomPopulation{RoomID: innerRoom.ID, Population: 5}).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&db.RoomPopulation{RoomID: outerRoom.ID}).Error; err != nil {
+			return err
+		}
+
+		door := &db.Door{
+			Gates: []db.DoorGate{{Position: 0, DeviceID: gate.ID}},
+			Rooms: []db.DoorRoom{{Position: 0, RoomID: outerRoom.ID}, {Position: 1, RoomID: innerRoom.ID}},
+		}
+		if err := tx.Create(door).Error; err != nil {
+			return err
+		}
+
+		// the manual presses from before the pass events were recorded, which are only in the device logs, where the ones
+		// with a receive time were inserted now, as if replayed
+		receivedAt := now.Add(-2 * time.Hour)
+		legacy := &db.DeviceLog{DeviceID: gate.ID, LogType: 3}
+		legacy.CreatedAt = receivedAt
+		deviceLogs := []*db.DeviceLog{
+			legacy,
+			{DeviceID: gate.ID, LogType: 3, ReceivedAt: &receivedAt},
+			{DeviceID: gate.ID, LogType: 4, ReceivedAt: &receivedAt},
+		}
+		if err := tx.Create(deviceLogs).Error; err != nil {
+			return err
+		}
+
+		return tx.Create([]*db.PassEvent{
+			{DoorID: door.ID, FromRoomID: &innerRoom.ID, ToRoomID: &outerRoom.ID, EndTime: now.Add(-30 * time.Minute)},
+			{DoorID: door.ID, FromRoomID: &outerRoom.ID, ToRoomID: &innerRoom.ID, EndTime: now.Add(-20 * time.Minute), Flagged: true},
+		}).Error
+	})
+	if err != nil {
+		t.Fatalf("failed to seed database: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		since time.Time
+		inner uint32
+		outer uint32
+	}{
+		{
+			name:  "From The Start",
+			inner: 0,
+			outer: 1,
+		},
+		{
+			// the inner room is empty at since, so the person leaving it is lost as it would have been
+			name:  "Since An Hour Ago",
+			since: now.Add(-time.Hour),
+			inner: 0,
+			outer: 1,
+		},
+		{
+			name:  "Since The Last Pass",
+			since: now.Add(-25 * time.Minute),
+			inner: 0,
+			outer: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diffs, err := Rebuild(tt.since, time.Time{})
+			if err != nil {
+				t.Fatalf("Rebuild() error = %v", err)
+			}
+
+			expected := map[uint]RoomDiff{
+				innerRoom.ID: {RoomID: innerRoom.ID, Name: "room", Stored: 5, Rebuilt: tt.inner},
+				outerRoom.ID: {RoomID: outerRoom.ID, Name: "corridor", Stored: 0, Rebuilt: tt.outer},
+			}
+			if len(diffs) != len(expected) {
+				t.Fatalf("Rebuild() = %+v, expected %+v", diffs, expected)
+			}
+			for _, diff := range diffs {
+				if diff != expected[diff.RoomID] {
+					t.Errorf("Rebuild() = %+v, expected %+v", diff, expected[diff.RoomID])
+				}
+			}
+		})
+	}
+
+	t.Run("Since A Correction", func(t *testing.T) {
+		corrected := &db.PopulationCorrection{RoomID: outerRoom.ID, Source: 2, NewPopulation: 4}
+		corrected.CreatedAt = now.Add(-10 * time.Minute)
+		skipped := &db.PopulationCorrection{RoomID: outerRoom.ID, Source: 1, NewPopulation: 9, Skipped: true}
+		skipped.CreatedAt = now.Add(-5 * time.Minute)
+		if err := db.Get().Create([]*db.PopulationCorrection{corrected, skipped}).Error; err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+
+		diffs, err := Rebuild(now.Add(-time.Hour), time.Time{})
+		if err != nil {
+			t.Fatalf("Rebuild() error = %v", err)
+		}
+
+		for _, diff := range diffs {
+			if diff.RoomID == outerRoom.ID && diff.Rebuilt != 4 {
+				t.Errorf("outer room rebuilt = %d, expected 4", diff.Rebuilt)
+			}
+		}
+	})
+
+	t.Run("Apply", func(t *testing.T) {
+		// the device logs are in the window until the first pass event, when the person entered the room
+		diffs, err := Rebuild(time.Time{}, now.Add(-time.Hour))
+		if err != nil {
+			t.Fatalf("Rebuild() error = %v", err)
+		}
+
+		if err := ApplyRebuild(diffs, nil); err != nil {
+			t.Fatalf("ApplyRebuild() error = %v", err)
+		}
+
+		roomPopulation := &db.RoomPopulation{}
+		if err := db.Get().Where(&db.RoomPopulation{RoomID: innerRoom.ID}).First(roomPopulation).Error; err != nil {
+			t.Fatalf("failed to find room population: %v", err)
+		}
+		if roomPopulation.Population != 1 {
+			t.Errorf("inner room population = %d, expected 1", roomPopulation.Population)
+		}
+
+		// only the inner room changed, so only it is recorded
+		corrections := []db.PopulationCorrection{}
+		if err := db.Get().Where(&db.PopulationCorrection{Source: 3}).Find(&corrections).Error; err != nil {
+			t.Fatalf("failed to find corrections: %v", err)
+		}
+		if len(corrections) != 1 {
+			t.Fatalf("corrections = %+v, expected 1 rebuild correction", corrections)
+		}
+		correction := corrections[0]
+		if correction.RoomID != innerRoom.ID || correction.OldPopulation != 5 || correction.NewPopulation != 1 {
+			t.Errorf("correction = %+v, expected the rebuild of the inner room from 5 to 1", correction)
+		}
+	})
+
+	t.Run("Conflict", func(t *testing.T) {
+		diffs, err := Rebuild(now.Add(-time.Hour), time.Time{})
+		if err != nil {
+			t.Fatalf("Rebuild() error = %v", err)
+		}
+
+		// a pass into the outer room after it was rebuilt
+		err = db.Get().Model(&db.RoomPopulation{}).
+			Where(&db.RoomPopulation{RoomID: outerRoom.ID}).
+			Updates(map[string]any{"population": 1, "net_population": 1}).Error
+		if err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+
+		if err := ApplyRebuild(diffs, nil); !errors.Is(err, ErrRebuildConflict) {
+			t.Fatalf("ApplyRebuild() error = %v, expected %v", err, ErrRebuildConflict)
+		}
+
+		roomPopulation := &db.RoomPopulation{}
+		if err := db.Get().Where(&db.RoomPopulation{RoomID: outerRoom.ID}).First(roomPopulation).Error; err != nil {
+			t.Fatalf("failed to find room population: %v", err)
+		}
+		if roomPopulation.Population != 1 {
+			t.Errorf("outer room population = %d, expected 1", roomPopulation.Population)
+		}
+	})
+
+	t.Run("Apply Net Population Only", func(t *testing.T) {
+		// the population of the outer room is already the rebuilt one, but it drifted from its net population
+		err := db.Get().Model(&db.RoomPopulation{}).
+			Where(&db.RoomPopulation{RoomID: outerRoom.ID}).
+			Updates(map[string]any{"population": 4, "net_population": 2}).Error
+		if err != nil {
+			t.Fatalf("Updates() error = %v", err)
+		}
+
+		diffs, err := Rebuild(now.Add(-time.Hour), time.Time{})
+		if err != nil {
+			t.Fatalf("Rebuild() error = %v", err)
+		}
+		for _, diff := range diffs {
+			if diff.RoomID == outerRoom.ID && !diff.Changed() {
+				t.Errorf("Changed() = %v, expected %v", diff.Changed(), true)
+			}
+		}
+
+		if err := ApplyRebuild(diffs, nil); err != nil {
+			t.Fatalf("ApplyRebuild() error = %v", err)
+		}
+
+		roomPopulation := &db.RoomPopulation{}
+		if err := db.Get().Where(&db.RoomPopulation{RoomID: outerRoom.ID}).First(roomPopulation).Error; err != nil {
+			t.Fatalf("failed to find room population: %v", err)
+		}
+		if roomPopulation.NetPopulation != 4 {
+			t.Errorf("outer room net population = %d, expected 4", roomPopulation.NetPopulation)
+		}
+	})
+}
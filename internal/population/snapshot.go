@@ -0,0 +1,39 @@
+package population
+
+import (
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+	"gorm.io/gorm"
+)
+
+// changed records the snapshot of the population of the room for the occupancy history, and checks its capacity, after
+// its population was changed within the transaction by the pass event, which is nil when the population was not changed
+// by a pass.
+func changed(tx *gorm.DB, roomID uint, event *db.PassEvent) error {
+	room, err := findRoom(tx, roomID)
+	if err != nil {
+		return err
+	}
+
+	snapshot := &db.OccupancySnapshot{
+		RoomID:        roomID,
+		Population:    room.RoomPopulation.Population,
+		NetPopulation: room.RoomPopulation.NetPopulation,
+		// the time the population changed in the database, rather than the time of the pass, so the snapshots are in
+		// the order the populations were changed; it is before the transaction commits, which the history compaction
+		// allows for
+		RecordedAt: time.Now(),
+	}
+	if err := tx.Create(snapshot).Error; err != nil {
+		return err
+	}
+
+	return checkCapacity(tx, room, event)
+}
+
+func findRoom(tx *gorm.DB, roomID uint) (*db.Room, error) {
+	room := &db.Room{}
+	result := tx.Preload("RoomPopulation").First(room, roomID)
+	return room, result.Error
+}
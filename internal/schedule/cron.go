@@ -0,0 +1,191 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidExpression = errors.New("invalid cron expression")
+
+// The aliases of the common expressions.
+var aliases = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// The furthest Next looks ahead for a matching time, as an expression such as 30 February never matches.
+const MAX_LOOKAHEAD = 5 * 366 * 24 * time.Hour
+
+// Expression is a cron expression of the minute, hour, day of month, month and day of week, such as "0 3 * * *" for
+// every day at 03:00. Every field is a * or a list of values, ranges and steps, such as "1-5", "*/15" or "0,30", and the
+// day of week is 0 to 7, where both 0 and 7 are Sunday.
+//
+// As in cron, a time matches the days when either the day of month or the day of week matches, unless one of them is a
+// *, in which case only the other has to match.
+type Expression struct {
+	minutes  uint64
+	hours    uint64
+	days     uint64
+	months   uint64
+	weekdays uint64
+	// whether the day of month or the day of week is a *
+	anyDay     bool
+	anyWeekday bool
+}
+
+type field struct {
+	name     string
+	min, max int
+}
+
+var fields = [5]field{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+func Parse(expression string) (*Expression, error) {
+	if alias, ok := aliases[strings.TrimSpace(expression)]; ok {
+		expression = alias
+	}
+
+	parts := strings.Fields(expression)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("%w: expected %d fields, got %d", ErrInvalidExpression, len(fields), len(parts))
+	}
+
+	sets := [len(fields)]uint64{}
+	for i, part := range parts {
+		set, err := parseField(part, fields[i])
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = set
+	}
+
+	// both 0 and 7 are Sunday
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+
+	return &Expression{
+		minutes:    sets[0],
+		hours:      sets[1],
+		days:       sets[2],
+		months:     sets[3],
+		weekdays:   sets[4],
+		anyDay:     parts[2] == "*",
+		anyWeekday: parts[4] == "*",
+	}, nil
+}
+
+// parseField returns the set of the values of the field as bits.
+func parseField(part string, f field) (uint64, error) {
+	var set uint64
+
+	for _, item := range strings.Split(part, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(item, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("%w: invalid step %q of the %s", ErrInvalidExpression, stepPart, f.name)
+			}
+		}
+
+		from, to := f.min, f.max
+		if rangePart != "*" {
+			fromPart, toPart, isRange := strings.Cut(rangePart, "-")
+
+			var err error
+			from, err = strconv.Atoi(fromPart)
+			if err != nil {
+				return 0, fmt.Errorf("%w: invalid value %q of the %s", ErrInvalidExpression, fromPart, f.name)
+			}
+
+			to = from
+			if isRange {
+				to, err = strconv.Atoi(toPart)
+				if err != nil {
+					return 0, fmt.Errorf("%w: invalid value %q of the %s", ErrInvalidExpression, toPart, f.name)
+				}
+			} else if hasStep {
+				// a single value with a step, such as 5/15, runs from the value to the end of the field
+				to = f.max
+			}
+		}
+
+		if from < f.min || to > f.max || from > to {
+			return 0, fmt.Errorf("%w: %q is out of the range %d-%d of the %s",
+				ErrInvalidExpression,
+				item,
+				f.min,
+				f.max,
+				f.name,
+			)
+		}
+
+		for value := from; value <= to; value += step {
+			set |= 1 << value
+		}
+	}
+
+	return set, nil
+}
+
+// Next returns the first time after the time that matches the expression, in the location of the time, or the zero time
+// when the expression never matches.
+func (e *Expression) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	end := after.Add(MAX_LOOKAHEAD)
+
+	for t.Before(end) {
+		if e.months&(1<<int(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !e.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if e.hours&(1<<t.Hour()) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if e.minutes&(1<<t.Minute()) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	return time.Time{}
+}
+
+func (e *Expression) matchDay(t time.Time) bool {
+	day := e.days&(1<<t.Day()) != 0
+	weekday := e.weekdays&(1<<int(t.Weekday())) != 0
+
+	switch {
+	case e.anyDay && e.anyWeekday:
+		return true
+	case e.anyDay:
+		return weekday
+	case e.anyWeekday:
+		return day
+	default:
+		return day || weekday
+	}
+}
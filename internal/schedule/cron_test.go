@@ -0,0 +1,106 @@
+package schedule
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		wantErr    bool
+	}{
+		{name: "Every Day", expression: "0 3 * * *"},
+		{name: "Lists Ranges And Steps", expression: "*/15 8-18 1,15 * 1-5"},
+		{name: "Sunday As 7", expression: "0 0 * * 7"},
+		{name: "Alias", expression: "@daily"},
+		{name: "Too Few Fields", expression: "0 3 * *", wantErr: true},
+		{name: "Out Of Range", expression: "60 3 * * *", wantErr: true},
+		{name: "Reversed Range", expression: "0 18-8 * * *", wantErr: true},
+		{name: "Zero Step", expression: "*/0 * * * *", wantErr: true},
+		{name: "Not A Number", expression: "0 three * * *", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.expression)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidExpression) {
+				t.Errorf("Parse() error = %v, expected %v", err, ErrInvalidExpression)
+			}
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+	// Tuesday 28 May 2024 13:45:30
+	after := time.Date(2024, time.May, 28, 13, 45, 30, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		expression string
+		expected   time.Time
+	}{
+		{
+			name:       "Later Today",
+			expression: "0 15 * * *",
+			expected:   time.Date(2024, time.May, 28, 15, 0, 0, 0, time.UTC),
+		},
+		{
+			name:       "Tomorrow",
+			expression: "0 3 * * *",
+			expected:   time.Date(2024, time.May, 29, 3, 0, 0, 0, time.UTC),
+		},
+		{
+			name:       "Next Minute",
+			expression: "* * * * *",
+			expected:   time.Date(2024, time.May, 28, 13, 46, 0, 0, time.UTC),
+		},
+		{
+			name:       "Step",
+			expression: "*/20 * * * *",
+			expected:   time.Date(2024, time.May, 28, 14, 0, 0, 0, time.UTC),
+		},
+		{
+			name:       "Day Of Week",
+			expression: "0 0 * * 0",
+			expected:   time.Date(2024, time.June, 2, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:       "Day Of Month Or Day Of Week",
+			expression: "0 0 1 * 4",
+			expected:   time.Date(2024, time.May, 30, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:       "Next Year",
+			expression: "0 0 1 1 *",
+			expected:   time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:       "Leap Day",
+			expression: "0 0 29 2 *",
+			expected:   time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:       "Never",
+			expression: "0 0 30 2 *",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expression, err := Parse(tt.expression)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+
+			if result := expression.Next(after); !result.Equal(tt.expected) {
+				t.Errorf("Next() = %v, expected %v", result, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+	"github.com/kKar1503/rewired-server-2024/internal/population"
+	"gorm.io/gorm"
+)
+
+// The interval the schedules are checked at, which is how late a reset can run after its time.
+const CHECK_INTERVAL = 15 * time.Second
+
+// Run resets the populations of the rooms on their schedules, until the context is done.
+func Run(ctx context.Context) {
+	ticker := time.NewTicker(CHECK_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("exiting population schedules")
+			return
+		case now := <-ticker.C:
+			if err := RunDue(now); err != nil {
+				slog.Error("failed to run the population schedules", "error", err)
+			}
+		}
+	}
+}
+
+// RunDue resets the population of the room of every schedule whose next time since it last ran, or since it was
+// created, is at or before now. A schedule that missed several of its times, such as while the server was down, only
+// resets once.
+func RunDue(now time.Time) error {
+	schedules := []db.PopulationSchedule{}
+	result := db.Get().Order("id").Find(&schedules)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	var errs error
+	for i := range schedules {
+		schedule := &schedules[i]
+
+		expression, err := Parse(schedule.Expression)
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("schedule %d: %w", schedule.ID, err))
+			continue
+		}
+
+		last := schedule.CreatedAt
+		if schedule.LastRunAt != nil {
+			last = *schedule.LastRunAt
+		}
+		next := expression.Next(last.In(time.Local))
+		if next.IsZero() || next.After(now) {
+			continue
+		}
+
+		if err := reset(schedule, now); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("schedule %d: %w", schedule.ID, err))
+		}
+	}
+
+	return errs
+}
+
+// reset resets the population of the room of the schedule, unless the schedule skips the room while it is occupied by
+// its occupancy calendar, and marks the schedule as run at now either way.
+func reset(schedule *db.PopulationSchedule, now time.Time) error {
+	correction := &db.PopulationCorrection{
+		RoomID:        schedule.RoomID,
+		Source:        1,
+		ScheduleID:    &schedule.ID,
+		NewPopulation: schedule.Population,
+		Reason:        fmt.Sprintf("scheduled reset %q", schedule.Expression),
+	}
+
+	if schedule.SkipWhileOccupied {
+		occupancy, ok, err := Occupancy(schedule.RoomID, now)
+		if err != nil {
+			return err
+		}
+		if ok {
+			correction.Skipped = true
+			correction.Reason = fmt.Sprintf("scheduled reset %q skipped while occupied by %q",
+				schedule.Expression,
+				occupancy.Name,
+			)
+		}
+	}
+
+	// the schedule is marked as run together with the correction, so a reset is neither repeated nor lost when either fails
+	return db.Get().Transaction(func(tx *gorm.DB) error {
+		if err := population.CorrectTx(tx, correction); err != nil {
+			return err
+		}
+
+		return tx.Model(schedule).Update("last_run_at", now).Error
+	})
+}
+
+// Occupancy returns the entry of the occupancy calendar of the room at the time, and whether the room is occupied.
+func Occupancy(roomID uint, at time.Time) (*db.RoomOccupancy, bool, error) {
+	occupancies := []db.RoomOccupancy{}
+	result := db.Get().
+		Where(&db.RoomOccupancy{RoomID: roomID}).
+		Where("start_time <= ? AND end_time > ?", at, at).
+		Order("start_time").
+		Limit(1).
+		Find(&occupancies)
+	if result.Error != nil {
+		return nil, false, result.Error
+	}
+
+	if len(occupancies) == 0 {
+		return nil, false, nil
+	}
+	return &occupancies[0], true, nil
+}
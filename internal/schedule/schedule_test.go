@@ -0,0 +1,128 @@
+package schedule
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+	"gorm.io/gorm"
+)
+
+func TestRunDue(t *testing.T) {
+	// the schedules were created at 02:00 and reset the room every day at 03:00
+	created := time.Date(2024, time.May, 28, 2, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name              string
+		now               time.Time
+		skipWhileOccupied bool
+		occupied          bool
+		expected          uint32
+		skipped           bool
+	}{
+		{
+			name:     "Not Due",
+			now:      created.Add(59 * time.Minute),
+			expected: 5,
+		},
+		{
+			name:     "Due",
+			now:      created.Add(time.Hour),
+			expected: 0,
+		},
+		{
+			name:     "Occupied Without Skip",
+			now:      created.Add(time.Hour),
+			occupied: true,
+			expected: 0,
+		},
+		{
+			name:              "Skipped While Occupied",
+			now:               created.Add(time.Hour),
+			skipWhileOccupied: true,
+			occupied:          true,
+			expected:          5,
+			skipped:           true,
+		},
+		{
+			name:              "Not Occupied",
+			now:               created.Add(time.Hour),
+			skipWhileOccupied: true,
+			expected:          0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := db.Init(filepath.Join(t.TempDir(), "rewired.db")); err != nil {
+				t.Fatalf("db.Init() error = %v", err)
+			}
+
+			room := &db.Room{Name: "room"}
+			populationSchedule := &db.PopulationSchedule{Expression: "0 3 * * *", SkipWhileOccupied: tt.skipWhileOccupied}
+			err := db.Get().Transaction(func(tx *gorm.DB) error {
+				if err := tx.Create(room).Error; err != nil {
+					return err
+				}
+				if err := tx.Create(&db.RoomPopulation{RoomID: room.ID, Population: 5, NetPopulation: 3}).Error; err != nil {
+					return err
+				}
+
+				populationSchedule.RoomID = room.ID
+				populationSchedule.CreatedAt = created
+				if err := tx.Create(populationSchedule).Error; err != nil {
+					return err
+				}
+
+				if !tt.occupied {
+					return nil
+				}
+				return tx.Create(&db.RoomOccupancy{
+					RoomID:    room.ID,
+					Name:      "night shift",
+					StartTime: created,
+					EndTime:   created.Add(4 * time.Hour),
+				}).Error
+			})
+			if err != nil {
+				t.Fatalf("failed to seed database: %v", err)
+			}
+
+			// the schedule only runs once for its time
+			for i := 0; i < 2; i++ {
+				if err := RunDue(tt.now); err != nil {
+					t.Fatalf("RunDue() error = %v", err)
+				}
+			}
+
+			roomPopulation := &db.RoomPopulation{}
+			if err := db.Get().Where(&db.RoomPopulation{RoomID: room.ID}).First(roomPopulation).Error; err != nil {
+				t.Fatalf("failed to find room population: %v", err)
+			}
+			if roomPopulation.Population != tt.expected {
+				t.Errorf("population = %d, expected %d", roomPopulation.Population, tt.expected)
+			}
+
+			corrections := []db.PopulationCorrection{}
+			if err := db.Get().Find(&corrections).Error; err != nil {
+				t.Fatalf("failed to find corrections: %v", err)
+			}
+			if tt.now.Before(created.Add(time.Hour)) {
+				if len(corrections) != 0 {
+					t.Errorf("corrections = %+v, expected none", corrections)
+				}
+				return
+			}
+			if len(corrections) != 1 {
+				t.Fatalf("corrections = %+v, expected 1", corrections)
+			}
+
+			correction := corrections[0]
+			if correction.Skipped != tt.skipped || correction.OldPopulation != 5 || correction.OldNetPopulation != 3 ||
+				*correction.ScheduleID != populationSchedule.ID || correction.Source != 1 {
+				t.Errorf("correction = %+v, expected the scheduled reset from 5", correction)
+			}
+		})
+	}
+}
@@ -1,9 +1,38 @@
 package settings
 
+import "time"
+
 type Settings struct {
-	TCPPort uint
-	WSPort  uint
-	Origins string
+	DBPath         string
+	TCPPort        uint
+	UDPPort        uint // the udp server is disabled when 0
+	WSPort         uint
+	Origins        string
+	CommandTimeout time.Duration
+	// The number of packet workers, the depth of each of their queues and the policy when a queue is full.
+	Workers     uint
+	QueueDepth  uint
+	QueuePolicy string
+	// The number of device logs inserted in a batch, and the interval the device logs are inserted at when the batch is
+	// not full.
+	LogBatchSize     uint
+	LogFlushInterval time.Duration
+	// The default timing of the doors, for the doors without their own timing.
+	AllowanceFrame time.Duration
+	PassFrame      time.Duration
+	// The version of the door pass detection.
+	DoorPass string
+	// The clock skew of a gate beyond which the receive time of its packets is used instead of their trigger time.
+	MaxClockSkew time.Duration
+	// The path of the file every received packet is captured into; packets are not captured when empty.
+	CapturePath string
+	// Whether devices without a secret are allowed to send unauthenticated packets.
+	AllowLegacyDevices bool
+	// The TLS certificate and key of the TCP server; the server is plaintext when they are empty.
+	TLSCert string
+	TLSKey  string
+	// The certificate authorities of the device client certificates; mutual TLS is not required when empty.
+	TLSClientCA string
 }
 
 func init() {
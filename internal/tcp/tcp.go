@@ -1,17 +1,27 @@
 package tcp
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net"
 	"sync"
+	"time"
 
+	"github.com/kKar1503/rewired-server-2024/internal/downlink"
 	"github.com/kKar1503/rewired-server-2024/internal/packet"
 )
 
-const MAX_PACKET_LENGTH = 64
+const (
+	MAX_PACKET_LENGTH = 64
+	WRITE_TIMEOUT     = 5 * time.Second
+	HANDSHAKE_TIMEOUT = 10 * time.Second
+)
 
 type TCP struct {
 	listener      net.Listener
@@ -20,12 +30,20 @@ type TCP struct {
 	wg            sync.WaitGroup
 }
 
-func NewTCPServer(port uint16, packetsEgress chan<- *packet.RawPacket) (*TCP, error) {
+// NewTCPServer creates the TCP server listening on the port, serving TLS when the tlsConfig is not nil.
+//
+// When the tlsConfig requires client certificates (see LoadTLSConfig), the gate ID of every packet must match the
+// gate ID of the client certificate of the connection, otherwise the packet is dropped.
+func NewTCPServer(port uint16, tlsConfig *tls.Config, packetsEgress chan<- *packet.RawPacket) (*TCP, error) {
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		return nil, err
 	}
 
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
 	return &TCP{
 		listener:      listener,
 		packetsEgress: packetsEgress,
@@ -34,6 +52,10 @@ func NewTCPServer(port uint16, packetsEgress chan<- *packet.RawPacket) (*TCP, er
 	}, nil
 }
 
+func (t *TCP) Addr() net.Addr {
+	return t.listener.Addr()
+}
+
 func (t *TCP) Close() {
 	close(t.quit)
 	t.listener.Close()
@@ -52,6 +74,7 @@ func (t *TCP) Start() {
 				return
 			default:
 				slog.Error("server error:", "error", err)
+				continue
 			}
 		}
 
@@ -63,10 +86,21 @@ func (t *TCP) Start() {
 func (t *TCP) readConnection(conn net.Conn) {
 	defer t.wg.Done()
 	defer conn.Close()
+
+	certGateID, verified, err := handshake(conn)
+	if err != nil {
+		slog.Error("failed tls handshake", "error", err, "remoteAddr", conn.RemoteAddr().String())
+		return
+	}
+
+	deviceConn := &deviceConn{Conn: conn, certGateID: certGateID, verified: verified}
+	defer deviceConn.unregister()
+
+	reader := bufio.NewReader(conn)
 	for {
 		rawPacket := &packet.RawPacket{}
 
-		err := rawPacket.ReadPackets(conn)
+		err := rawPacket.ReadPackets(reader)
 		if err != nil {
 			if errors.Is(err, io.EOF) {
 				slog.Debug("socket received EOF", "error", err)
@@ -75,6 +109,22 @@ func (t *TCP) readConnection(conn net.Conn) {
 			}
 			break
 		}
+		rawPacket.RemoteAddr = conn.RemoteAddr().String()
+		rawPacket.ReceivedAt = time.Now()
+		rawPacket.Source = deviceConn
+
+		if verified {
+			packetGateID, err := rawPacket.GateID()
+			if err != nil || packetGateID != certGateID {
+				slog.Warn("dropped packet with gateID not matching the client certificate",
+					"gateID",
+					packetGateID,
+					"certGateID",
+					certGateID,
+				)
+				continue
+			}
+		}
 
 		if t.packetsEgress != nil {
 			t.packetsEgress <- rawPacket
@@ -83,3 +133,95 @@ func (t *TCP) readConnection(conn net.Conn) {
 
 	slog.Debug("finished reading from connection")
 }
+
+// handshake completes the TLS handshake of the connection, returning the gate ID of the client certificate when the
+// client presented a verified certificate.
+func handshake(conn net.Conn) (uint16, bool, error) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return 0, false, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), HANDSHAKE_TIMEOUT)
+	defer cancel()
+
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return 0, false, err
+	}
+
+	state := tlsConn.ConnectionState()
+	if len(state.VerifiedChains) == 0 {
+		return 0, false, nil
+	}
+
+	gateID, err := GateIDFromCertificate(state.VerifiedChains[0][0])
+	if err != nil {
+		return 0, false, err
+	}
+
+	return gateID, true, nil
+}
+
+// The connection of a device, which serialises the writes of acks and downlink commands.
+type deviceConn struct {
+	net.Conn
+	writeMu sync.Mutex
+
+	certGateID uint16
+	verified   bool
+
+	// the downlink registration of the connection, which is made once a packet from it passed authentication
+	registerMu sync.Mutex
+	registered bool
+	closed     bool
+	gateID     uint16
+}
+
+// Authenticated registers the connection for downlink as the connection of the gate, so that commands are only sent
+// down to a connection that proved to be the device, rather than one that only claimed its gate ID.
+//
+// The connection is registered once, with the gate of the client certificate when it presented one.
+func (c *deviceConn) Authenticated(gateID uint16) {
+	c.registerMu.Lock()
+	defer c.registerMu.Unlock()
+
+	// the packets are authenticated by the workers, which may happen after the connection was closed
+	if c.registered || c.closed {
+		return
+	}
+
+	if c.verified {
+		gateID = c.certGateID
+	}
+
+	c.registered = true
+	c.gateID = gateID
+	downlink.Register(gateID, c)
+}
+
+func (c *deviceConn) unregister() {
+	c.registerMu.Lock()
+	defer c.registerMu.Unlock()
+
+	c.closed = true
+	if c.registered {
+		downlink.Unregister(c.gateID, c)
+	}
+}
+
+func (c *deviceConn) WritePacket(p encoding.BinaryMarshaler) error {
+	data, err := p.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if err := c.SetWriteDeadline(time.Now().Add(WRITE_TIMEOUT)); err != nil {
+		return err
+	}
+
+	_, err = c.Write(data)
+	return err
+}
@@ -0,0 +1,428 @@
+package tcp
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+	"github.com/kKar1503/rewired-server-2024/internal/downlink"
+	"github.com/kKar1503/rewired-server-2024/internal/packet"
+)
+
+type certificateAuthority struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	pem         []byte
+}
+
+func newCertificateAuthority(t *testing.T) *certificateAuthority {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "rewired test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate() error = %v", err)
+	}
+
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseCertificate() error = %v", err)
+	}
+
+	return &certificateAuthority{
+		certificate: certificate,
+		key:         key,
+		pem:         pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// issue creates a certificate signed by the certificate authority, returning the certificate and key in PEM.
+func (ca *certificateAuthority) issue(t *testing.T, template *x509.Certificate) ([]byte, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatalf("rand.Int() error = %v", err)
+	}
+
+	template.SerialNumber = serialNumber
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.certificate, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("CreateCertificate() error = %v", err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey() error = %v", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func writeFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	return path
+}
+
+// startServer starts the TCP server with the tls config on a random port, closing it when the test finishes.
+func startServer(t *testing.T, tlsConfig *tls.Config) (*TCP, <-chan *packet.RawPacket) {
+	t.Helper()
+
+	if err := db.Init(filepath.Join(t.TempDir(), "rewired.db")); err != nil {
+		t.Fatalf("db.Init() error = %v", err)
+	}
+
+	packets := make(chan *packet.RawPacket, 10)
+	server, err := NewTCPServer(0, tlsConfig, packets)
+	if err != nil {
+		t.Fatalf("NewTCPServer() error = %v", err)
+	}
+
+	go server.Start()
+	t.Cleanup(server.Close)
+
+	return server, packets
+}
+
+func heartbeat(t *testing.T, gateID uint16) []byte {
+	t.Helper()
+
+	heartbeatPacket := &packet.HeartbeatPacket{
+		RawPacket: packet.RawPacket{Version: packet.VERSION_2, PacketType: packet.PacketTypeHeartbeat},
+	}
+	heartbeatPacket.SetGateID(gateID)
+
+	data, err := heartbeatPacket.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary() error = %v", err)
+	}
+
+	return data
+}
+
+func receiveGateID(t *testing.T, packets <-chan *packet.RawPacket) uint16 {
+	t.Helper()
+
+	select {
+	case rawPacket := <-packets:
+		gateID, err := rawPacket.GateID()
+		if err != nil {
+			t.Fatalf("GateID() error = %v", err)
+		}
+		return gateID
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for packet")
+		return 0
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newCertificateAuthority(t)
+
+	serverCert, serverKey := ca.issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "rewired test server"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+
+	tlsConfig, err := LoadTLSConfig(
+		writeFile(t, "server.crt", serverCert),
+		writeFile(t, "server.key", serverKey),
+		writeFile(t, "ca.crt", ca.pem),
+	)
+	if err != nil {
+		t.Fatalf("LoadTLSConfig() error = %v", err)
+	}
+
+	server, packets := startServer(t, tlsConfig)
+
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(ca.certificate)
+
+	dial := func(t *testing.T, clientCert, clientKey []byte) *tls.Conn {
+		t.Helper()
+
+		clientConfig := &tls.Config{RootCAs: rootCAs, ServerName: "127.0.0.1"}
+		if clientCert != nil {
+			certificate, err := tls.X509KeyPair(clientCert, clientKey)
+			if err != nil {
+				t.Fatalf("X509KeyPair() error = %v", err)
+			}
+			clientConfig.Certificates = []tls.Certificate{certificate}
+		}
+
+		conn, err := tls.Dial("tcp", server.Addr().String(), clientConfig)
+		if err != nil {
+			t.Fatalf("tls.Dial() error = %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+
+		return conn
+	}
+
+	t.Run("Matching Gate ID", func(t *testing.T) {
+		clientCert, clientKey := ca.issue(t, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: "gate-7"},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		})
+		conn := dial(t, clientCert, clientKey)
+
+		for _, gateID := range []uint16{7, 8, 7} {
+			if _, err := conn.Write(heartbeat(t, gateID)); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+		}
+
+		// the packet claiming to be from gate 8 is dropped, so both received packets are from gate 7
+		for i := 0; i < 2; i++ {
+			if gateID := receiveGateID(t, packets); gateID != 7 {
+				t.Errorf("received gateID = %d, expected 7", gateID)
+			}
+		}
+	})
+
+	t.Run("Gate ID In Subject Alternative Name", func(t *testing.T) {
+		clientCert, clientKey := ca.issue(t, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: "device"},
+			DNSNames:    []string{"gate-9"},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		})
+		conn := dial(t, clientCert, clientKey)
+
+		if _, err := conn.Write(heartbeat(t, 9)); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+
+		if gateID := receiveGateID(t, packets); gateID != 9 {
+			t.Errorf("received gateID = %d, expected 9", gateID)
+		}
+	})
+
+	t.Run("Missing Client Certificate", func(t *testing.T) {
+		conn := dial(t, nil, nil)
+
+		// the server rejects the handshake, which the client only observes when reading with TLS 1.3
+		conn.Write(heartbeat(t, 7))
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Read(make([]byte, 1)); err == nil {
+			t.Errorf("Read() error = nil, expected handshake failure")
+		}
+
+		select {
+		case rawPacket := <-packets:
+			t.Errorf("received packet %v, expected none", rawPacket)
+		default:
+		}
+	})
+}
+
+func TestTLSWithoutClientCertificate(t *testing.T) {
+	ca := newCertificateAuthority(t)
+
+	serverCert, serverKey := ca.issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "rewired test server"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+
+	tlsConfig, err := LoadTLSConfig(writeFile(t, "server.crt", serverCert), writeFile(t, "server.key", serverKey), "")
+	if err != nil {
+		t.Fatalf("LoadTLSConfig() error = %v", err)
+	}
+
+	server, packets := startServer(t, tlsConfig)
+
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(ca.certificate)
+
+	conn, err := tls.Dial("tcp", server.Addr().String(), &tls.Config{RootCAs: rootCAs, ServerName: "127.0.0.1"})
+	if err != nil {
+		t.Fatalf("tls.Dial() error = %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write(heartbeat(t, 8)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	if gateID := receiveGateID(t, packets); gateID != 8 {
+		t.Errorf("received gateID = %d, expected 8", gateID)
+	}
+}
+
+func TestRegisterAfterAuthentication(t *testing.T) {
+	server, packets := startServer(t, nil)
+
+	if err := db.Get().Create(&db.Device{GateID: 5}).Error; err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial() error = %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write(heartbeat(t, 5)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	var rawPacket *packet.RawPacket
+	select {
+	case rawPacket = <-packets:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for packet")
+	}
+
+	// the connection only claimed the gate ID so far, so the command must not be sent down to it
+	command, err := downlink.Send(5, packet.PacketTypeIdentify, 0)
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if downlink.CommandStatus(command.Status) != downlink.CommandStatusQueued {
+		t.Fatalf("Status = %v, expected %v", downlink.CommandStatus(command.Status), downlink.CommandStatusQueued)
+	}
+
+	rawPacket.Source.Authenticated(5)
+
+	// the queued command is delivered once the connection is registered
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("SetReadDeadline() error = %v", err)
+	}
+
+	received := &packet.RawPacket{}
+	if err := received.ReadPackets(bufio.NewReader(conn)); err != nil {
+		t.Fatalf("ReadPackets() error = %v", err)
+	}
+
+	commandPacket := &packet.CommandPacket{}
+	if err := commandPacket.Parse(received); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if commandPacket.PacketType != packet.PacketTypeIdentify || commandPacket.Sequence != command.Sequence {
+		t.Errorf("received command %v with sequence %d, expected %v with sequence %d",
+			commandPacket.PacketType,
+			commandPacket.Sequence,
+			packet.PacketTypeIdentify,
+			command.Sequence,
+		)
+	}
+}
+
+func TestLoadTLSConfigRequiresCertificate(t *testing.T) {
+	ca := newCertificateAuthority(t)
+	serverCert, serverKey := ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "rewired test server"}})
+
+	certFile := writeFile(t, "server.crt", serverCert)
+	keyFile := writeFile(t, "server.key", serverKey)
+	clientCAFile := writeFile(t, "ca.crt", ca.pem)
+
+	tests := []struct {
+		name         string
+		certFile     string
+		keyFile      string
+		clientCAFile string
+		wantErr      error
+	}{
+		{"Certificate And Key", certFile, keyFile, "", nil},
+		{"Mutual TLS", certFile, keyFile, clientCAFile, nil},
+		{"Client CA Only", "", "", clientCAFile, ErrNoCertificate},
+		{"Client CA Without Key", certFile, "", clientCAFile, ErrNoCertificate},
+		{"Key Only", "", keyFile, "", ErrNoCertificate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadTLSConfig(tt.certFile, tt.keyFile, tt.clientCAFile)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("LoadTLSConfig() error = %v, expected %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGateIDFromCertificate(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    *x509.Certificate
+		expected uint16
+		wantErr  bool
+	}{
+		{
+			name:     "Common Name",
+			input:    &x509.Certificate{Subject: pkix.Name{CommonName: "gate-42"}},
+			expected: 42,
+		},
+		{
+			name:     "DNS Name",
+			input:    &x509.Certificate{Subject: pkix.Name{CommonName: "device"}, DNSNames: []string{"other", "gate-65535"}},
+			expected: 65535,
+		},
+		{
+			name:    "Out Of Range",
+			input:   &x509.Certificate{Subject: pkix.Name{CommonName: "gate-65536"}},
+			wantErr: true,
+		},
+		{
+			name:    "Missing",
+			input:   &x509.Certificate{Subject: pkix.Name{CommonName: "device"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := GateIDFromCertificate(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GateIDFromCertificate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if result != tt.expected {
+				t.Errorf("GateIDFromCertificate() = %v, expected %v", result, tt.expected)
+			}
+		})
+	}
+}
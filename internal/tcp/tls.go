@@ -0,0 +1,79 @@
+package tcp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrNoCertificate         = errors.New("tls requires both the certificate and the key")
+	ErrInvalidClientCA       = errors.New("no certificates found in client ca file")
+	ErrNoGateIDInCertificate = errors.New("no gate id found in the certificate common name or subject alternative names")
+)
+
+// The prefix of the certificate common name or DNS subject alternative name that identifies the gate, e.g. gate-42.
+const CERTIFICATE_GATE_PREFIX = "gate-"
+
+// LoadTLSConfig loads the server certificate and key for the TCP server.
+//
+// When the clientCAFile is provided, the devices are required to present a client certificate signed by one of its
+// certificate authorities, with its common name or one of its DNS subject alternative names in the form of gate-<ID>.
+func LoadTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, ErrNoCertificate
+	}
+
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if clientCAFile == "" {
+		return tlsConfig, nil
+	}
+
+	clientCAs, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	clientCAPool := x509.NewCertPool()
+	if !clientCAPool.AppendCertsFromPEM(clientCAs) {
+		return nil, ErrInvalidClientCA
+	}
+
+	tlsConfig.ClientCAs = clientCAPool
+	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+
+	return tlsConfig, nil
+}
+
+// GateIDFromCertificate reads the gate ID from the common name, or the DNS subject alternative names of the
+// certificate, in the form of gate-<ID>.
+func GateIDFromCertificate(certificate *x509.Certificate) (uint16, error) {
+	names := append([]string{certificate.Subject.CommonName}, certificate.DNSNames...)
+
+	for _, name := range names {
+		if !strings.HasPrefix(name, CERTIFICATE_GATE_PREFIX) {
+			continue
+		}
+
+		gateID, err := strconv.ParseUint(strings.TrimPrefix(name, CERTIFICATE_GATE_PREFIX), 10, 16)
+		if err != nil {
+			continue
+		}
+
+		return uint16(gateID), nil
+	}
+
+	return 0, ErrNoGateIDInCertificate
+}
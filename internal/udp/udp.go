@@ -0,0 +1,183 @@
+package udp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/downlink"
+	"github.com/kKar1503/rewired-server-2024/internal/packet"
+)
+
+// The maximum size of a datagram, which is large enough for a number of packets to be batched in a single datagram.
+const MAX_DATAGRAM_LENGTH = 1024
+
+type UDP struct {
+	conn          *net.UDPConn
+	packetsEgress chan<- *packet.RawPacket
+	quit          chan interface{}
+	wg            sync.WaitGroup
+
+	// the writers of the last source address each gate sent an authenticated packet from
+	writersMu sync.Mutex
+	writers   map[uint16]*datagramWriter
+}
+
+// NewUDPServer creates the UDP server listening on the port.
+//
+// Every datagram is expected to contain one or more packets in the same encoding as the TCP server, which are validated
+// the same way as the packets from the TCP server.
+func NewUDPServer(port uint16, packetsEgress chan<- *packet.RawPacket) (*UDP, error) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: int(port)})
+	if err != nil {
+		return nil, err
+	}
+
+	return &UDP{
+		conn:          conn,
+		packetsEgress: packetsEgress,
+		quit:          make(chan interface{}),
+		wg:            sync.WaitGroup{},
+		writers:       make(map[uint16]*datagramWriter),
+	}, nil
+}
+
+func (u *UDP) Addr() net.Addr {
+	return u.conn.LocalAddr()
+}
+
+func (u *UDP) Close() {
+	close(u.quit)
+	u.conn.Close()
+	u.wg.Wait()
+
+	u.writersMu.Lock()
+	defer u.writersMu.Unlock()
+
+	for gateID, writer := range u.writers {
+		downlink.Unregister(gateID, writer)
+	}
+}
+
+func (u *UDP) Start() {
+	u.wg.Add(1)
+	defer u.wg.Done()
+
+	buf := make([]byte, MAX_DATAGRAM_LENGTH)
+	for {
+		n, addr, err := u.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-u.quit:
+				return
+			default:
+				slog.Error("server error:", "error", err)
+				continue
+			}
+		}
+
+		u.readDatagram(buf[:n], addr)
+	}
+}
+
+func (u *UDP) readDatagram(datagram []byte, addr *net.UDPAddr) {
+	reader := bufio.NewReader(bytes.NewReader(datagram))
+	for {
+		rawPacket := &packet.RawPacket{}
+
+		err := rawPacket.ReadPackets(reader)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				slog.Error("server error:", "error", err, "remoteAddr", addr.String())
+			}
+			break
+		}
+		rawPacket.RemoteAddr = addr.String()
+		rawPacket.ReceivedAt = time.Now()
+		rawPacket.Source = &datagramSource{server: u, addr: addr, signed: rawPacket.IsAuthenticated()}
+
+		if u.packetsEgress != nil {
+			u.packetsEgress <- rawPacket
+		}
+	}
+}
+
+// recordSource records the source address of the gate, registering it for downlink whenever the address changes.
+func (u *UDP) recordSource(gateID uint16, addr *net.UDPAddr) {
+	u.writersMu.Lock()
+	defer u.writersMu.Unlock()
+
+	// the packets are authenticated by the workers, which may happen after the server was closed
+	select {
+	case <-u.quit:
+		return
+	default:
+	}
+
+	writer, ok := u.writers[gateID]
+	if ok && writer.addr.String() == addr.String() {
+		return
+	}
+
+	slog.Info("recorded udp source address", "gateID", gateID, "remoteAddr", addr.String())
+
+	writer = &datagramWriter{conn: u.conn, addr: addr}
+	u.writers[gateID] = writer
+	downlink.Register(gateID, writer)
+}
+
+// The source of the packets of a datagram, which is only recorded as the source address of the gate once a signed
+// packet from it passed authentication, so a spoofed datagram can not redirect the commands of a gate.
+//
+// The unsigned packets of the devices without a secret pass authentication as well, but the source address of a
+// datagram is trivially spoofed, so they are never recorded, and the commands can only be sent to such a device over
+// TCP.
+type datagramSource struct {
+	server *UDP
+	addr   *net.UDPAddr
+	signed bool
+}
+
+func (s *datagramSource) Authenticated(gateID uint16) {
+	if !s.signed {
+		return
+	}
+	s.server.recordSource(gateID, s.addr)
+}
+
+// WritePacket writes the packet to the address the datagram was sent from, regardless of the recorded source address.
+func (s *datagramSource) WritePacket(p encoding.BinaryMarshaler) error {
+	writer := &datagramWriter{conn: s.server.conn, addr: s.addr}
+	return writer.WritePacket(p)
+}
+
+// The writer that sends packets as datagrams to the last source address of a gate.
+type datagramWriter struct {
+	conn *net.UDPConn
+	addr *net.UDPAddr
+}
+
+func (w *datagramWriter) WritePacket(p encoding.BinaryMarshaler) error {
+	data, err := p.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	n, err := w.conn.WriteToUDP(data, w.addr)
+	if err != nil {
+		return err
+	}
+
+	if n != len(data) {
+		return fmt.Errorf("short write of %d out of %d bytes", n, len(data))
+	}
+
+	return nil
+}
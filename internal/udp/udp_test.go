@@ -0,0 +1,213 @@
+package udp
+
+import (
+	"encoding/hex"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+	"github.com/kKar1503/rewired-server-2024/internal/packet"
+	"github.com/kKar1503/rewired-server-2024/internal/packetpass"
+	"github.com/kKar1503/rewired-server-2024/internal/settings"
+)
+
+var secret = []byte("0123456789abcdef")
+
+// startServer starts the UDP server on a random port with a fresh database, closing it when the test finishes.
+func startServer(t *testing.T) (*UDP, <-chan *packet.RawPacket) {
+	t.Helper()
+
+	if err := db.Init(filepath.Join(t.TempDir(), "rewired.db")); err != nil {
+		t.Fatalf("db.Init() error = %v", err)
+	}
+
+	packets := make(chan *packet.RawPacket, 10)
+	server, err := NewUDPServer(0, packets)
+	if err != nil {
+		t.Fatalf("NewUDPServer() error = %v", err)
+	}
+
+	go server.Start()
+	t.Cleanup(server.Close)
+
+	return server, packets
+}
+
+func dial(t *testing.T, server *UDP) *net.UDPConn {
+	t.Helper()
+
+	conn, err := net.DialUDP("udp", nil, server.Addr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("DialUDP() error = %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+// heartbeat encodes a heartbeat of the gate, signed with the secret and counter when the secret is not nil.
+func heartbeat(t *testing.T, version byte, gateID uint16, secret []byte, counter uint32) []byte {
+	t.Helper()
+
+	heartbeatPacket := &packet.HeartbeatPacket{
+		RawPacket: packet.RawPacket{Version: version, PacketType: packet.PacketTypeHeartbeat},
+	}
+	heartbeatPacket.SetGateID(gateID)
+
+	if secret != nil {
+		if err := heartbeatPacket.Sign(secret, counter); err != nil {
+			t.Fatalf("Sign() error = %v", err)
+		}
+	}
+
+	data, err := heartbeatPacket.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary() error = %v", err)
+	}
+
+	return data
+}
+
+func send(t *testing.T, conn *net.UDPConn, datagram []byte) {
+	t.Helper()
+
+	if _, err := conn.Write(datagram); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+}
+
+// receiveAll receives the packets until none is received for a while.
+func receiveAll(packets <-chan *packet.RawPacket) []*packet.RawPacket {
+	received := []*packet.RawPacket{}
+	for {
+		select {
+		case rawPacket := <-packets:
+			received = append(received, rawPacket)
+		case <-time.After(200 * time.Millisecond):
+			return received
+		}
+	}
+}
+
+func TestReadDatagram(t *testing.T) {
+	server, packets := startServer(t)
+	conn := dial(t, server)
+
+	valid := heartbeat(t, packet.VERSION_2, 0x0A0B, nil, 0)
+
+	corrupted := heartbeat(t, packet.VERSION_2, 0x0A0B, nil, 0)
+	corrupted[len(corrupted)-1] ^= 0xFF
+
+	tests := []struct {
+		name     string
+		datagram []byte
+		expected []uint16
+	}{
+		{"Single Packet", valid, []uint16{0x0A0B}},
+		{
+			"Batched Packets",
+			join(heartbeat(t, packet.VERSION_2, 1, nil, 0), heartbeat(t, packet.VERSION_1, 2, nil, 0), valid),
+			[]uint16{1, 2, 0x0A0B},
+		},
+		{"Checksum Mismatched", corrupted, []uint16{}},
+		{"Checksum Mismatched Before Valid Packet", join(corrupted, valid), []uint16{0x0A0B}},
+		{"Truncated Packet", valid[:len(valid)-1], []uint16{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			send(t, conn, tt.datagram)
+
+			received := receiveAll(packets)
+			if len(received) != len(tt.expected) {
+				t.Fatalf("received %d packets, expected %d", len(received), len(tt.expected))
+			}
+
+			for i, rawPacket := range received {
+				gateID, err := rawPacket.GateID()
+				if err != nil {
+					t.Fatalf("GateID() error = %v", err)
+				}
+				if gateID != tt.expected[i] {
+					t.Errorf("received gateID = %X, expected %X", gateID, tt.expected[i])
+				}
+				if rawPacket.RemoteAddr != conn.LocalAddr().String() {
+					t.Errorf("RemoteAddr = %v, expected %v", rawPacket.RemoteAddr, conn.LocalAddr().String())
+				}
+			}
+		})
+	}
+}
+
+func TestRecordSource(t *testing.T) {
+	server, packets := startServer(t)
+	settings.Get().AllowLegacyDevices = true
+
+	if err := db.Get().Create(&db.Device{GateID: 1, Secret: hex.EncodeToString(secret)}).Error; err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := db.Get().Create(&db.Device{GateID: 2}).Error; err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	first := dial(t, server)
+	second := dial(t, server)
+
+	// the packets go through the authentication of the packet pass, which records the source of the packets that pass
+	authenticate := packetpass.Authenticate(packetpass.HandlerFunc(func(p *packetpass.Packet) error { return nil }))
+
+	tests := []struct {
+		name     string
+		gateID   uint16
+		conn     *net.UDPConn
+		datagram []byte
+		rejected bool
+		// the connection expected to be recorded as the source of the gate afterwards, nil when none is
+		source *net.UDPConn
+	}{
+		{"Unsigned", 1, first, heartbeat(t, packet.VERSION_2, 1, nil, 0), true, nil},
+		{"Signed", 1, first, heartbeat(t, packet.VERSION_2, 1, secret, 1), false, first},
+		{"Forged", 1, second, heartbeat(t, packet.VERSION_2, 1, []byte("fedcba9876543210"), 2), true, first},
+		{"Replayed", 1, second, heartbeat(t, packet.VERSION_2, 1, secret, 1), true, first},
+		{"Signed From New Address", 1, second, heartbeat(t, packet.VERSION_2, 1, secret, 2), false, second},
+		// the legacy packet is accepted, but could be spoofed, so it does not register the source
+		{"Legacy", 2, first, heartbeat(t, packet.VERSION_2, 2, nil, 0), false, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			send(t, tt.conn, tt.datagram)
+
+			received := receiveAll(packets)
+			if len(received) != 1 {
+				t.Fatalf("received %d packets, expected 1", len(received))
+			}
+
+			err := authenticate.Handle(&packetpass.Packet{Raw: received[0], GateID: tt.gateID})
+			if (err != nil) != tt.rejected {
+				t.Fatalf("Handle() error = %v, expected rejected %v", err, tt.rejected)
+			}
+
+			server.writersMu.Lock()
+			writer, ok := server.writers[tt.gateID]
+			server.writersMu.Unlock()
+
+			if ok != (tt.source != nil) {
+				t.Fatalf("recorded = %v, expected %v", ok, tt.source != nil)
+			}
+			if ok && writer.addr.String() != tt.source.LocalAddr().String() {
+				t.Errorf("recorded source = %v, expected %v", writer.addr, tt.source.LocalAddr())
+			}
+		})
+	}
+}
+
+func join(datagrams ...[]byte) []byte {
+	joined := []byte{}
+	for _, datagram := range datagrams {
+		joined = append(joined, datagram...)
+	}
+	return joined
+}
@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestCRC16CCITT(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []byte
+		expected uint16
+	}{
+		{
+			name:     "Empty",
+			input:    []byte{},
+			expected: 0xFFFF,
+		},
+		{
+			name:     "Check Value",
+			input:    []byte("123456789"),
+			expected: 0x29B1,
+		},
+		{
+			name:     "Single Zero Byte",
+			input:    []byte{0x00},
+			expected: 0xE1F0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := CRC16CCITT(tt.input)
+			if result != tt.expected {
+				t.Errorf("CRC16CCITT(%X) = %04X, expected %04X", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package zone
+
+import (
+	"errors"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+)
+
+var (
+	ErrUnknownType   = errors.New("unknown zone type")
+	ErrInvalidParent = errors.New("a building has no parent, and a floor is in a building")
+)
+
+// The type of a db.Zone.
+type Type uint8
+
+const (
+	TypeBuilding Type = iota + 1
+	TypeFloor
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeBuilding:
+		return "building"
+	case TypeFloor:
+		return "floor"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseType returns the type of its name.
+func ParseType(name string) (Type, error) {
+	for _, t := range []Type{TypeBuilding, TypeFloor} {
+		if t.String() == name {
+			return t, nil
+		}
+	}
+	return 0, ErrUnknownType
+}
+
+// ValidateParent checks that the zone of the type can be in the parent zone, which is nil when the zone has no parent.
+func ValidateParent(zoneType Type, parent *db.Zone) error {
+	switch zoneType {
+	case TypeBuilding:
+		if parent != nil {
+			return ErrInvalidParent
+		}
+	case TypeFloor:
+		if parent == nil || Type(parent.ZoneType) != TypeBuilding {
+			return ErrInvalidParent
+		}
+	default:
+		return ErrUnknownType
+	}
+	return nil
+}
+
+// Population is the population of a zone, which is the total of the rooms in the zone and in the zones below it.
+type Population struct {
+	Population    uint32
+	NetPopulation int32
+	// the total of the capacities of the rooms that have a capacity
+	Capacity uint32
+	Rooms    int
+}
+
+// Aggregate returns the population of every zone by zone ID, from the rooms, which must have their RoomPopulation loaded.
+func Aggregate(zones []db.Zone, rooms []db.Room) map[uint]*Population {
+	parentIDs := make(map[uint]*uint, len(zones))
+	populations := make(map[uint]*Population, len(zones))
+	for _, zone := range zones {
+		parentIDs[zone.ID] = zone.ParentID
+		populations[zone.ID] = &Population{}
+	}
+
+	for _, room := range rooms {
+		// the depth is bounded by the zones, so a parent that loops back on itself is not followed forever
+		zoneID := room.ZoneID
+		for depth := 0; zoneID != nil && depth < len(zones); depth++ {
+			population, ok := populations[*zoneID]
+			if !ok {
+				break
+			}
+
+			population.Population += room.RoomPopulation.Population
+			population.NetPopulation += room.RoomPopulation.NetPopulation
+			population.Capacity += room.Capacity
+			population.Rooms++
+
+			zoneID = parentIDs[*zoneID]
+		}
+	}
+
+	return populations
+}
+
+// RoomIDs returns the IDs of the rooms in the zone and in the zones below it.
+func RoomIDs(zoneID uint) ([]uint, error) {
+	zoneIDs := []uint{zoneID}
+	for parentIDs := zoneIDs; len(parentIDs) > 0; {
+		childIDs := []uint{}
+		result := db.Get().Model(&db.Zone{}).Where("parent_id IN ?", parentIDs).Pluck("id", &childIDs)
+		if result.Error != nil {
+			return nil, result.Error
+		}
+
+		zoneIDs = append(zoneIDs, childIDs...)
+		parentIDs = childIDs
+	}
+
+	roomIDs := []uint{}
+	result := db.Get().Model(&db.Room{}).Where("zone_id IN ?", zoneIDs).Order("id").Pluck("id", &roomIDs)
+	return roomIDs, result.Error
+}
@@ -0,0 +1,131 @@
+package zone
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/kKar1503/rewired-server-2024/internal/db"
+)
+
+func ptr(id uint) *uint {
+	return &id
+}
+
+func TestAggregate(t *testing.T) {
+	zones := []db.Zone{
+		{Name: "building", ZoneType: uint8(TypeBuilding)},
+		{Name: "level 1", ZoneType: uint8(TypeFloor), ParentID: ptr(1)},
+		{Name: "level 2", ZoneType: uint8(TypeFloor), ParentID: ptr(1)},
+		{Name: "annex", ZoneType: uint8(TypeBuilding)},
+	}
+	for i := range zones {
+		zones[i].ID = uint(i + 1)
+	}
+
+	room := func(zoneID *uint, population uint32, netPopulation int32, capacity uint32) db.Room {
+		return db.Room{
+			ZoneID:         zoneID,
+			Capacity:       capacity,
+			RoomPopulation: db.RoomPopulation{Population: population, NetPopulation: netPopulation},
+		}
+	}
+	rooms := []db.Room{
+		room(ptr(2), 5, 5, 10),
+		room(ptr(2), 3, 1, 0),
+		room(ptr(3), 4, 4, 20),
+		// a room directly in the building, outside of its floors
+		room(ptr(1), 2, 2, 5),
+		room(nil, 7, 7, 10),
+	}
+
+	populations := Aggregate(zones, rooms)
+
+	expected := map[uint]Population{
+		1: {Population: 14, NetPopulation: 12, Capacity: 35, Rooms: 4},
+		2: {Population: 8, NetPopulation: 6, Capacity: 10, Rooms: 2},
+		3: {Population: 4, NetPopulation: 4, Capacity: 20, Rooms: 1},
+		4: {},
+	}
+	if len(populations) != len(expected) {
+		t.Fatalf("Aggregate() = %v, expected %v", populations, expected)
+	}
+	for zoneID, population := range populations {
+		if *population != expected[zoneID] {
+			t.Errorf("Aggregate()[%d] = %+v, expected %+v", zoneID, *population, expected[zoneID])
+		}
+	}
+}
+
+func TestValidateParent(t *testing.T) {
+	building := &db.Zone{ZoneType: uint8(TypeBuilding)}
+	floor := &db.Zone{ZoneType: uint8(TypeFloor)}
+
+	tests := []struct {
+		name     string
+		zoneType Type
+		parent   *db.Zone
+		expected error
+	}{
+		{"Building", TypeBuilding, nil, nil},
+		{"Building In A Building", TypeBuilding, building, ErrInvalidParent},
+		{"Floor In A Building", TypeFloor, building, nil},
+		{"Floor Without A Building", TypeFloor, nil, ErrInvalidParent},
+		{"Floor In A Floor", TypeFloor, floor, ErrInvalidParent},
+		{"Unknown Type", 0, nil, ErrUnknownType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateParent(tt.zoneType, tt.parent); err != tt.expected {
+				t.Errorf("ValidateParent() = %v, expected %v", err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRoomIDs(t *testing.T) {
+	if err := db.Init(filepath.Join(t.TempDir(), "rewired.db")); err != nil {
+		t.Fatalf("db.Init() error = %v", err)
+	}
+
+	building := &db.Zone{Name: "building", ZoneType: uint8(TypeBuilding)}
+	if err := db.Get().Create(building).Error; err != nil {
+		t.Fatalf("failed to create building: %v", err)
+	}
+	floor := &db.Zone{Name: "level 1", ZoneType: uint8(TypeFloor), ParentID: &building.ID}
+	if err := db.Get().Create(floor).Error; err != nil {
+		t.Fatalf("failed to create floor: %v", err)
+	}
+
+	rooms := []*db.Room{{Name: "lobby", ZoneID: &building.ID}, {Name: "room", ZoneID: &floor.ID}, {Name: "outside"}}
+	if err := db.Get().Create(rooms).Error; err != nil {
+		t.Fatalf("failed to create rooms: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		zoneID   uint
+		expected []uint
+	}{
+		{"Building", building.ID, []uint{rooms[0].ID, rooms[1].ID}},
+		{"Floor", floor.ID, []uint{rooms[1].ID}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roomIDs, err := RoomIDs(tt.zoneID)
+			if err != nil {
+				t.Fatalf("RoomIDs() error = %v", err)
+			}
+
+			if len(roomIDs) != len(tt.expected) {
+				t.Fatalf("RoomIDs() = %v, expected %v", roomIDs, tt.expected)
+			}
+			for i := range roomIDs {
+				if roomIDs[i] != tt.expected[i] {
+					t.Errorf("RoomIDs() = %v, expected %v", roomIDs, tt.expected)
+				}
+			}
+		})
+	}
+}